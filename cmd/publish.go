@@ -15,10 +15,9 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
-	"os"
 
-	"github.com/nikogura/gomason/pkg/gomason"
 	"github.com/spf13/cobra"
 )
 
@@ -33,115 +32,30 @@ var publishCmd = &cobra.Command{
 Test, build, sign and publish your code.
 
 Publish will upload your binaries to wherever it is you've configured them to go in whatever way you like.  The detached signatures will likewise be uploaded.
+
+With --dryrun, publish will test and build as usual, but instead of signing and uploading, it prints the plan: every file, where it would go, how it would get there, and which credentials would be used.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		gm, err := gomason.NewGomason()
-		if err != nil {
-			log.Fatalf("error creating gomason object")
+		if dryrun {
+			fmt.Printf("Dry run.  Nothing will be signed or uploaded.\n\n")
 		}
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			log.Fatalf("Failed to get current working directory: %s", err)
-		}
-		rootWorkDir, err := os.MkdirTemp("", "gomason")
-		if err != nil {
-			log.Fatalf("Failed to create temp dir: %s", err)
-		}
-		defer os.RemoveAll(rootWorkDir)
+		opts := pipelineOptions()
+		opts.SkipTests = pubSkipTests
+		opts.Prebuilt = pubSkipBuild
+		opts.Build = true
+		opts.Publish = true
+		opts.DryRun = dryrun
 
-		meta, err := gomason.ReadMetadata(gomason.METADATA_FILENAME)
-		if err != nil {
-			log.Fatalf("failed to read metadata: %s", err)
-		}
+		p := newPipeline(opts)
 
-		lang, err := gomason.GetByName(meta.GetLanguage())
-		if err != nil {
-			log.Fatalf("Invalid language: %v", err)
-		}
+		p.Options.Sign = !p.Meta.PublishInfo.SkipSigning
 
-		workDir, err := lang.CreateWorkDir(rootWorkDir)
-		if err != nil {
-			log.Fatalf("Failed to create ephemeral working directory: %s", err)
+		if !p.Options.Sign {
+			log.Printf("[DEBUG] Skipping signing due to 'skip-signing': true in metadata file")
 		}
 
-		// Totally skip building, and just do signing and uploading
-		if pubSkipBuild {
-			workDir, err = os.Getwd()
-			if err != nil {
-				log.Fatalf("Failed getting current working directory.")
-			}
-
-			for _, t := range meta.PublishInfo.Targets {
-				if meta.PublishInfo.SkipSigning {
-					err = gm.PublishFile(meta, t.Source)
-					if err != nil {
-						log.Fatalf("Failed to publish %s: %s", t.Source, err)
-					}
-
-				} else {
-					err = gm.SignBinary(meta, t.Source)
-					if err != nil {
-						log.Fatalf("Failed to sign %s: %s", t.Source, err)
-					}
-
-					err = gm.PublishFile(meta, t.Source)
-					if err != nil {
-						log.Fatalf("Failed to publish %s: %s", t.Source, err)
-					}
-				}
-			}
-		} else {
-			if !local {
-				err = lang.Checkout(workDir, meta, branch)
-				if err != nil {
-					log.Fatalf("failed to checkout package %s at branch %s: %s", meta.Package, branch, err)
-				}
-
-			}
-
-			err = lang.Prep(workDir, meta, local)
-			if err != nil {
-				log.Fatalf("error running prep steps: %s", err)
-			}
-
-			if !pubSkipTests {
-				err = lang.Test(workDir, meta.Package, testTimeout, local)
-				if err != nil {
-					log.Fatalf("error running go test: %s", err)
-				}
-
-			}
-
-			err = lang.Build(workDir, meta, buildSkipTargets, local)
-			if err != nil {
-				log.Fatalf("build failed: %s", err)
-			}
-
-			if meta.PublishInfo.SkipSigning {
-				log.Printf("[DEBUG] Skipping signing due to 'skip-signing': true in metadata file")
-				err = gm.HandleArtifacts(meta, workDir, cwd, false, true, false, buildSkipTargets, local)
-				if err != nil {
-					log.Fatalf("post-build processing failed: %s", err)
-				}
-
-				err = gm.HandleExtras(meta, workDir, cwd, false, true, false, local)
-				if err != nil {
-					log.Fatalf("Extra artifact processing failed: %s", err)
-				}
-
-			} else {
-				err = gm.HandleArtifacts(meta, workDir, cwd, true, true, false, buildSkipTargets, local)
-				if err != nil {
-					log.Fatalf("post-build processing failed: %s", err)
-				}
-
-				err = gm.HandleExtras(meta, workDir, cwd, true, true, false, local)
-				if err != nil {
-					log.Fatalf("Extra artifact processing failed: %s", err)
-				}
-			}
-		}
+		runPipeline(p)
 	},
 }
 
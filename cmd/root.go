@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"github.com/nikogura/gomason/pkg/gomason"
 	"github.com/sirupsen/logrus"
+	"log"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -31,6 +32,9 @@ var workdir string
 var buildSkipTargets string
 var testTimeout string
 var local bool
+var committed bool
+var keepOnFailure bool
+var parallelism int
 
 //var pubSkipTargets string
 
@@ -59,12 +63,63 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "Verbose output")
-	rootCmd.PersistentFlags().BoolVarP(&dryrun, "dryrun", "d", false, "Dry Run (Only applies to publish.")
-	rootCmd.PersistentFlags().StringVarP(&branch, "branch", "b", "", "Branch to operate upon")
-	rootCmd.PersistentFlags().StringVarP(&workdir, "workdir", "w", "", "Workdir.  If omitted, a temp dir will be created and subsequently cleaned up.")
+	rootCmd.PersistentFlags().BoolVarP(&dryrun, "dryrun", "d", false, "Dry Run.  Print what publish would sign and upload without doing it. (Only applies to publish.)")
+	rootCmd.PersistentFlags().StringVarP(&branch, "branch", "b", "", "Branch, tag, commit or other git ref to operate upon")
+	rootCmd.PersistentFlags().StringVarP(&workdir, "workdir", "w", "", "Workdir.  If omitted, a temp dir will be created and subsequently cleaned up.  If set, the workdir is kept, and reused on subsequent runs.")
+	rootCmd.PersistentFlags().BoolVarP(&keepOnFailure, "keep-on-failure", "k", false, "Keep the temp workdir, and print its location, if anything fails.")
 	rootCmd.PersistentFlags().StringVarP(&buildSkipTargets, "skip-build-targets", "", "", fmt.Sprintf("Comma separated list of build targets from %s to skip.", gomason.METADATA_FILENAME))
+	rootCmd.PersistentFlags().IntVarP(&parallelism, "parallelism", "p", 0, fmt.Sprintf("Number of build targets to build at once.  Overrides 'building.parallelism' in %s.  Defaults to the number of CPUs.", gomason.METADATA_FILENAME))
 	rootCmd.PersistentFlags().StringVarP(&testTimeout, "test-timeout", "", "", "timeout for tests to complete (must be valid time input for language)")
 
 	rootCmd.PersistentFlags().BoolVarP(&local, "local", "l", false, "Do all work out of current working directory, with whatever is checked out.")
+	rootCmd.PersistentFlags().BoolVarP(&committed, "committed", "c", false, "Check out what's committed in the local git repository, at --branch or HEAD, into the workdir rather than fetching from the remote.  Uncommitted changes are left out.")
 	//rootCmd.PersistentFlags().StringVarP(&pubSkipTargets, fmt.Sprintf("skip-publish-targets", "", "", "Comma separated list of publish targets from %s to skip.", gomason.METADATA_FILENAME))
 }
+
+// pipelineOptions returns pipeline options set from the persistent flags common to all commands.
+func pipelineOptions() (opts gomason.PipelineOptions) {
+	opts = gomason.PipelineOptions{
+		Branch:        branch,
+		Local:         local,
+		Committed:     committed,
+		WorkDir:       workdir,
+		KeepOnFailure: keepOnFailure,
+		SkipTargets:   buildSkipTargets,
+		TestTimeout:   testTimeout,
+		Parallelism:   parallelism,
+	}
+
+	return opts
+}
+
+// newPipeline reads the metadata file and sets up a pipeline with the given options.
+func newPipeline(opts gomason.PipelineOptions) (p *gomason.Pipeline) {
+	gm, err := gomason.NewGomason()
+	if err != nil {
+		log.Fatalf("error creating gomason object: %s", err)
+	}
+
+	meta, err := gomason.ReadMetadata(gomason.METADATA_FILENAME)
+	if err != nil {
+		log.Fatalf("failed to read metadata: %s", err)
+	}
+
+	p, err = gomason.NewPipeline(gm, meta, opts)
+	if err != nil {
+		log.Fatalf("failed to set up pipeline: %s", err)
+	}
+
+	return p
+}
+
+// runPipeline runs the pipeline, and exits non-zero if it fails, saying where to find the workspace if it was kept.
+func runPipeline(p *gomason.Pipeline) {
+	err := p.Run()
+	if err != nil {
+		if p.Kept {
+			log.Printf("Workspace kept at %s", p.Workspace.Dir)
+		}
+
+		log.Fatalf("%s", err)
+	}
+}
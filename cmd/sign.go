@@ -15,9 +15,7 @@
 package cmd
 
 import (
-	"io/ioutil"
 	"log"
-	"os"
 
 	"github.com/nikogura/gomason/pkg/gomason"
 	"github.com/spf13/cobra"
@@ -35,68 +33,22 @@ Artists sign their work, you should too.
 Signing sorta implies something to sign, which in turn, implies that it built, which means it tested successfully.  What I'm getting at is this command will run 'test', 'build', and then it will 'sign'.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		gm, err := gomason.NewGomason()
-		if err != nil {
-			log.Fatalf("error creating gomason object")
-		}
+		opts := pipelineOptions()
+		opts.Build = true
+		opts.Sign = true
+		opts.Collect = true
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			log.Fatalf("Failed to get current working directory: %s", err)
-		}
-		rootWorkDir, err := ioutil.TempDir("", "gomason")
-		if err != nil {
-			log.Fatalf("Failed to create temp dir: %s", err)
-		}
+		p := newPipeline(opts)
 
-		defer os.RemoveAll(rootWorkDir)
+		p.AfterStage = append(p.AfterStage, func(p *gomason.Pipeline, stage string, stageErr error) error {
+			if stage == gomason.StageTest && stageErr == nil {
+				log.Printf("Tests Succeeded!\n\n")
+			}
 
-		meta, err := gomason.ReadMetadata(gomason.METADATA_FILENAME)
-		if err != nil {
-			log.Fatalf("failed to read metadata: %s", err)
-		}
+			return nil
+		})
 
-		lang, err := gomason.GetByName(meta.GetLanguage())
-		if err != nil {
-			log.Fatalf("Invalid language: %v", err)
-		}
-
-		workDir, err := lang.CreateWorkDir(rootWorkDir)
-		if err != nil {
-			log.Fatalf("Failed to create ephemeral workDir: %s", err)
-		}
-
-		err = lang.Checkout(workDir, meta, branch)
-		if err != nil {
-			log.Fatalf("failed to checkout package %s at branch %s: %s", meta.Package, branch, err)
-		}
-
-		err = lang.Prep(workDir, meta, local)
-		if err != nil {
-			log.Fatalf("error running prep steps: %s", err)
-		}
-
-		err = lang.Test(workDir, meta.Package, testTimeout, local)
-		if err != nil {
-			log.Fatalf("error running go test: %s", err)
-		}
-
-		log.Printf("Tests Succeeded!\n\n")
-
-		err = lang.Build(workDir, meta, buildSkipTargets, local)
-		if err != nil {
-			log.Fatalf("build failed: %s", err)
-		}
-
-		err = gm.HandleArtifacts(meta, workDir, cwd, true, false, true, buildSkipTargets, local)
-		if err != nil {
-			log.Fatalf("signing failed: %s", err)
-		}
-
-		err = gm.HandleExtras(meta, workDir, cwd, true, false, true, local)
-		if err != nil {
-			log.Fatalf("Extra artifact processing failed: %s", err)
-		}
+		runPipeline(p)
 	},
 }
 
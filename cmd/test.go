@@ -15,11 +15,7 @@
 package cmd
 
 import (
-	"github.com/nikogura/gomason/pkg/gomason"
 	"github.com/spf13/cobra"
-	"io/ioutil"
-	"log"
-	"os"
 )
 
 // testCmd represents the test command
@@ -40,48 +36,9 @@ Sure, you could do the same thing with a CI or CD system.  But sometimes that's
 Sometimes you need the benefits of a full system here.  Now.  Right at your fingertips.  You're welcome.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		_, err := gomason.NewGomason()
-		if err != nil {
-			log.Fatalf("error creating gomason object")
-		}
-
-		rootWorkDir, err := ioutil.TempDir("", "gomason")
-		if err != nil {
-			log.Fatalf("Failed to create temp dir: %s", err)
-		}
-
-		defer os.RemoveAll(rootWorkDir)
-
-		meta, err := gomason.ReadMetadata(gomason.METADATA_FILENAME)
-		if err != nil {
-			log.Fatalf("failed to read metadata: %s", err)
-		}
-
-		lang, err := gomason.GetByName(meta.GetLanguage())
-		if err != nil {
-			log.Fatalf("Invalid language: %v", err)
-		}
-
-		workDir, err := lang.CreateWorkDir(rootWorkDir)
-		if err != nil {
-			log.Fatalf("Failed to create ephemeral workDir: %s", err)
-		}
-
-		err = lang.Checkout(workDir, meta, branch)
-		if err != nil {
-			log.Fatalf("failed to checkout package %s at branch %s: %s", meta.Package, branch, err)
-		}
-
-		err = lang.Prep(workDir, meta, local)
-		if err != nil {
-			log.Fatalf("error running prep steps: %s", err)
-		}
-
-		err = lang.Test(workDir, meta.Package, testTimeout, local)
-		if err != nil {
-			log.Fatalf("error running go test: %s", err)
-		}
+		opts := pipelineOptions()
 
+		runPipeline(newPipeline(opts))
 	},
 }
 
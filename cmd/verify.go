@@ -0,0 +1,125 @@
+// Copyright © 2017 Nik Ogura <nik.ogura@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nikogura/gomason/pkg/gomason"
+	"github.com/spf13/cobra"
+)
+
+var verifyTargets []string
+var verifyVersion string
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [file...]",
+	Short: "Verify the checksums and signatures of released files",
+	Long: `
+Verify the checksums and signatures of released files.
+
+Given files, verify checks each against whatever .md5, .sha1 and .sha256 files sit next to it, and against its signature, made by the signing program in the metadata file.
+
+Given --target, verify downloads the artifact for that publishing target from where publish put it, along with its signature and checksums, and checks them all.  The package and version come from the metadata file.  Use --version to check a different release.
+
+Verify exits non-zero, saying what failed, if a checksum doesn't match, a signature is bad, or a file was signed by a key that isn't trusted.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 && len(verifyTargets) == 0 {
+			log.Fatalf("Nothing to verify.  Give files to verify, or --target.")
+		}
+
+		gm, err := gomason.NewGomason()
+		if err != nil {
+			log.Fatalf("error creating gomason object: %s", err)
+		}
+
+		meta, err := gomason.ReadMetadata(gomason.METADATA_FILENAME)
+		if err != nil {
+			log.Fatalf("failed to read metadata: %s", err)
+		}
+
+		if verifyVersion != "" {
+			meta.Version = verifyVersion
+		}
+
+		results := make([]gomason.VerifyResult, 0)
+
+		for _, file := range args {
+			result, err := gomason.VerifyFile(meta, file, !meta.PublishInfo.SkipSigning)
+			if err != nil {
+				verifyFailed(file, err)
+			}
+
+			results = append(results, result)
+		}
+
+		if len(verifyTargets) > 0 {
+			dir, err := os.MkdirTemp("", "gomason-verify")
+			if err != nil {
+				log.Fatalf("failed to create temp dir: %s", err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			for _, target := range verifyTargets {
+				result, err := gm.VerifyTarget(meta, target, dir)
+				if err != nil {
+					_ = os.RemoveAll(dir)
+					verifyFailed(target, err)
+				}
+
+				results = append(results, result)
+			}
+		}
+
+		for _, r := range results {
+			checked := make([]string, 0)
+
+			if len(r.Checksums) > 0 {
+				checked = append(checked, strings.Join(r.Checksums, ", "))
+			}
+
+			if len(r.Signatures) == 1 {
+				checked = append(checked, "signature")
+			} else if len(r.Signatures) > 1 {
+				checked = append(checked, fmt.Sprintf("%d signatures", len(r.Signatures)))
+			}
+
+			fmt.Printf("Verified %s: %s\n", r.File, strings.Join(checked, ", "))
+		}
+	},
+}
+
+// verifyFailed exits non-zero, saying exactly what failed if it was a checksum or signature.
+func verifyFailed(what string, err error) {
+	failure := gomason.VerificationFailure(err)
+	if failure != nil {
+		log.Fatalf("Verification of %s FAILED: %s", what, failure)
+	}
+
+	log.Fatalf("Failed to verify %s: %s", what, err)
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringSliceVarP(&verifyTargets, "target", "t", []string{}, fmt.Sprintf("Publishing target from %s to download and verify.  Can be given more than once.", gomason.METADATA_FILENAME))
+	verifyCmd.Flags().StringVarP(&verifyVersion, "version", "", "", fmt.Sprintf("Version to verify, rather than the one in %s.", gomason.METADATA_FILENAME))
+}
@@ -1,6 +1,7 @@
 module github.com/nikogura/gomason
 
 require (
+	github.com/ProtonMail/go-crypto v1.0.0
 	github.com/a8m/envsubst v1.3.0
 	github.com/aws/aws-sdk-go v1.44.159
 	github.com/go-sql-driver/mysql v1.5.0 // indirect
@@ -14,7 +15,7 @@ require (
 	github.com/smartystreets/goconvey v0.0.0-20181108003508-044398e4856c // indirect
 	github.com/spf13/cobra v1.6.1
 	github.com/stretchr/testify v1.8.1
-	golang.org/x/sys v0.3.0 // indirect
+	golang.org/x/crypto v0.7.0
 	gopkg.in/ini.v1 v1.67.0
 )
 
@@ -0,0 +1,734 @@
+package gomason
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SigningProgramX509 signs with an X.509 certificate and its PKCS#8 private key, writing detached CMS (PKCS#7) signatures that 'openssl cms -verify' can check.
+const SigningProgramX509 = "x509"
+
+// CMSSignatureSuffix is the suffix of detached CMS signatures, the same as S/MIME uses.
+const CMSSignatureSuffix = ".p7s"
+
+func init() {
+	signersMap[SigningProgramX509] = X509Signer{}
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// cmsContentInfo is the outermost wrapper of a CMS message.
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// cmsSignedData is a CMS SignedData, per RFC 5652.  Signatures are detached, so the encapsulated content is only ever its type.
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type cmsSignerInfo struct {
+	Version               int
+	IssuerAndSerialNumber cmsIssuerAndSerialNumber
+	DigestAlgorithm       pkix.AlgorithmIdentifier
+	SignedAttrs           asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm    pkix.AlgorithmIdentifier
+	Signature             []byte
+	UnsignedAttrs         asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// X509Signer signs with X.509 certificates, and verifies their signatures against a bundle of trusted CA certificates.
+type X509Signer struct{}
+
+// Sign signs binary with the identity's key file and certificate file from ~/.gomason.
+func (X509Signer) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	return SignCMS(identity, binary, sigFile)
+}
+
+// Verify verifies the signature of binary against the CA bundle that's the identity's keyring in the metadata file.
+func (X509Signer) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (ok bool, err error) {
+	return VerifyCMS(identity, binary, sigFile)
+}
+
+// Inspect verifies the signature of binary, and says which certificate made it.
+func (X509Signer) Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (signer SignatureSigner, err error) {
+	return InspectCMS(identity, binary, sigFile)
+}
+
+// SignatureSuffix returns '.p7s'.
+func (X509Signer) SignatureSuffix() string {
+	return CMSSignatureSuffix
+}
+
+// SignCMS signs a given binary with the identity's PKCS#8 private key, writing a detached, DER encoded CMS signature to sigFile.  The certificate for the key, and any intermediate certificates, are read from the identity's certificate file, and included in the signature so that it can be verified with nothing but the root CA certificate.
+func SignCMS(identity SigningIdentity, binary string, sigFile string) (err error) {
+	if identity.KeyFile == "" || identity.CertFile == "" {
+		err = errors.New(fmt.Sprintf("signing with x509 needs 'keyfile' and 'certfile' set in the '%s' section of ~/.gomason", identity.ConfigSection()))
+		return err
+	}
+
+	key, err := ReadPKCS8PrivateKey(identity.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	certs, err := ReadCertificates(identity.CertFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := certificateForKey(certs, key)
+	if err != nil {
+		err = errors.Wrapf(err, "no certificate in %s is for the key in %s", identity.CertFile, identity.KeyFile)
+		return err
+	}
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", binary)
+		return err
+	}
+
+	sig, err := CMSSign(data, key, cert, certs, time.Now())
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sign %s", binary)
+		return err
+	}
+
+	err = os.WriteFile(sigFile, sig, 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", sigFile)
+		return err
+	}
+
+	logrus.Debugf("Signed %s with certificate %q", binary, cert.Subject.String())
+
+	return err
+}
+
+// ReadPKCS8PrivateKey reads an unencrypted PKCS#8 private key, PEM or DER encoded, from a file.
+func ReadPKCS8PrivateKey(keyFile string) (key crypto.Signer, err error) {
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", keyFile)
+		return key, err
+	}
+
+	if block, _ := pem.Decode(keyBytes); block != nil {
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			err = errors.New(fmt.Sprintf("key in %s is encrypted, which isn't supported.  Decrypt it with 'openssl pkcs8', and keep it somewhere safe", keyFile))
+			return key, err
+		}
+
+		keyBytes = block.Bytes
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse PKCS#8 private key in %s", keyFile)
+		return key, err
+	}
+
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		err = errors.New(fmt.Sprintf("key in %s can't sign", keyFile))
+		return key, err
+	}
+
+	return key, err
+}
+
+// ReadCertificates reads X.509 certificates from a file.  The file can hold any number of PEM encoded certificates, or a single DER encoded one.
+func ReadCertificates(certFile string) (certs []*x509.Certificate, err error) {
+	certBytes, err := os.ReadFile(certFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", certFile)
+		return certs, err
+	}
+
+	certs = make([]*x509.Certificate, 0)
+
+	rest := certBytes
+
+	for {
+		var block *pem.Block
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse certificate in %s", certFile)
+			return certs, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("no certificates in %s", certFile))
+			return certs, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, err
+}
+
+// certificateForKey returns the certificate, out of certs, for the given key.
+func certificateForKey(certs []*x509.Certificate, key crypto.Signer) (cert *x509.Certificate, err error) {
+	type publicKey interface {
+		Equal(crypto.PublicKey) bool
+	}
+
+	pub, ok := key.Public().(publicKey)
+	if !ok {
+		err = errors.New("unsupported key type")
+		return cert, err
+	}
+
+	for _, c := range certs {
+		if pub.Equal(c.PublicKey) {
+			return c, err
+		}
+	}
+
+	err = errors.New("no matching certificate")
+
+	return cert, err
+}
+
+// CMSSign makes a detached, DER encoded CMS SignedData signature of data, by cert, with its private key.  The signing time and a digest of data are signed as attributes, as RFC 5652 requires when there are any.  All of chain is included in the signature.  RSA, ECDSA and Ed25519 keys are supported.
+func CMSSign(data []byte, key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate, signingTime time.Time) (sig []byte, err error) {
+	digestAlg, sigAlg, hash, err := cmsAlgorithms(key)
+	if err != nil {
+		return sig, err
+	}
+
+	h := hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	attrs := make([][]byte, 0)
+
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, signingTime.UTC().Truncate(time.Second)},
+		{oidMessageDigest, digest},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to encode signed attribute %s", attr.oid)
+			return sig, err
+		}
+
+		encoded, err := asn1.Marshal(cmsAttribute{
+			Type:   attr.oid,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "failed to encode signed attribute %s", attr.oid)
+			return sig, err
+		}
+
+		attrs = append(attrs, encoded)
+	}
+
+	// DER wants the members of a SET OF in order
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+
+	signedAttrs := bytes.Join(attrs, nil)
+
+	toSign, err := cmsSignedAttrsSet(signedAttrs)
+	if err != nil {
+		return sig, err
+	}
+
+	var signature []byte
+
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		signature, err = key.Sign(rand.Reader, toSign, crypto.Hash(0))
+	} else {
+		h := hash.New()
+		h.Write(toSign)
+		signature, err = key.Sign(rand.Reader, h.Sum(nil), hash)
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sign")
+		return sig, err
+	}
+
+	certBytes := make([]byte, 0)
+
+	for _, c := range chain {
+		certBytes = append(certBytes, c.Raw...)
+	}
+
+	signedData := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certBytes},
+		SignerInfos: []cmsSignerInfo{
+			{
+				Version: 1,
+				IssuerAndSerialNumber: cmsIssuerAndSerialNumber{
+					Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+					SerialNumber: cert.SerialNumber,
+				},
+				DigestAlgorithm:    digestAlg,
+				SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs},
+				SignatureAlgorithm: sigAlg,
+				Signature:          signature,
+			},
+		},
+	}
+
+	content, err := asn1.Marshal(signedData)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to encode signed data")
+		return sig, err
+	}
+
+	sig, err = asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to encode content info")
+		return sig, err
+	}
+
+	return sig, err
+}
+
+// cmsAlgorithms returns the digest and signature algorithms to sign with the given key with.  Ed25519 uses SHA-512 for the message digest, as RFC 8419 says it must.
+func cmsAlgorithms(key crypto.Signer) (digestAlg pkix.AlgorithmIdentifier, sigAlg pkix.AlgorithmIdentifier, hash crypto.Hash, err error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		digestAlg = pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+		hash = crypto.SHA256
+	case *ecdsa.PrivateKey:
+		digestAlg = pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+		hash = crypto.SHA256
+	case ed25519.PrivateKey:
+		digestAlg = pkix.AlgorithmIdentifier{Algorithm: oidSHA512}
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidEd25519}
+		hash = crypto.SHA512
+	default:
+		err = errors.New(fmt.Sprintf("unsupported key type %T.  RSA, ECDSA and Ed25519 keys are supported", key))
+	}
+
+	return digestAlg, sigAlg, hash, err
+}
+
+// cmsSignedAttrsSet returns the encoding of signed attributes that's actually signed: a SET OF, rather than the [0] they're tagged with in the SignerInfo.
+func cmsSignedAttrsSet(signedAttrs []byte) (encoded []byte, err error) {
+	encoded, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to encode signed attributes")
+		return encoded, err
+	}
+
+	return encoded, err
+}
+
+// VerifyCMS verifies the detached CMS signature of a binary in sigFile.  The certificate that made it has to chain to one of the CA certificates in the identity's keyring now, and be for its email, if it has one.
+func VerifyCMS(identity SigningIdentity, binary string, sigFile string) (ok bool, err error) {
+	cert, err := checkCMSSignature(identity, binary, sigFile, false)
+	if err != nil {
+		return ok, err
+	}
+
+	logrus.Debugf("Good signature on %s from certificate %q", binary, cert.Subject.String())
+
+	ok = true
+
+	return ok, err
+}
+
+// InspectCMS verifies the detached CMS signature of a binary in sigFile, and returns the certificate that made it.  The fingerprint is the SHA-256 of the certificate, and the identities are its subject, common name and email addresses.  A signature by a certificate that has expired is reported as such, rather than as an error.  Revocation isn't checked.
+func InspectCMS(identity SigningIdentity, binary string, sigFile string) (signer SignatureSigner, err error) {
+	cert, err := checkCMSSignature(identity, binary, sigFile, true)
+	if err != nil {
+		return signer, err
+	}
+
+	signer.Fingerprint = fmt.Sprintf("%X", sha256.Sum256(cert.Raw))
+	signer.Identities = make([]string, 0)
+
+	seen := make(map[string]bool)
+
+	for _, id := range append([]string{cert.Subject.String(), cert.Subject.CommonName}, cert.EmailAddresses...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			signer.Identities = append(signer.Identities, id)
+		}
+	}
+
+	signer.Expired = time.Now().After(cert.NotAfter)
+
+	return signer, err
+}
+
+// checkCMSSignature checks the detached CMS signature of a binary in sigFile, returning the certificate that made it.  The certificate chain is checked as of now.  The signing time in the signature is whatever the signer says it is, so it's no evidence the certificate was good when it was made.  If expiredOK is true, a certificate that has expired is checked as of just before it did, so that it can be reported as expired.
+func checkCMSSignature(identity SigningIdentity, binary string, sigFile string, expiredOK bool) (cert *x509.Certificate, err error) {
+	if identity.Keyring == "" {
+		err = errors.New("verifying with x509 needs 'keyring' set to a bundle of trusted CA certificates in the 'signing' section of the metadata file")
+		return cert, err
+	}
+
+	roots, err := ReadCertificates(identity.Keyring)
+	if err != nil {
+		return cert, err
+	}
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", binary)
+		return cert, err
+	}
+
+	sig, err := os.ReadFile(sigFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", sigFile)
+		return cert, err
+	}
+
+	// openssl writes them PEM encoded with -outform PEM
+	if block, _ := pem.Decode(sig); block != nil {
+		sig = block.Bytes
+	}
+
+	signedData, err := parseCMSSignedData(sig)
+	if err != nil {
+		err = BadSignatureError{File: binary, Reason: err.Error()}
+		return cert, err
+	}
+
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		err = BadSignatureError{File: binary, Reason: fmt.Sprintf("failed to parse certificates: %s", err)}
+		return cert, err
+	}
+
+	if len(signedData.SignerInfos) != 1 {
+		err = BadSignatureError{File: binary, Reason: fmt.Sprintf("expected 1 signer, found %d", len(signedData.SignerInfos))}
+		return cert, err
+	}
+
+	signerInfo := signedData.SignerInfos[0]
+
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, signerInfo.IssuerAndSerialNumber.Issuer.FullBytes) && c.SerialNumber.Cmp(signerInfo.IssuerAndSerialNumber.SerialNumber) == 0 {
+			cert = c
+			break
+		}
+	}
+
+	if cert == nil {
+		err = BadSignatureError{File: binary, Reason: "the signer's certificate isn't in the signature"}
+		return cert, err
+	}
+
+	signingTime, err := verifyCMSSignerInfo(signerInfo, cert, data)
+	if err != nil {
+		err = BadSignatureError{File: binary, Reason: err.Error()}
+		return cert, err
+	}
+
+	logrus.Debugf("%s says it was signed at %s", sigFile, signingTime)
+
+	checkTime := time.Now()
+
+	if expiredOK && checkTime.After(cert.NotAfter) {
+		checkTime = cert.NotAfter
+	}
+
+	rootPool := x509.NewCertPool()
+
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, c := range certs {
+		if c != cert {
+			intermediates.AddCert(c)
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		CurrentTime:   checkTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		err = UnknownSignerError{File: binary, Signer: fmt.Sprintf("%q, which isn't trusted by %s: %s", cert.Subject.String(), identity.Keyring, err)}
+		return cert, err
+	}
+
+	if identity.Email != "" && !certificateHasEmail(cert, identity.Email) {
+		err = UnknownSignerError{File: binary, Signer: fmt.Sprintf("%q, which isn't a certificate for %s", cert.Subject.String(), identity.Email)}
+		return cert, err
+	}
+
+	return cert, err
+}
+
+// parseCMSSignedData parses a DER encoded CMS ContentInfo holding a detached SignedData.
+func parseCMSSignedData(sig []byte) (signedData cmsSignedData, err error) {
+	var contentInfo cmsContentInfo
+
+	rest, err := asn1.Unmarshal(sig, &contentInfo)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse CMS signature")
+		return signedData, err
+	}
+
+	if len(rest) > 0 {
+		err = errors.New("trailing data after CMS signature")
+		return signedData, err
+	}
+
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		err = errors.New(fmt.Sprintf("CMS content is %s, not signed data", contentInfo.ContentType))
+		return signedData, err
+	}
+
+	_, err = asn1.Unmarshal(contentInfo.Content.Bytes, &signedData)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse CMS signed data")
+		return signedData, err
+	}
+
+	if len(signedData.EncapContentInfo.EContent.Bytes) > 0 {
+		err = errors.New("CMS signature isn't detached")
+		return signedData, err
+	}
+
+	return signedData, err
+}
+
+// verifyCMSSignerInfo checks that a SignerInfo's signed attributes have the digest of data, and that the signature over them is by cert.  The signing time from the attributes is returned, or the zero time if there isn't one.
+func verifyCMSSignerInfo(signerInfo cmsSignerInfo, cert *x509.Certificate, data []byte) (signingTime time.Time, err error) {
+	hash, err := cmsHash(signerInfo.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return signingTime, err
+	}
+
+	sigAlg, err := cmsSignatureAlgorithm(signerInfo.SignatureAlgorithm.Algorithm, hash, cert)
+	if err != nil {
+		return signingTime, err
+	}
+
+	if len(signerInfo.SignedAttrs.Bytes) == 0 {
+		err = errors.New("signature has no signed attributes")
+		return signingTime, err
+	}
+
+	var digest []byte
+
+	var contentType asn1.ObjectIdentifier
+
+	rest := signerInfo.SignedAttrs.Bytes
+
+	for len(rest) > 0 {
+		var attr cmsAttribute
+
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse signed attributes")
+			return signingTime, err
+		}
+
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			_, err = asn1.Unmarshal(attr.Values.Bytes, &digest)
+		case attr.Type.Equal(oidContentType):
+			_, err = asn1.Unmarshal(attr.Values.Bytes, &contentType)
+		case attr.Type.Equal(oidSigningTime):
+			_, err = asn1.Unmarshal(attr.Values.Bytes, &signingTime)
+		}
+
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse signed attribute %s", attr.Type)
+			return signingTime, err
+		}
+	}
+
+	if !contentType.Equal(oidData) {
+		err = errors.New(fmt.Sprintf("signed content type is %s, not data", contentType))
+		return signingTime, err
+	}
+
+	h := hash.New()
+	h.Write(data)
+
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		err = errors.New("message digest doesn't match")
+		return signingTime, err
+	}
+
+	signed, err := cmsSignedAttrsSet(signerInfo.SignedAttrs.Bytes)
+	if err != nil {
+		return signingTime, err
+	}
+
+	err = cert.CheckSignature(sigAlg, signed, signerInfo.Signature)
+	if err != nil {
+		err = errors.Wrapf(err, "signature doesn't verify")
+		return signingTime, err
+	}
+
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	return signingTime, err
+}
+
+// cmsHash returns the hash for a CMS digest algorithm.
+func cmsHash(oid asn1.ObjectIdentifier) (hash crypto.Hash, err error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		hash = crypto.SHA256
+	case oid.Equal(oidSHA384):
+		hash = crypto.SHA384
+	case oid.Equal(oidSHA512):
+		hash = crypto.SHA512
+	default:
+		err = errors.New(fmt.Sprintf("unsupported digest algorithm %s", oid))
+	}
+
+	return hash, err
+}
+
+// cmsSignatureAlgorithm returns the x509 signature algorithm for a CMS signature algorithm, made with the given digest algorithm.  RSA signatures are often labelled with just 'rsaEncryption', leaving the hash to the digest algorithm.
+func cmsSignatureAlgorithm(oid asn1.ObjectIdentifier, hash crypto.Hash, cert *x509.Certificate) (sigAlg x509.SignatureAlgorithm, err error) {
+	byHash := func(sha256Alg, sha384Alg, sha512Alg x509.SignatureAlgorithm) x509.SignatureAlgorithm {
+		switch hash {
+		case crypto.SHA384:
+			return sha384Alg
+		case crypto.SHA512:
+			return sha512Alg
+		default:
+			return sha256Alg
+		}
+	}
+
+	switch {
+	case oid.Equal(oidRSAEncryption), oid.Equal(oidSHA256WithRSA), oid.Equal(oidSHA384WithRSA), oid.Equal(oidSHA512WithRSA):
+		sigAlg = byHash(x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA)
+	case oid.Equal(oidECDSAWithSHA256), oid.Equal(oidECDSAWithSHA384), oid.Equal(oidECDSAWithSHA512):
+		sigAlg = byHash(x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512)
+	case oid.Equal(oidEd25519):
+		sigAlg = x509.PureEd25519
+	default:
+		err = errors.New(fmt.Sprintf("unsupported signature algorithm %s", oid))
+		return sigAlg, err
+	}
+
+	keyAlg := map[x509.SignatureAlgorithm]x509.PublicKeyAlgorithm{
+		x509.SHA256WithRSA:   x509.RSA,
+		x509.SHA384WithRSA:   x509.RSA,
+		x509.SHA512WithRSA:   x509.RSA,
+		x509.ECDSAWithSHA256: x509.ECDSA,
+		x509.ECDSAWithSHA384: x509.ECDSA,
+		x509.ECDSAWithSHA512: x509.ECDSA,
+		x509.PureEd25519:     x509.Ed25519,
+	}[sigAlg]
+
+	if cert.PublicKeyAlgorithm != keyAlg {
+		err = errors.New(fmt.Sprintf("signature algorithm %s doesn't match the certificate's %s key", oid, cert.PublicKeyAlgorithm))
+		return sigAlg, err
+	}
+
+	return sigAlg, err
+}
+
+// certificateHasEmail says whether cert is for the given email address.
+func certificateHasEmail(cert *x509.Certificate, email string) bool {
+	for _, e := range cert.EmailAddresses {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,358 @@
+package gomason
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate makes a certificate for key, signed by parent with parentKey, or self signed if parent is nil.
+func testCertificate(t *testing.T, name string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer, ca bool, notAfter time.Time) (cert *x509.Certificate) {
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("Error generating serial: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"Gomason Test"}},
+		NotBefore:             time.Now().Add(-2 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+		template.EmailAddresses = []string{name}
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate for %s: %s", name, err)
+	}
+
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate for %s: %s", name, err)
+	}
+
+	return cert
+}
+
+// writeTestPEM writes PEM blocks of the given type to a file in dir.
+func writeTestPEM(t *testing.T, dir string, name string, blockType string, ders ...[]byte) (file string) {
+	file = filepath.Join(dir, name)
+
+	content := make([]byte, 0)
+
+	for _, der := range ders {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})...)
+	}
+
+	err := os.WriteFile(file, content, 0600)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", file, err)
+	}
+
+	return file
+}
+
+// writeTestPKI makes a root CA, an intermediate CA, and a code signing certificate for email with a key of the given type, valid until notAfter.  It returns the PKCS#8 key file, a file with the signing and intermediate certificates, and a file with the root CA certificate.
+func writeTestPKI(t *testing.T, dir string, name string, keyType string, email string, notAfter time.Time) (keyFile string, certFile string, caFile string) {
+	newKey := func() crypto.Signer {
+		var key crypto.Signer
+		var err error
+
+		switch keyType {
+		case "rsa":
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		case "ecdsa":
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case "ed25519":
+			_, key, err = ed25519.GenerateKey(rand.Reader)
+		}
+
+		if err != nil {
+			t.Fatalf("Error generating %s key: %s", keyType, err)
+		}
+
+		return key
+	}
+
+	rootKey := newKey()
+	root := testCertificate(t, name+" Root CA", rootKey, nil, nil, true, time.Now().Add(24*time.Hour))
+
+	intermediateKey := newKey()
+	intermediate := testCertificate(t, name+" Intermediate CA", intermediateKey, root, rootKey, true, time.Now().Add(24*time.Hour))
+
+	key := newKey()
+	leaf := testCertificate(t, email, key, intermediate, intermediateKey, false, notAfter)
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshalling key: %s", err)
+	}
+
+	keyFile = writeTestPEM(t, dir, name+".key", "PRIVATE KEY", keyDer)
+	certFile = writeTestPEM(t, dir, name+".crt", "CERTIFICATE", leaf.Raw, intermediate.Raw)
+	caFile = writeTestPEM(t, dir, name+"-ca.crt", "CERTIFICATE", root.Raw)
+
+	return keyFile, certFile, caFile
+}
+
+func TestSignVerifyCMS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	email := "tester@foo.com"
+	later := time.Now().Add(24 * time.Hour)
+
+	rsaKey, rsaCert, rsaCA := writeTestPKI(t, dir, "rsa", "rsa", email, later)
+	ecKey, ecCert, ecCA := writeTestPKI(t, dir, "ecdsa", "ecdsa", email, later)
+	edKey, edCert, edCA := writeTestPKI(t, dir, "ed25519", "ed25519", email, later)
+	_, _, otherCA := writeTestPKI(t, dir, "other", "ecdsa", email, later)
+
+	encKey := writeTestPEM(t, dir, "encrypted.key", "ENCRYPTED PRIVATE KEY", []byte("sekrit"))
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	inputs := []struct {
+		name      string
+		keyFile   string
+		certFile  string
+		caFile    string
+		email     string
+		signErr   bool
+		verifyErr bool
+		openssl   bool
+	}{
+		{"rsa", rsaKey, rsaCert, rsaCA, email, false, false, true},
+		{"ecdsa", ecKey, ecCert, ecCA, email, false, false, true},
+		{"ed25519", edKey, edCert, edCA, email, false, false, false},
+		{"no email", ecKey, ecCert, ecCA, "", false, false, true},
+		{"wrong email", ecKey, ecCert, ecCA, "someone@bar.com", false, true, false},
+		{"untrusted", ecKey, ecCert, otherCA, email, false, true, false},
+		{"certificate for another key", rsaKey, ecCert, ecCA, email, true, false, false},
+		{"no certificate", ecKey, "", ecCA, email, true, false, false},
+		{"encrypted key", encKey, ecCert, ecCA, email, true, false, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			g := Gomason{
+				Config: UserConfig{
+					Signing: UserSignInfo{Program: SigningProgramX509, KeyFile: tc.keyFile, CertFile: tc.certFile},
+				},
+			}
+
+			meta := testMetadataObj()
+			meta.SignInfo = SignInfo{Program: SigningProgramX509, Email: tc.email, Keyring: tc.caFile}
+
+			err := g.SignBinary(meta, binary)
+			if tc.signErr {
+				assert.NotNil(t, err, "Signing fails")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			ok, err := VerifyBinary(binary, meta)
+			if tc.verifyErr {
+				assert.IsType(t, UnknownSignerError{}, VerificationFailure(err), "Signer isn't trusted")
+				assert.False(t, ok, "Signature doesn't verify")
+				return
+			}
+
+			assert.Nil(t, err, "No error verifying signature")
+			assert.True(t, ok, "Signature verifies")
+
+			// openssl should agree, for the keys it can do CMS with
+			if _, err := exec.LookPath("openssl"); err != nil || !tc.openssl {
+				return
+			}
+
+			out, err := exec.Command("openssl", "cms", "-verify", "-binary", "-inform", "DER", "-in", binary+CMSSignatureSuffix, "-content", binary, "-CAfile", tc.caFile, "-purpose", "any", "-out", os.DevNull).CombinedOutput()
+			assert.Nil(t, err, "openssl verifies the signature: %s", out)
+		})
+	}
+
+	// the signer is who the certificate says
+	identity := SigningIdentity{Program: SigningProgramX509, Email: email, Keyring: ecCA, KeyFile: ecKey, CertFile: ecCert}
+
+	err = SignCMS(identity, binary, binary+CMSSignatureSuffix)
+	if err != nil {
+		t.Fatalf("Error signing %s: %s", binary, err)
+	}
+
+	signer, err := InspectCMS(identity, binary, binary+CMSSignatureSuffix)
+	assert.Nil(t, err, "No error inspecting signature")
+	assert.Equal(t, []string{"CN=tester@foo.com,O=Gomason Test", email}, signer.Identities, "Identities are the certificate's")
+	assert.False(t, signer.Expired, "Certificate isn't expired")
+	assert.True(t, signer.AllowedBy([]string{email}), "Signer is allowed by email")
+
+	certs, err := ReadCertificates(ecCert)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", ecCert, err)
+	}
+
+	assert.True(t, signer.AllowedBy([]string{fmt.Sprintf("%X", sha256.Sum256(certs[0].Raw))}), "Signer is allowed by certificate fingerprint")
+
+	// a signature made by openssl verifies, DER or PEM, and stops verifying if the file changes
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+
+	rsaCerts, err := ReadCertificates(rsaCert)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", rsaCert, err)
+	}
+
+	intermediateFile := writeTestPEM(t, dir, "rsa-intermediate.crt", "CERTIFICATE", rsaCerts[1].Raw)
+
+	for _, format := range []string{"DER", "PEM"} {
+		sigFile := filepath.Join(dir, "openssl"+CMSSignatureSuffix)
+
+		out, err := exec.Command("openssl", "cms", "-sign", "-binary", "-in", binary, "-signer", rsaCert, "-inkey", rsaKey, "-certfile", intermediateFile, "-outform", format, "-out", sigFile).CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error signing with openssl: %s: %s", err, out)
+		}
+
+		identity := SigningIdentity{Program: SigningProgramX509, Email: email, Keyring: rsaCA}
+
+		ok, err := VerifyCMS(identity, binary, sigFile)
+		assert.Nil(t, err, "No error verifying openssl %s signature", format)
+		assert.True(t, ok, "openssl %s signature verifies", format)
+	}
+
+	err = os.WriteFile(binary, []byte(testFileContent()+"tampered"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	ok, err := VerifyCMS(SigningIdentity{Program: SigningProgramX509, Email: email, Keyring: rsaCA}, binary, filepath.Join(dir, "openssl"+CMSSignatureSuffix))
+	assert.IsType(t, BadSignatureError{}, VerificationFailure(err), "Tampered file fails verification")
+	assert.False(t, ok, "Tampered file doesn't verify")
+}
+
+func TestCMSExpired(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile, certFile, caFile := writeTestPKI(t, dir, "expiring", "ecdsa", "tester@foo.com", time.Now().Add(-time.Hour))
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	key, err := ReadPKCS8PrivateKey(keyFile)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", keyFile, err)
+	}
+
+	certs, err := ReadCertificates(certFile)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", certFile, err)
+	}
+
+	// signed after the certificate expired, claiming to have been signed while it was good, as anyone with its key can
+	sig, err := CMSSign([]byte(testFileContent()), key, certs[0], certs, time.Now().Add(-90*time.Minute))
+	if err != nil {
+		t.Fatalf("Error signing: %s", err)
+	}
+
+	sigFile := binary + CMSSignatureSuffix
+
+	err = os.WriteFile(sigFile, sig, 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", sigFile, err)
+	}
+
+	identity := SigningIdentity{Program: SigningProgramX509, Keyring: caFile}
+
+	ok, err := VerifyCMS(identity, binary, sigFile)
+	assert.IsType(t, UnknownSignerError{}, VerificationFailure(err), "Backdated signature by an expired certificate doesn't verify")
+	assert.False(t, ok, "Signature doesn't verify")
+
+	ok, err = VerifyBinary(binary, Metadata{SignInfo: SignInfo{Program: SigningProgramX509, Keyring: caFile}})
+	assert.NotNil(t, err, "Verifying the binary fails")
+	assert.False(t, ok, "Binary doesn't verify")
+
+	signer, err := InspectCMS(identity, binary, sigFile)
+	assert.Nil(t, err, "No error inspecting signature")
+	assert.True(t, signer.Expired, "Certificate has expired")
+
+	// nor does it get published
+	meta := testMetadataObj()
+	meta.SignInfo = SignInfo{Program: SigningProgramX509, Keyring: caFile, Allowed: []string{"tester@foo.com"}}
+
+	g := Gomason{}
+
+	err = g.CheckSigningPolicy(meta, binary)
+	assert.IsType(t, SignerNotAllowedError{}, VerificationFailure(err), "Publishing is refused")
+}
+
+func TestPlanFileCMS(t *testing.T) {
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{Program: SigningProgramX509}}}
+
+	meta := testMetadataObj()
+	meta.Repository = "http://localhost:8081/artifactory/generic-local"
+
+	plan, err := g.PlanFile(meta, "/tmp/foo/testproject_linux_amd64", true, true)
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+
+	signatures := make([]PlannedUpload, 0)
+
+	for _, u := range plan.Uploads {
+		if u.Kind == UploadKindSignature {
+			signatures = append(signatures, u)
+		}
+	}
+
+	if assert.Equal(t, 1, len(signatures), "One signature is uploaded") {
+		assert.Equal(t, "/tmp/foo/testproject_linux_amd64.p7s", signatures[0].Source, "Signature source is the .p7s")
+		assert.Equal(t, "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject.p7s", signatures[0].Destination, "Signature is uploaded next to the artifact")
+	}
+}
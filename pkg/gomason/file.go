@@ -0,0 +1,256 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	RegisterPublisher("file", FilePublisher{})
+	// plain absolute paths have no scheme
+	RegisterPublisher("", FilePublisher{})
+}
+
+// FilePublisher publishes to a directory on disk, such as a repository shared over NFS.  Destinations are 'file:///...' urls, or plain absolute paths.
+type FilePublisher struct{}
+
+// LocalPath returns the path on disk for a 'file:///...' url or an absolute path.
+func LocalPath(destination string) (localPath string, err error) {
+	if !strings.HasPrefix(strings.ToLower(destination), "file:") {
+		if !filepath.IsAbs(destination) {
+			err = errors.New(fmt.Sprintf("%s is not an absolute path", destination))
+			return localPath, err
+		}
+
+		return filepath.Clean(destination), err
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", destination)
+		return localPath, err
+	}
+
+	if u.Host != "" && u.Host != "localhost" {
+		err = errors.New(fmt.Sprintf("%s is on host %s.  Only local files, or mounted network shares, can be published to with file urls", destination, u.Host))
+		return localPath, err
+	}
+
+	if u.Path == "" {
+		err = errors.New(fmt.Sprintf("%s has no path", destination))
+		return localPath, err
+	}
+
+	return filepath.FromSlash(u.Path), err
+}
+
+// Put writes data to the file at url atomically, by writing a temporary file next to it, and renaming it into place.  Directories above it are made if need be.  If data is a file, its permissions are kept.  Otherwise the file is readable by everyone.
+func (FilePublisher) Put(url string, data io.Reader, checksums Checksums, username string, password string) (err error) {
+	localPath, err := LocalPath(url)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+
+	if f, ok := data.(interface{ Stat() (os.FileInfo, error) }); ok {
+		info, err := f.Stat()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to stat what's being written to %s", localPath)
+			return err
+		}
+
+		mode = info.Mode().Perm()
+	}
+
+	// make the directory paths, the same as for s3
+	dirs, err := DirsForURL(fileURL(localPath))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse dirs for %s", localPath)
+		return err
+	}
+
+	for _, d := range dirs {
+		if d == "." || d == "" {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.FromSlash("/"+d), 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create directory %s", d)
+			return err
+		}
+	}
+
+	dir, name := filepath.Split(localPath)
+
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf(".%s.tmp-*", name))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create temp file in %s", dir)
+		return err
+	}
+
+	// only does anything if we didn't get to rename it
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, data)
+	if err != nil {
+		_ = tmp.Close()
+		err = errors.Wrapf(err, "failed to write %s", tmp.Name())
+		return err
+	}
+
+	err = tmp.Chmod(mode)
+	if err != nil {
+		_ = tmp.Close()
+		err = errors.Wrapf(err, "failed to set permissions of %s", tmp.Name())
+		return err
+	}
+
+	// make sure it's all on disk before it appears under its real name
+	err = tmp.Sync()
+	if err != nil {
+		_ = tmp.Close()
+		err = errors.Wrapf(err, "failed to sync %s", tmp.Name())
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to close %s", tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to rename %s to %s", tmp.Name(), localPath)
+		return err
+	}
+
+	logrus.Debugf("Wrote %s", localPath)
+
+	return err
+}
+
+// Get copies the file at url into out.
+func (FilePublisher) Get(url string, out io.Writer, username string, password string) (err error) {
+	localPath, err := LocalPath(url)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", localPath)
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(out, f)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", localPath)
+		return err
+	}
+
+	return err
+}
+
+// Head says whether there's a file at url.
+func (FilePublisher) Head(url string, username string, password string) (exists bool, err error) {
+	localPath, err := LocalPath(url)
+	if err != nil {
+		return exists, err
+	}
+
+	_, err = os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return exists, nil
+		}
+
+		err = errors.Wrapf(err, "failed to stat %s", localPath)
+		return exists, err
+	}
+
+	exists = true
+
+	return exists, err
+}
+
+// Delete removes the file at url.  Deleting something that isn't there isn't an error.
+func (FilePublisher) Delete(url string, username string, password string) (err error) {
+	localPath, err := LocalPath(url)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(localPath)
+	if err != nil && !os.IsNotExist(err) {
+		err = errors.Wrapf(err, "failed to remove %s", localPath)
+		return err
+	}
+
+	return nil
+}
+
+// List returns the files in the directory at url, in the same form as url: file urls for a file url, and paths for a path.  Subdirectories, and temporary files left by interrupted writes, are left out.
+func (FilePublisher) List(url string, username string, password string) (urls []string, err error) {
+	urls = make([]string, 0)
+
+	localPath, err := LocalPath(url)
+	if err != nil {
+		return urls, err
+	}
+
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read directory %s", localPath)
+		return urls, err
+	}
+
+	isURL := strings.HasPrefix(strings.ToLower(url), "file:")
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		p := filepath.Join(localPath, entry.Name())
+
+		if isURL {
+			p = fileURL(p)
+		}
+
+		urls = append(urls, p)
+	}
+
+	sort.Strings(urls)
+
+	return urls, err
+}
+
+// Method describes where on disk url is.
+func (FilePublisher) Method(url string) string {
+	localPath, err := LocalPath(url)
+	if err != nil {
+		return fmt.Sprintf("unsupported: %s", err)
+	}
+
+	return fmt.Sprintf("local file %s", localPath)
+}
+
+// fileURL returns the 'file:///...' url for a local path.
+func fileURL(localPath string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(localPath)}
+
+	return u.String()
+}
@@ -0,0 +1,172 @@
+package gomason
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalPath(t *testing.T) {
+	inputs := []struct {
+		name string
+		dst  string
+		path string
+		errs bool
+	}{
+		{"file url", "file:///srv/repo/foo", "/srv/repo/foo", false},
+		{"localhost file url", "file://localhost/srv/repo/foo", "/srv/repo/foo", false},
+		{"escaped file url", "file:///srv/repo/foo%20bar", "/srv/repo/foo bar", false},
+		{"absolute path", "/srv/repo/../repo/foo", "/srv/repo/foo", false},
+		{"remote host", "file://fileserver/srv/repo/foo", "", true},
+		{"relative path", "repo/foo", "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			localPath, err := LocalPath(tc.dst)
+			if tc.errs {
+				assert.NotNil(t, err, "Not a local path")
+				return
+			}
+
+			assert.Nil(t, err, "No error getting path")
+			assert.Equal(t, tc.path, localPath, "Path meets expectations")
+			assert.Equal(t, "local file "+tc.path, UploadMethod(tc.dst), "Plan describes publisher")
+		})
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inputs := []struct {
+		name string
+		base string
+	}{
+		{"file url", fileURL(filepath.Join(tmpDir, "url"))},
+		{"path", filepath.Join(tmpDir, "path")},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			publisher, err := GetPublisher(tc.base)
+			if err != nil {
+				t.Fatalf("Error getting publisher: %s", err)
+			}
+
+			dir := tc.base + "/testproject/0.1.0/linux/amd64"
+			file := dir + "/testproject"
+			sig := file + ".asc"
+
+			exists, err := publisher.Head(file, "", "")
+			assert.Nil(t, err, "No error checking for missing file")
+			assert.False(t, exists, "File isn't there yet")
+
+			for _, u := range []string{file, sig} {
+				err = publisher.Put(u, strings.NewReader(testFileContent()), Checksums{}, "", "")
+				if err != nil {
+					t.Fatalf("Error putting %s: %s", u, err)
+				}
+			}
+
+			// again, replacing it
+			err = publisher.Put(file, strings.NewReader(testFileContent()), Checksums{}, "", "")
+			assert.Nil(t, err, "No error replacing file")
+
+			var out bytes.Buffer
+
+			err = publisher.Get(file, &out, "", "")
+			assert.Nil(t, err, "No error getting file")
+			assert.Equal(t, testFileContent(), out.String(), "Got what was put")
+
+			urls, err := publisher.List(dir, "", "")
+			assert.Nil(t, err, "No error listing")
+			assert.Equal(t, []string{file, sig}, urls, "Listing has what was put, and no temp files")
+
+			localDir, _ := LocalPath(dir)
+			entries, _ := os.ReadDir(localDir)
+			assert.Equal(t, 2, len(entries), "No temp files left behind")
+
+			err = publisher.Delete(sig, "", "")
+			assert.Nil(t, err, "No error deleting")
+
+			exists, err = publisher.Head(sig, "", "")
+			assert.Nil(t, err, "No error checking for deleted file")
+			assert.False(t, exists, "Deleted file is gone")
+
+			err = publisher.Delete(sig, "", "")
+			assert.Nil(t, err, "Deleting something that's not there is fine")
+		})
+	}
+}
+
+func TestPublishFileToDisk(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binary := filepath.Join(tmpDir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0750)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	err = os.WriteFile(binary+".asc", []byte("signature"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary+".asc", err)
+	}
+
+	repo := filepath.Join(tmpDir, "repo")
+
+	meta := testMetadataObj()
+	meta.Repository = repo
+
+	g := Gomason{}
+
+	results, err := g.PublishFileWithResults(meta, binary)
+	if err != nil {
+		t.Fatalf("Error publishing: %s", err)
+	}
+
+	assert.Equal(t, 5, len(results), "Every upload has a result")
+
+	published := filepath.Join(repo, "testproject/0.1.0/linux/amd64/testproject")
+
+	info, err := os.Stat(published)
+	if err != nil {
+		t.Fatalf("Error finding published file: %s", err)
+	}
+
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), "Permissions were kept")
+
+	sha256sum, _ := BytesSha256([]byte(testFileContent()))
+
+	inputs := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"binary", published, testFileContent()},
+		{"signature", published + ".asc", "signature"},
+		{"sha256", published + ".sha256", sha256sum},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := os.ReadFile(tc.file)
+			assert.Nil(t, err, "No error reading %s", tc.file)
+			assert.Equal(t, tc.content, string(content), "Content was written alongside")
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package gomason
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// GitRemoteName is the name of the remote gomason fetches code from.
+const GitRemoteName = "origin"
+
+var fullShaRegex = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// scpGitURLRegex matches the scp like urls git understands, e.g. 'git@github.com:nikogura/gomason.git'
+var scpGitURLRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/]`)
+
+// GitURLFromPackage turns a go package name into a https git url, which is what 'go get' would have cloned.
+func GitURLFromPackage(packageName string) (gitUrl string) {
+	gitUrl = fmt.Sprintf("https://%s", packageName)
+
+	return gitUrl
+}
+
+// GitURL returns the url to clone the code from.  That's 'git-url' from the metadata if it's set, otherwise it's derived from the package name.
+func (m Metadata) GitURL() (gitUrl string) {
+	if m.GitUrl != "" {
+		return m.GitUrl
+	}
+
+	return GitURLFromPackage(m.Package)
+}
+
+// CheckoutURL returns where the code is checked out from.  That's the local repository when building what's committed in it, otherwise it's GitURL.
+func (m Metadata) CheckoutURL() (checkoutUrl string) {
+	if m.LocalRepo != "" {
+		return m.LocalRepo
+	}
+
+	return m.GitURL()
+}
+
+// IsRemoteGitURL says whether gitUrl is somewhere other people can get the code from, e.g. 'https://github.com/nikogura/gomason' or 'git@github.com:nikogura/gomason.git', rather than a path on this machine.
+func IsRemoteGitURL(gitUrl string) bool {
+	if scpGitURLRegex.MatchString(gitUrl) {
+		return true
+	}
+
+	parsed, err := url.Parse(gitUrl)
+	if err != nil {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "ssh", "git", "git+ssh":
+		return parsed.Host != ""
+	}
+
+	return false
+}
+
+// GitCheckout checks out ref from the repository at url into dir, which is created if necessary.  Ref can be a branch, a tag, a full commit sha, a ref such as 'refs/pull/123/head', or empty for the remote's default branch.  If dir already holds a checkout from a previous run, it's fetched into and cleaned, so that nothing from that run is left behind.  Returns the sha of the commit that was checked out.
+func GitCheckout(dir string, url string, ref string, insecure bool) (commit string, err error) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		err = errors.Wrap(err, "Failed to find git executable in path")
+		return commit, err
+	}
+
+	globalArgs := make([]string, 0)
+	if insecure {
+		globalArgs = append(globalArgs, "-c", "http.sslVerify=false")
+	}
+
+	run := func(args ...string) (output string, err error) {
+		return RunGit(git, dir, append(globalArgs, args...)...)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		logrus.Debugf("Initializing git repository in %s", dir)
+
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed creating %s", dir)
+			return commit, err
+		}
+
+		_, err = run("init", "--quiet")
+		if err != nil {
+			return commit, err
+		}
+
+		_, err = run("remote", "add", GitRemoteName, url)
+		if err != nil {
+			return commit, err
+		}
+	} else {
+		logrus.Debugf("%s already has a git repository.  Updating.", dir)
+
+		_, err = run("remote", "set-url", GitRemoteName, url)
+		if err != nil {
+			return commit, err
+		}
+	}
+
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = "HEAD"
+	}
+
+	logrus.Debugf("Fetching %s from %s", fetchRef, url)
+
+	_, err = run("fetch", "--quiet", "--tags", "--force", GitRemoteName, fetchRef)
+	if err != nil {
+		// Not every server will hand over a commit by sha.  If that's what we're after, fetch everything and look for it.
+		if !fullShaRegex.MatchString(ref) {
+			err = errors.Wrapf(err, "failed fetching %q from %s", fetchRef, url)
+			return commit, err
+		}
+
+		logrus.Debugf("Fetching %s directly failed.  Fetching all branches instead.", ref)
+
+		_, err = run("fetch", "--quiet", "--tags", "--force", GitRemoteName, fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", GitRemoteName))
+		if err != nil {
+			err = errors.Wrapf(err, "failed fetching from %s", url)
+			return commit, err
+		}
+
+		fetchRef = ref
+	} else {
+		fetchRef = "FETCH_HEAD"
+	}
+
+	_, err = run("checkout", "--quiet", "--force", "--detach", fetchRef)
+	if err != nil {
+		err = errors.Wrapf(err, "failed checking out %q", ref)
+		return commit, err
+	}
+
+	// a warm workspace may have leftovers from last time
+	_, err = run("clean", "--quiet", "-ffdx")
+	if err != nil {
+		err = errors.Wrapf(err, "failed cleaning %s", dir)
+		return commit, err
+	}
+
+	commit, err = run("rev-parse", "HEAD")
+	if err != nil {
+		err = errors.Wrapf(err, "failed resolving checked out commit")
+		return commit, err
+	}
+
+	if fullShaRegex.MatchString(ref) && commit != ref {
+		err = errors.New(fmt.Sprintf("checked out %s, but %s was asked for", commit, ref))
+		return commit, err
+	}
+
+	return commit, err
+}
+
+// RunGit runs git with the given args in dir, and returns its trimmed output.  If git fails, the error includes whatever git had to say about it.
+func RunGit(git string, dir string, args ...string) (output string, err error) {
+	cmd := exec.Command(git, args...)
+
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logrus.Debugf("Running git %s in %s", strings.Join(args, " "), dir)
+
+	err = cmd.Run()
+	output = strings.TrimSpace(stdout.String())
+
+	if err != nil {
+		err = errors.Wrapf(err, "git %s failed: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+		return output, err
+	}
+
+	return output, err
+}
+
+// GitTopLevel returns the root of the git repository containing dir.
+func GitTopLevel(dir string) (topLevel string, err error) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		err = errors.Wrap(err, "Failed to find git executable in path")
+		return topLevel, err
+	}
+
+	topLevel, err = RunGit(git, dir, "rev-parse", "--show-toplevel")
+
+	return topLevel, err
+}
+
+// GitDirty returns true if the git repository in dir has uncommitted changes, or untracked files that aren't ignored.
+func GitDirty(dir string) (dirty bool, err error) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		err = errors.Wrap(err, "Failed to find git executable in path")
+		return dirty, err
+	}
+
+	status, err := RunGit(git, dir, "status", "--porcelain")
+	if err != nil {
+		return dirty, err
+	}
+
+	dirty = status != ""
+
+	return dirty, err
+}
+
+// GitCommit returns the sha of the commit checked out in dir.
+func GitCommit(dir string) (commit string, err error) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		err = errors.Wrap(err, "Failed to find git executable in path")
+		return commit, err
+	}
+
+	commit, err = RunGit(git, dir, "rev-parse", "HEAD")
+
+	return commit, err
+}
@@ -0,0 +1,149 @@
+package gomason
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testGitRepo creates a git repository with a couple of commits, a branch, a tag, and a pull request ref.  Returns the dir and a map of ref to the commit it points to.
+func testGitRepo(t *testing.T) (dir string, refs map[string]string) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	git := func(args ...string) string {
+		args = append([]string{"-c", "user.name=Gomason Test", "-c", "user.email=gomason@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s: %s", args, err, out)
+		}
+
+		return string(out)
+	}
+
+	commit := func(file string) string {
+		err := os.WriteFile(filepath.Join(dir, file), []byte(file), 0644)
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", file, err)
+		}
+
+		git("add", file)
+		git("commit", "--quiet", "-m", file)
+
+		out, err := RunGit("git", dir, "rev-parse", "HEAD")
+		if err != nil {
+			t.Fatalf("Error getting HEAD: %s", err)
+		}
+
+		return out
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+
+	refs = make(map[string]string)
+
+	refs["v0.1.0"] = commit("first")
+	git("tag", "v0.1.0")
+
+	refs["main"] = commit("second")
+
+	git("checkout", "--quiet", "-b", "testbranch")
+	refs["testbranch"] = commit("test_file")
+
+	git("checkout", "--quiet", "-b", "pr")
+	refs["refs/pull/123/head"] = commit("pr_file")
+	git("update-ref", "refs/pull/123/head", "HEAD")
+
+	git("checkout", "--quiet", "main")
+	git("branch", "--quiet", "-D", "pr")
+
+	return dir, refs
+}
+
+func TestGitCheckout(t *testing.T) {
+	repo, refs := testGitRepo(t)
+	defer os.RemoveAll(repo)
+
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputs := []struct {
+		name     string
+		ref      string
+		expected string
+		present  string
+	}{
+		{"default", "", refs["main"], "second"},
+		{"branch", "testbranch", refs["testbranch"], "test_file"},
+		{"tag", "v0.1.0", refs["v0.1.0"], "first"},
+		{"sha", refs["testbranch"], refs["testbranch"], "test_file"},
+		{"pull request", "refs/pull/123/head", refs["refs/pull/123/head"], "pr_file"},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			// each checkout reuses the same dir, so this also exercises reusing a warm workspace
+			codepath := filepath.Join(dir, "src", "example.com", "fixture")
+
+			err := os.MkdirAll(codepath, 0755)
+			if err != nil {
+				t.Fatalf("Error creating %s: %s", codepath, err)
+			}
+
+			err = os.WriteFile(filepath.Join(codepath, "leftover"), []byte("leftover"), 0644)
+			if err != nil {
+				t.Fatalf("Error writing leftover file: %s", err)
+			}
+
+			commit, err := GitCheckout(codepath, repo, tc.ref, false)
+			if err != nil {
+				t.Fatalf("Error checking out %q: %s", tc.ref, err)
+			}
+
+			assert.Equal(t, tc.expected, commit, "Checked out commit meets expectations")
+
+			_, err = os.Stat(filepath.Join(codepath, tc.present))
+			assert.Nil(t, err, "%s is checked out", tc.present)
+
+			_, err = os.Stat(filepath.Join(codepath, "leftover"))
+			assert.True(t, os.IsNotExist(err), "Leftovers are cleaned up")
+		})
+	}
+
+	_, err = GitCheckout(filepath.Join(dir, "bogus"), repo, "nosuchbranch", false)
+	assert.NotNil(t, err, "Checking out a ref that doesn't exist is an error")
+
+	_, err = GitCheckout(filepath.Join(dir, "bogus2"), filepath.Join(dir, "nosuchrepo"), "", false)
+	assert.NotNil(t, err, "Checking out a repo that doesn't exist is an error")
+}
+
+func TestIsRemoteGitURL(t *testing.T) {
+	inputs := []struct {
+		url    string
+		remote bool
+	}{
+		{"https://github.com/nikogura/gomason", true},
+		{"http://git.example.com/foo.git", true},
+		{"ssh://git@github.com/nikogura/gomason.git", true},
+		{"git@github.com:nikogura/gomason.git", true},
+		{"/home/tester/src/gomason", false},
+		{"../gomason", false},
+		{"file:///home/tester/src/gomason", false},
+		{"https:///nohost", false},
+		{"", false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.url, func(t *testing.T) {
+			assert.Equal(t, tc.remote, IsRemoteGitURL(tc.url), "Remote meets expectations")
+		})
+	}
+}
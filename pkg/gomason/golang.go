@@ -1,12 +1,17 @@
 package gomason
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/a8m/envsubst"
 	"github.com/pkg/errors"
@@ -16,6 +21,13 @@ func init() {
 	languagesMap[LanguageGolang] = Golang{}
 }
 
+const (
+	// BuilderGo builds binaries with 'go build'.  This is the default.
+	BuilderGo = "go"
+	// BuilderGox builds binaries with github.com/mitchellh/gox, which is installed at build time.
+	BuilderGox = "gox"
+)
+
 // Golang struct.  For golang, workdir is GOPATH
 type Golang struct{}
 
@@ -42,53 +54,25 @@ func (Golang) CreateWorkDir(workDir string) (gopath string, err error) {
 	return gopath, err
 }
 
-// Checkout  Actually checks out the code you're trying to test into your temporary GOPATH
+// Checkout  Actually checks out the code you're trying to test into your temporary GOPATH.  Branch can be any git ref: a branch, a tag, a full commit sha, or something like 'refs/pull/123/head'.
 func (Golang) Checkout(gopath string, meta Metadata, branch string) (err error) {
-	err = os.Chdir(gopath)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to cwd to %s", gopath)
-		return err
-	}
+	codepath := filepath.Join(gopath, "src", meta.Package)
+	url := meta.CheckoutURL()
+
+	logrus.Debugf("Checking out %s from %s into %s", meta.Package, url, codepath)
 
-	// install the code via go get  after all, we don't really want to play if it's not in a repo.
-	gobinary := "go"
-	gocommand, err := exec.LookPath(gobinary)
+	commit, err := GitCheckout(codepath, url, branch, meta.InsecureGet)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to find go binary: %s", gobinary)
+		err = errors.Wrapf(err, "failed to check out %s", meta.Package)
 		return err
 	}
 
-	runenv := append(os.Environ(), fmt.Sprintf("GOPATH=%s", gopath))
-	runenv = append(runenv, "GO111MODULE=off")
-
-	var cmd *exec.Cmd
-
-	if meta.InsecureGet {
-		cmd = exec.Command(gocommand, "get", "-v", "-insecure", meta.Package)
-	} else {
-		cmd = exec.Command(gocommand, "get", "-v", "-d", fmt.Sprintf("%s/...", meta.Package))
+	ref := branch
+	if ref == "" {
+		ref = "default branch"
 	}
 
-	logrus.Debugf("Running %s with GOPATH=%s", cmd.Args, gopath)
-
-	cmd.Env = runenv
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err = cmd.Run()
-
-	if err == nil {
-		logrus.Debugf("Checkout of %s complete", meta.Package)
-	}
-
-	git, err := exec.LookPath("git")
-	if err != nil {
-		err := errors.Wrap(err, "Failed to find git executable in path")
-		return err
-	}
-
-	codepath := filepath.Join(gopath, "src", meta.Package)
+	fmt.Printf("Checked out %s (%s) at %s\n", meta.Package, ref, commit)
 
 	err = os.Chdir(codepath)
 	if err != nil {
@@ -96,17 +80,6 @@ func (Golang) Checkout(gopath string, meta Metadata, branch string) (err error)
 		return err
 	}
 
-	if branch != "" {
-		logrus.Debugf("Checking out branch: %s", branch)
-
-		cmd := exec.Command(git, "checkout", branch)
-
-		err = cmd.Run()
-		if err == nil {
-			logrus.Debugf("Checkout of branch: %s complete.", branch)
-		}
-	}
-
 	return err
 }
 
@@ -209,19 +182,8 @@ func (Golang) Test(gopath string, gomodule string, timeout string, local bool) (
 	return err
 }
 
-// Build uses `gox` to build binaries per metadata file
+// Build builds binaries per metadata file, with 'go build' or optionally with `gox`
 func (g Golang) Build(gopath string, meta Metadata, skipTargets string, local bool) (err error) {
-	logrus.Debugf("Checking to see that gox is installed.")
-
-	// Install gox if it's not already there
-	if _, err := os.Stat(filepath.Join(gopath, "bin/gox")); os.IsNotExist(err) {
-		err = GoxInstall(gopath)
-		if err != nil {
-			err = errors.Wrap(err, "Failed to install gox")
-			return err
-		}
-	}
-
 	var wd string
 
 	if local {
@@ -243,10 +205,6 @@ func (g Golang) Build(gopath string, meta Metadata, skipTargets string, local bo
 		}
 	}
 
-	gox := fmt.Sprintf("%s/bin/gox", gopath)
-
-	logrus.Debugf("Gox is: %s", gox)
-
 	var metadatapath string
 	if local {
 		metadatapath = fmt.Sprintf("%s/%s", wd, METADATA_FILENAME)
@@ -271,6 +229,8 @@ func (g Golang) Build(gopath string, meta Metadata, skipTargets string, local bo
 		}
 	}
 
+	targets := make([]BuildTarget, 0)
+
 	for _, target := range md.BuildInfo.Targets {
 		// skip this target if we're told to do so
 		_, skip := skipTargetsMap[target.Name]
@@ -278,70 +238,459 @@ func (g Golang) Build(gopath string, meta Metadata, skipTargets string, local bo
 			continue
 		}
 
-		logrus.Debugf("Building target: %q in dir %s", target.Name, wd)
+		targets = append(targets, target)
+	}
 
-		// This gets weird because go's exec shell doesn't like the arg format that gox expects
-		// Building it thusly keeps the various quoting levels straight
+	// parallelism from the metadata we were called with (which includes command line overrides) wins over what's in the checked out code
+	parallelism := md.BuildInfo.Parallelism
+	if meta.BuildInfo.Parallelism > 0 {
+		parallelism = meta.BuildInfo.Parallelism
+	}
+
+	if parallelism < 1 {
+		parallelism = runtime.NumCPU()
+	}
 
-		runenv := os.Environ()
+	// as with parallelism, the builder from the metadata we were called with wins
+	builder := md.BuildInfo.Builder
+	if meta.BuildInfo.Builder != "" {
+		builder = meta.BuildInfo.Builder
+	}
 
-		if !local {
-			gopathenv := fmt.Sprintf("GOPATH=%s", gopath)
-			runenv = append(runenv, gopathenv)
+	var buildTarget func(target BuildTarget, stdout io.Writer, stderr io.Writer) error
+
+	switch builder {
+	case BuilderGox:
+		logrus.Debugf("Checking to see that gox is installed.")
+
+		// Install gox if it's not already there
+		if _, err := os.Stat(filepath.Join(gopath, "bin/gox")); os.IsNotExist(err) {
+			err = GoxInstall(gopath)
+			if err != nil {
+				err = errors.Wrap(err, "Failed to install gox")
+				return err
+			}
 		}
 
-		// allow user to turn off go modules
-		if !target.Legacy {
-			runenv = append(runenv, "GO111MODULE=on")
+		gox := fmt.Sprintf("%s/bin/gox", gopath)
+
+		logrus.Debugf("Gox is: %s", gox)
+
+		buildTarget = func(target BuildTarget, stdout io.Writer, stderr io.Writer) error {
+			return GoxBuildTarget(gox, gopath, wd, target, md.BuildInfo.Binaries, local, stdout, stderr)
 		}
 
-		cgo := ""
-		// build with cgo if we're told to do so.
-		if target.Cgo {
-			cgo = " -cgo"
+	case BuilderGo, "":
+		buildTarget = func(target BuildTarget, stdout io.Writer, stderr io.Writer) error {
+			return GoBuildTarget(gopath, wd, target, md.BuildInfo.Binaries, local, stdout, stderr)
 		}
 
-		for k, v := range target.Flags {
-			runenv = append(runenv, fmt.Sprintf("%s=%s", k, v))
-			logrus.Debugf("Build Flag: %s=%s", k, v)
+	default:
+		err = errors.New(fmt.Sprintf("unsupported builder %q", builder))
+		return err
+	}
+
+	logrus.Debugf("Building %d targets with parallelism %d", len(targets), parallelism)
+
+	results := make([]BuildResult, len(targets))
+	outputLock := &sync.Mutex{}
+	workers := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+
+	for i, target := range targets {
+		wg.Add(1)
+
+		go func(i int, target BuildTarget) {
+			defer wg.Done()
+
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			prefix := fmt.Sprintf("[%s] ", target.Name)
+			stdout := &prefixWriter{prefix: prefix, out: os.Stdout, lock: outputLock}
+			stderr := &prefixWriter{prefix: prefix, out: os.Stderr, lock: outputLock}
+
+			err := buildTarget(target, stdout, stderr)
+
+			_ = stdout.Flush()
+			_ = stderr.Flush()
+
+			results[i] = BuildResult{
+				Target: target.Name,
+				Err:    err,
+			}
+		}(i, target)
+	}
+
+	wg.Wait()
+
+	err = BuildResults(results)
+	if err != nil {
+		return err
+	}
+
+	err = BuildExtras(md, wd)
+	if err != nil {
+		err = errors.Wrapf(err, "Failed to build extras")
+		return err
+	}
+
+	return err
+}
+
+// GoBuildTarget builds the given binaries for a single target with 'go build'.  If no binaries are given, every main package in wd is built, and named <dir>_<os>_<arch>, the same as gox would name them.
+func GoBuildTarget(gopath string, wd string, target BuildTarget, binaries []Binary, local bool, stdout io.Writer, stderr io.Writer) (err error) {
+	logrus.Debugf("Building target: %q in dir %s", target.Name, wd)
+
+	archparts := strings.Split(target.Name, "/")
+	if len(archparts) != 2 {
+		err = errors.New(fmt.Sprintf("invalid build target %q.  Targets must be of the form <os>/<arch>", target.Name))
+		return err
+	}
+
+	osname := archparts[0]
+	archname := archparts[1]
+
+	runenv := os.Environ()
+
+	if !local {
+		runenv = append(runenv, fmt.Sprintf("GOPATH=%s", gopath))
+	}
+
+	// allow user to turn off go modules
+	if !target.Legacy {
+		runenv = append(runenv, "GO111MODULE=on")
+	}
+
+	runenv = append(runenv, fmt.Sprintf("GOOS=%s", osname), fmt.Sprintf("GOARCH=%s", archname))
+
+	// build with cgo only if we're told to do so, same as gox.
+	if target.Cgo {
+		runenv = append(runenv, "CGO_ENABLED=1")
+	} else {
+		runenv = append(runenv, "CGO_ENABLED=0")
+	}
+
+	for k, v := range target.Flags {
+		runenv = append(runenv, fmt.Sprintf("%s=%s", k, v))
+		logrus.Debugf("Build Flag: %s=%s", k, v)
+	}
+
+	if len(binaries) == 0 {
+		mainPackages, err := MainPackages(wd, runenv)
+		if err != nil {
+			err = errors.Wrapf(err, "failed finding main packages for target %s", target.Name)
+			return err
+		}
+
+		if len(mainPackages) == 0 {
+			err = errors.New(fmt.Sprintf("no main packages found in %s for target %s", wd, target.Name))
+			return err
 		}
 
-		ldflags := ""
+		for _, pkgDir := range mainPackages {
+			binaries = append(binaries, Binary{Package: pkgDir})
+		}
+	}
+
+	outputs, err := BinaryOutputs(binaries, wd, osname, archname)
+	if err != nil {
+		err = errors.Wrapf(err, "failed naming binaries for target %s", target.Name)
+		return err
+	}
+
+	for _, binary := range outputs {
+		output := filepath.Join(wd, binary.File)
+
+		args := []string{"build", "-o", output}
+
 		if target.Ldflags != "" {
-			ldflags = fmt.Sprintf(" -ldflags %q ", target.Ldflags)
+			// ldflags went through a shell when built with gox.  Interpolate env vars the same way.
+			ldflags := os.Expand(target.Ldflags, envLookup(runenv))
 			logrus.Debugf("LD Flag: %s", ldflags)
+			args = append(args, "-ldflags", ldflags)
+		}
+
+		args = append(args, binary.Package)
+
+		logrus.Debugf("Running go %s in dir %s", strings.Join(args, " "), wd)
+
+		cmd := exec.Command("go", args...)
+
+		cmd.Dir = wd
+		cmd.Env = runenv
+
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		err = cmd.Run()
+		if err != nil {
+			err = errors.Wrapf(err, "failed building %s for target %s", binary.Package, target.Name)
+			return err
+		}
+
+		logrus.Debugf("Built %s", output)
+	}
+
+	return err
+}
+
+// MainPackages lists the directories of the main packages in and below wd.
+func MainPackages(wd string, runenv []string) (dirs []string, err error) {
+	dirs = make([]string, 0)
+
+	cmd := exec.Command("go", "list", "-e", "-f", "{{if eq .Name \"main\"}}{{.Dir}}{{end}}", "./...")
+
+	cmd.Dir = wd
+	cmd.Env = runenv
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		err = errors.Wrapf(err, "failed running 'go list' in %s", wd)
+		return dirs, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			dirs = append(dirs, line)
+		}
+	}
+
+	return dirs, err
+}
+
+// DefaultBinaryOutput is the name binaries are given if their output template isn't set.  It's the same as what gox does by default.
+const DefaultBinaryOutput = "{{.Name}}_{{.OS}}_{{.Arch}}{{.Ext}}"
+
+// BinaryOutput is a Binary, and the name of the file it's built into for a particular target.
+type BinaryOutput struct {
+	Package string
+	File    string
+}
+
+// BinaryNameData is the data binary output templates are filled with.
+type BinaryNameData struct {
+	Name string
+	OS   string
+	Arch string
+	Ext  string
+}
+
+// BinaryOutputs figures out the file names the given binaries are built into for a target, relative to wd.  Output names must be unique, or binaries would overwrite each other.
+func BinaryOutputs(binaries []Binary, wd string, osname string, archname string) (outputs []BinaryOutput, err error) {
+	outputs = make([]BinaryOutput, 0)
+	seen := make(map[string]string)
+
+	for _, binary := range binaries {
+		if binary.Package == "" {
+			err = errors.New("binary is missing its package")
+			return outputs, err
+		}
+
+		outputTemplate := binary.Output
+		if outputTemplate == "" {
+			outputTemplate = DefaultBinaryOutput
 		}
 
-		// Interesting idea, but breaks multiple binary builds such as dbt.  To properly implement, we'd have to find and handle each binary instead of relying on the './...'.
-		//outputTemplate := fmt.Sprintf("%s_{{.OS}}_{{.Arch}}", meta.Name)
-		//args := gox + cgo + ldflags + ` -osarch="` + target.Name + `"` + ` -output="` + outputTemplate + `"` + " ./..."
+		tmpl, err := template.New(binary.Package).Parse(outputTemplate)
+		if err != nil {
+			err = errors.Wrapf(err, "syntax error in output template %q for %s", outputTemplate, binary.Package)
+			return outputs, err
+		}
 
-		args := gox + cgo + ldflags + ` -osarch="` + target.Name + `"` + " ./..."
+		// Package can be a relative dir like './cmd/foo', an absolute dir, or an import path.  In every case, the last element is the name.
+		data := BinaryNameData{
+			Name: filepath.Base(filepath.Join(wd, binary.Package)),
+			OS:   osname,
+			Arch: archname,
+			Ext:  ExecutableExtension(osname),
+		}
+
+		buf := new(bytes.Buffer)
+
+		err = tmpl.Execute(buf, data)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to fill output template %q for %s", outputTemplate, binary.Package)
+			return outputs, err
+		}
+
+		file := buf.String()
+
+		if file == "" || strings.ContainsAny(file, "/\\") {
+			err = errors.New(fmt.Sprintf("output template %q for %s makes invalid file name %q", outputTemplate, binary.Package, file))
+			return outputs, err
+		}
 
+		if other, ok := seen[file]; ok {
+			err = errors.New(fmt.Sprintf("%s and %s would both be built into %s", other, binary.Package, file))
+			return outputs, err
+		}
+
+		seen[file] = binary.Package
+
+		outputs = append(outputs, BinaryOutput{Package: binary.Package, File: file})
+	}
+
+	return outputs, err
+}
+
+// ExecutableExtension returns the file extension executables have on the given OS.
+func ExecutableExtension(osname string) string {
+	if osname == "windows" {
+		return ".exe"
+	}
+
+	return ""
+}
+
+// envLookup returns a function that looks up variables in an environment list such as os.Environ().  Later entries win, as they do with exec.
+func envLookup(env []string) func(string) string {
+	vars := make(map[string]string)
+
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			vars[parts[0]] = parts[1]
+		}
+	}
+
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+// GoxBuildTarget builds a single target with gox.  Output from gox goes to the given writers.
+func GoxBuildTarget(gox string, gopath string, wd string, target BuildTarget, binaries []Binary, local bool, stdout io.Writer, stderr io.Writer) (err error) {
+	logrus.Debugf("Building target: %q in dir %s", target.Name, wd)
+
+	// This gets weird because go's exec shell doesn't like the arg format that gox expects
+	// Building it thusly keeps the various quoting levels straight
+
+	runenv := os.Environ()
+
+	if !local {
+		gopathenv := fmt.Sprintf("GOPATH=%s", gopath)
+		runenv = append(runenv, gopathenv)
+	}
+
+	// allow user to turn off go modules
+	if !target.Legacy {
+		runenv = append(runenv, "GO111MODULE=on")
+	}
+
+	cgo := ""
+	// build with cgo if we're told to do so.
+	if target.Cgo {
+		cgo = " -cgo"
+	}
+
+	for k, v := range target.Flags {
+		runenv = append(runenv, fmt.Sprintf("%s=%s", k, v))
+		logrus.Debugf("Build Flag: %s=%s", k, v)
+	}
+
+	ldflags := ""
+	if target.Ldflags != "" {
+		ldflags = fmt.Sprintf(" -ldflags %q ", target.Ldflags)
+		logrus.Debugf("LD Flag: %s", ldflags)
+	}
+
+	argsList := make([]string, 0)
+
+	if len(binaries) == 0 {
+		argsList = append(argsList, gox+cgo+ldflags+` -osarch="`+target.Name+`"`+" ./...")
+	} else {
+		// With an explicit list of binaries, we build each one with its own output name.
+		archparts := strings.Split(target.Name, "/")
+		if len(archparts) != 2 {
+			err = errors.New(fmt.Sprintf("invalid build target %q.  Targets must be of the form <os>/<arch>", target.Name))
+			return err
+		}
+
+		outputs, err := BinaryOutputs(binaries, wd, archparts[0], archparts[1])
+		if err != nil {
+			err = errors.Wrapf(err, "failed naming binaries for target %s", target.Name)
+			return err
+		}
+
+		for _, binary := range outputs {
+			// gox puts '.exe' on windows binaries itself
+			output := strings.TrimSuffix(binary.File, ExecutableExtension(archparts[0]))
+			argsList = append(argsList, gox+cgo+ldflags+` -osarch="`+target.Name+`"`+` -output="`+output+`" `+binary.Package)
+		}
+	}
+
+	for _, args := range argsList {
 		logrus.Debugf("Running gox with: %s in dir %s", args, wd)
 
 		// Calling it through sh makes everything happy
 		cmd := exec.Command("sh", "-c", args)
 
+		cmd.Dir = wd
 		cmd.Env = runenv
 
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
 
 		err = cmd.Run()
 		if err != nil {
 			err = errors.Wrapf(err, "failed building target %s", target.Name)
 			return err
 		}
+	}
+
+	logrus.Debugf("Gox build of target %s complete and successful.", target.Name)
+
+	return err
+}
+
+// BuildResult is the outcome of building a single target.
+type BuildResult struct {
+	Target string
+	Err    error
+}
+
+// BuildError is returned when one or more targets fail to build.  It carries the results of all the targets, not just the ones that failed.
+type BuildError struct {
+	Results []BuildResult
+}
+
+// Error lists the result of every target, successful or not.
+func (e BuildError) Error() string {
+	failed := 0
+	results := make([]string, 0)
+
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed++
+			results = append(results, fmt.Sprintf("%s: %s", r.Target, r.Err))
+			continue
+		}
 
-		logrus.Debugf("Gox build of target %s complete and successful.", target.Name)
+		results = append(results, fmt.Sprintf("%s: ok", r.Target))
 	}
 
-	err = BuildExtras(md, wd)
-	if err != nil {
-		err = errors.Wrapf(err, "Failed to build extras")
-		return err
+	return fmt.Sprintf("failed building %d of %d targets (%s)", failed, len(e.Results), strings.Join(results, "; "))
+}
+
+// BuildResults logs the results of building each target, and returns a BuildError if any of them failed.
+func BuildResults(results []BuildResult) (err error) {
+	failed := false
+
+	for _, r := range results {
+		if r.Err != nil {
+			failed = true
+			logrus.Errorf("Build of target %s failed: %s", r.Target, r.Err)
+			continue
+		}
+
+		logrus.Debugf("Build of target %s succeeded.", r.Target)
+	}
+
+	if failed {
+		err = BuildError{Results: results}
 	}
 
 	return err
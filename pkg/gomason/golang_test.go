@@ -3,9 +3,11 @@ package gomason
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -348,3 +350,201 @@ Expire-Date: 0
 		t.Errorf("Extra artifact processing failed: %s", err)
 	}
 }
+
+func TestBuildResults(t *testing.T) {
+	inputs := []struct {
+		name     string
+		results  []BuildResult
+		expected string
+	}{
+		{
+			"all ok",
+			[]BuildResult{
+				{Target: "linux/amd64"},
+				{Target: "darwin/amd64"},
+			},
+			"",
+		},
+		{
+			"one failed",
+			[]BuildResult{
+				{Target: "linux/amd64"},
+				{Target: "darwin/amd64", Err: errors.New("exit status 1")},
+				{Target: "windows/amd64"},
+			},
+			"failed building 1 of 3 targets (linux/amd64: ok; darwin/amd64: exit status 1; windows/amd64: ok)",
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := BuildResults(tc.results)
+			if tc.expected == "" {
+				assert.Nil(t, err, "No error when all targets build")
+				return
+			}
+
+			if assert.NotNil(t, err, "Error when a target fails") {
+				assert.Equal(t, tc.expected, err.Error(), "Combined error meets expectations")
+
+				buildErr, ok := err.(BuildError)
+				assert.True(t, ok, "Error is a BuildError")
+				assert.Equal(t, tc.results, buildErr.Results, "Error carries all results")
+			}
+		})
+	}
+}
+
+func TestGoBuildTarget(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.mod":           "module example.com/fixture\n\ngo 1.16\n",
+		"lib/lib.go":       "package lib\n\n// Version is set at build time\nvar Version = \"dev\"\n",
+		"cmd/foo/main.go":  "package main\n\nimport \"example.com/fixture/lib\"\n\nfunc main() { println(lib.Version) }\n",
+		"cmd/bar/main.go":  "package main\n\nfunc main() {}\n",
+		"cmd/bar/other.go": "package main\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("Error creating dir for %s: %s", name, err)
+		}
+
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", name, err)
+		}
+	}
+
+	inputs := []struct {
+		name      string
+		target    BuildTarget
+		binaries  []Binary
+		artifacts []string
+	}{
+		{
+			"linux",
+			BuildTarget{Name: "linux/amd64", Ldflags: "-X example.com/fixture/lib.Version=${FIXTURE_VERSION}", Flags: map[string]string{"FIXTURE_VERSION": "1.2.3"}},
+			nil,
+			[]string{"foo_linux_amd64", "bar_linux_amd64"},
+		},
+		{
+			"windows",
+			BuildTarget{Name: "windows/amd64"},
+			nil,
+			[]string{"foo_windows_amd64.exe", "bar_windows_amd64.exe"},
+		},
+		{
+			"explicit-binaries",
+			BuildTarget{Name: "darwin/arm64"},
+			[]Binary{{Package: "./cmd/foo", Output: "fixture-{{.Name}}-{{.OS}}-{{.Arch}}{{.Ext}}"}},
+			[]string{"fixture-foo-darwin-arm64"},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := GoBuildTarget("", dir, tc.target, tc.binaries, true, os.Stdout, os.Stderr)
+			if err != nil {
+				t.Fatalf("Error building %s: %s", tc.target.Name, err)
+			}
+
+			for _, artifact := range tc.artifacts {
+				_, err := os.Stat(filepath.Join(dir, artifact))
+				assert.Nil(t, err, "%s was built", artifact)
+			}
+		})
+	}
+
+	if runtime.GOOS == "linux" && runtime.GOARCH == "amd64" {
+		out, err := exec.Command(filepath.Join(dir, "foo_linux_amd64")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error running built binary: %s", err)
+		}
+
+		assert.Equal(t, "1.2.3\n", string(out), "ldflags were expanded from the build flags")
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "bar_darwin_arm64"))
+	assert.True(t, os.IsNotExist(err), "Binaries that aren't listed aren't built")
+
+	err = GoBuildTarget("", dir, BuildTarget{Name: "linux"}, nil, true, os.Stdout, os.Stderr)
+	assert.NotNil(t, err, "Malformed target is an error")
+}
+
+func TestBinaryOutputs(t *testing.T) {
+	inputs := []struct {
+		name     string
+		binaries []Binary
+		osname   string
+		archname string
+		expected []BinaryOutput
+		err      bool
+	}{
+		{
+			"default",
+			[]Binary{{Package: "./cmd/foo"}, {Package: "."}},
+			"linux",
+			"amd64",
+			[]BinaryOutput{
+				{Package: "./cmd/foo", File: "foo_linux_amd64"},
+				{Package: ".", File: "myproject_linux_amd64"},
+			},
+			false,
+		},
+		{
+			"template",
+			[]Binary{{Package: "github.com/nikogura/myproject/cmd/bar", Output: "{{.Name}}-{{.OS}}-{{.Arch}}{{.Ext}}"}},
+			"windows",
+			"amd64",
+			[]BinaryOutput{
+				{Package: "github.com/nikogura/myproject/cmd/bar", File: "bar-windows-amd64.exe"},
+			},
+			false,
+		},
+		{
+			"duplicate",
+			[]Binary{{Package: "./cmd/foo", Output: "tool"}, {Package: "./cmd/bar", Output: "tool"}},
+			"linux",
+			"amd64",
+			nil,
+			true,
+		},
+		{
+			"path in output",
+			[]Binary{{Package: "./cmd/foo", Output: "bin/{{.Name}}"}},
+			"linux",
+			"amd64",
+			nil,
+			true,
+		},
+		{
+			"no package",
+			[]Binary{{Output: "foo"}},
+			"linux",
+			"amd64",
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			outputs, err := BinaryOutputs(tc.binaries, "/tmp/go/src/myproject", tc.osname, tc.archname)
+			if tc.err {
+				assert.NotNil(t, err, "Invalid binaries are an error")
+				return
+			}
+
+			assert.Nil(t, err, "No error naming binaries")
+			assert.Equal(t, tc.expected, outputs, "Outputs meet expectations")
+		})
+	}
+}
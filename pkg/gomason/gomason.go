@@ -3,11 +3,14 @@ package gomason
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -33,11 +36,14 @@ type Metadata struct {
 	Repository     string                 `json:"repository"`
 	ToolRepository string                 `json:"tool-repository"`
 	InsecureGet    bool                   `json:"insecure_get"`
+	GitUrl         string                 `json:"git-url,omitempty"`
 	Language       string                 `json:"language,omitempty"`
 	BuildInfo      BuildInfo              `json:"building,omitempty"`
 	SignInfo       SignInfo               `json:"signing,omitempty"`
 	PublishInfo    PublishInfo            `json:"publishing,omitempty"`
 	Options        map[string]interface{} `json:"options,omitempty"`
+	Commit         string                 `json:"-"`
+	LocalRepo      string                 `json:"-"`
 }
 
 // GetLanguage returns the language set in metadata, or the default 'golang'.
@@ -54,6 +60,9 @@ func (m Metadata) GetLanguage() (lang string) {
 // Gomason Object that does all the building
 type Gomason struct {
 	Config UserConfig
+	DryRun bool
+	// Results of every file handled so far, in the order they were handled.  The release manifest is made from these.
+	Results []ArtifactResult
 }
 
 // NewGomason creates a new Gomason object for the current user
@@ -82,6 +91,15 @@ type BuildInfo struct {
 	PrepCommands []string        `json:"prepcommands,omitempty"`
 	Targets      []BuildTarget   `json:"targets,omitempty"`
 	Extras       []ExtraArtifact `json:"extras,omitempty"`
+	Parallelism  int             `json:"parallelism,omitempty"`
+	Builder      string          `json:"builder,omitempty"`
+	Binaries     []Binary        `json:"binaries,omitempty"`
+}
+
+// Binary is a main package to build for each target, and the template for the name of the file it's built into.  The template can use {{.Name}}, {{.OS}}, {{.Arch}}, and {{.Ext}}.  Name is the last element of the package path, and Ext is '.exe' on windows.
+type Binary struct {
+	Package string `json:"package"`
+	Output  string `json:"output,omitempty"`
 }
 
 // BuildTarget contains information on each build target
@@ -102,8 +120,24 @@ type ExtraArtifact struct {
 
 // SignInfo holds information used for signing your binaries.
 type SignInfo struct {
-	Program string `json:"program"`
-	Email   string `json:"email"`
+	Program    string            `json:"program"`
+	Email      string            `json:"email"`
+	Keyring    string            `json:"keyring,omitempty"`
+	Trustdb    string            `json:"trustdb,omitempty"`
+	Identities []SigningIdentity `json:"identities,omitempty"`
+	Allowed    []string          `json:"allowed,omitempty"`
+}
+
+// SigningIdentity is one of the identities artifacts are signed as, e.g. a team release key, or the key of the person doing the release.  Each makes its own signature file, named '<file>.<name><suffix>'.  KeyFile, CertFile and PassphraseFunc only ever come from ~/.gomason.
+type SigningIdentity struct {
+	Name           string `json:"name"`
+	Program        string `json:"program,omitempty"`
+	Email          string `json:"email,omitempty"`
+	Keyring        string `json:"keyring,omitempty"`
+	Trustdb        string `json:"trustdb,omitempty"`
+	KeyFile        string `json:"-"`
+	CertFile       string `json:"-"`
+	PassphraseFunc string `json:"-"`
 }
 
 // PublishInfo holds information for publishing
@@ -115,20 +149,46 @@ type PublishInfo struct {
 	UsernameFunc string                   `json:"usernamefunc"`
 	PasswordFunc string                   `json:"passwordfunc"`
 	SkipSigning  bool                     `json:"skip-signing"`
+	Parallelism  int                      `json:"parallelism,omitempty"`
+	Retry        RetryPolicy              `json:"retry,omitempty"`
+	Manifest     string                   `json:"manifest,omitempty"`
+	Sha256Sums   string                   `json:"sha256sums,omitempty"`
+	AWS          AWSConfig                `json:"aws,omitempty"`
 }
 
 // PublishTarget  a struct representing an individual file to upload
 type PublishTarget struct {
-	Source      string `json:"src"`
-	Destination string `json:"dst"`
-	Signature   bool   `json:"sig"`
-	Checksums   bool   `json:"checksums"`
+	Source      string    `json:"src"`
+	Destination string    `json:"dst"`
+	Signature   bool      `json:"sig"`
+	Checksums   bool      `json:"checksums"`
+	AWS         AWSConfig `json:"aws,omitempty"`
+}
+
+// AWSConfig holds the AWS settings for publishing to S3, or to something that talks like it, such as MinIO or Ceph RGW, and the options objects are created with.  They can be set for the whole repository in the publishing section, and for individual targets, overriding those.
+type AWSConfig struct {
+	Profile              string            `json:"profile,omitempty"`
+	Region               string            `json:"region,omitempty"`
+	Endpoint             string            `json:"endpoint,omitempty"`
+	PathStyle            bool              `json:"path-style,omitempty"`
+	RoleARN              string            `json:"role-arn,omitempty"`
+	ExternalID           string            `json:"external-id,omitempty"`
+	RoleSessionName      string            `json:"role-session-name,omitempty"`
+	ServerSideEncryption string            `json:"sse,omitempty"`
+	SSEKMSKeyID          string            `json:"sse-kms-key-id,omitempty"`
+	StorageClass         string            `json:"storage-class,omitempty"`
+	ACL                  string            `json:"acl,omitempty"`
+	ContentType          string            `json:"content-type,omitempty"`
+	CacheControl         string            `json:"cache-control,omitempty"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	SkipFolders          bool              `json:"skip-folders,omitempty"`
 }
 
 // UserConfig a struct representing the information stored in ~/.gomason
 type UserConfig struct {
-	User    UserInfo
-	Signing UserSignInfo
+	User       UserInfo
+	Signing    UserSignInfo
+	Identities map[string]UserSignInfo
 }
 
 // UserInfo  information from the user section in ~/.gomason
@@ -140,9 +200,13 @@ type UserInfo struct {
 	PasswordFunc string
 }
 
-// UserSignInfo  information from the signing section in ~/.gomason
+// UserSignInfo  information from the signing section in ~/.gomason, or from a 'signing.<name>' section for a signing identity
 type UserSignInfo struct {
-	Program string
+	Program        string
+	Email          string
+	KeyFile        string
+	CertFile       string
+	PassphraseFunc string
 }
 
 // HandleArtifacts loops over the expected files built by Build() and optionally signs them and publishes them along with their signatures (if signing).
@@ -150,6 +214,8 @@ type UserSignInfo struct {
 // If not publishing, the binaries (and their optional signatures) are collected and dumped into the directory where gomason was called. (Typically the root of a go project).
 func (g *Gomason) HandleArtifacts(meta Metadata, gopath string, cwd string, sign bool, publish bool, collect bool, skipTargets string, local bool) (err error) {
 	logrus.Debug("Handling Artifacts\n")
+	filenames := make([]string, 0)
+
 	// loop through the built things for each type of build target
 	skipTargetsMap := make(map[string]int)
 
@@ -187,6 +253,28 @@ func (g *Gomason) HandleArtifacts(meta Metadata, gopath string, cwd string, sign
 			workdir = fmt.Sprintf("%s/src/%s", gopath, meta.Package)
 		}
 
+		// if we were told exactly what was built, that's what we handle.  Nothing more, nothing less.
+		if len(meta.BuildInfo.Binaries) > 0 {
+			outputs, err := BinaryOutputs(meta.BuildInfo.Binaries, workdir, osname, archname)
+			if err != nil {
+				err = errors.Wrapf(err, "failed naming binaries for target %s", target.Name)
+				return err
+			}
+
+			for _, output := range outputs {
+				filename := filepath.Join(workdir, output.File)
+
+				if _, err := os.Stat(filename); os.IsNotExist(err) {
+					err = errors.Wrapf(err, "failed building binary: %s\n", filename)
+					return err
+				}
+
+				filenames = append(filenames, filename)
+			}
+
+			continue
+		}
+
 		files, err := os.ReadDir(workdir)
 		if err != nil {
 			err = errors.Wrap(err, fmt.Sprintf("failed to read dir %s", workdir))
@@ -202,48 +290,18 @@ func (g *Gomason) HandleArtifacts(meta Metadata, gopath string, cwd string, sign
 			if matched {
 				filename := fmt.Sprintf("%s/%s", workdir, file.Name())
 
-				logrus.Debugf("Handling %s", filename)
-
 				if _, err := os.Stat(filename); os.IsNotExist(err) {
 					err = errors.Wrapf(err, "failed building binary: %s\n", filename)
 					return err
 				}
 
-				// sign 'em if we're signing
-				if sign {
-					logrus.Debugf("Signing %s", filename)
-					err = g.SignBinary(meta, filename)
-					if err != nil {
-						err = errors.Wrapf(err, "failed to sign binary %s", filename)
-						return err
-					}
-				}
-
-				// publish and return if we're publishing
-				if publish {
-					logrus.Debugf("Publishing %s", filename)
-					err = g.PublishFile(meta, filename)
-					if err != nil {
-						err = errors.Wrap(err, "failed to publish binary")
-						return err
-					}
-				}
-
-				// Collect up the stuff we built, and dump 'em into the cwd where we called gomason
-				if collect {
-					logrus.Debugf("Collecting %s", filename)
-					err := CollectFileAndSignature(cwd, filename)
-					if err != nil {
-						err = errors.Wrap(err, "failed to collect binaries")
-						return err
-					}
-				}
+				filenames = append(filenames, filename)
 			}
 		}
 
 	}
 
-	return err
+	return g.HandleFiles(meta, filenames, cwd, sign, publish, collect)
 }
 
 // HandleExtras loops over the expected files built by Build() and optionally signs them and publishes them along with their signatures (if signing).
@@ -251,6 +309,8 @@ func (g *Gomason) HandleArtifacts(meta Metadata, gopath string, cwd string, sign
 // If not publishing, the binaries (and their optional signatures) are collected and dumped into the directory where gomason was called. (Typically the root of a go project).
 func (g *Gomason) HandleExtras(meta Metadata, gopath string, cwd string, sign bool, publish bool, collect bool, local bool) (err error) {
 
+	filenames := make([]string, 0)
+
 	// loop through the built things for each type of build target
 	for _, extra := range meta.BuildInfo.Extras {
 		logrus.Debugf("Processing build extra: %s", extra.Template)
@@ -277,37 +337,173 @@ func (g *Gomason) HandleExtras(meta Metadata, gopath string, cwd string, sign bo
 			return err
 		}
 
-		// sign 'em if we're signing
-		if sign {
-			logrus.Debugf("Signing %s", filename)
-			err = g.SignBinary(meta, filename)
+		filenames = append(filenames, filename)
+	}
+
+	return g.HandleFiles(meta, filenames, cwd, sign, publish, collect)
+}
+
+// ArtifactResult is the outcome of signing, publishing and collecting a single file.
+type ArtifactResult struct {
+	File    string
+	Signed  bool
+	Uploads []UploadResult
+	Err     error
+}
+
+// HandleFiles optionally signs, publishes and collects each of the given files.  Files are handled concurrently, per 'parallelism' in the publishing section of the metadata file.  A failure with one file doesn't stop the others.  When publishing, a summary of what did and did not get uploaded is printed at the end.
+func (g *Gomason) HandleFiles(meta Metadata, filenames []string, cwd string, sign bool, publish bool, collect bool) (err error) {
+	// just show what we would do if this is a dry run
+	if g.DryRun {
+		for _, filename := range filenames {
+			err = g.PrintPlan(meta, filename, sign, publish)
 			if err != nil {
-				err = errors.Wrapf(err, "failed to sign extra artifact %s", filename)
+				err = errors.Wrapf(err, "failed to plan publishing of %s", filename)
 				return err
 			}
 		}
 
-		// publish and return if we're publishing
-		if publish {
-			logrus.Debugf("Publishing %s", filename)
-			err = g.PublishFile(meta, filename)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to publish extra artifact %s", filename)
-				return err
-			}
+		return err
+	}
+
+	parallelism := meta.PublishInfo.Parallelism
+	if parallelism < 1 {
+		parallelism = runtime.NumCPU()
+	}
+
+	results := make([]ArtifactResult, len(filenames))
+	workers := make(chan struct{}, parallelism)
+	wg := sync.WaitGroup{}
+
+	for i, filename := range filenames {
+		wg.Add(1)
+
+		go func(i int, filename string) {
+			defer wg.Done()
+
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			results[i] = g.HandleFile(meta, filename, cwd, sign, publish, collect)
+		}(i, filename)
+	}
 
+	wg.Wait()
+
+	g.Results = append(g.Results, results...)
+
+	if publish && len(results) > 0 {
+		PrintPublishSummary(os.Stdout, results)
+	}
+
+	failed := make([]string, 0)
+
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", filepath.Base(r.File), r.Err))
 		}
+	}
 
-		if collect {
-			// Collect up the stuff we built, and dump 'em into the cwd where we called gomason
-			logrus.Debugf("Collecting %s", filename)
-			err := CollectFileAndSignature(cwd, filename)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to collect binary %s", filename)
-				return err
+	if len(failed) > 0 {
+		err = errors.New(fmt.Sprintf("failed handling %d of %d files: %s", len(failed), len(results), strings.Join(failed, "; ")))
+	}
+
+	return err
+}
+
+// HandleFile signs, publishes, and collects a single file, as requested.
+func (g *Gomason) HandleFile(meta Metadata, filename string, cwd string, sign bool, publish bool, collect bool) (result ArtifactResult) {
+	result = ArtifactResult{
+		File:    filename,
+		Uploads: make([]UploadResult, 0),
+	}
+
+	logrus.Debugf("Handling %s", filename)
+
+	// sign 'em if we're signing
+	if sign {
+		logrus.Debugf("Signing %s", filename)
+		err := g.SignBinary(meta, filename)
+		if err != nil {
+			result.Err = errors.Wrapf(err, "failed to sign %s", filename)
+			return result
+		}
+
+		result.Signed = true
+	}
+
+	// publish and return if we're publishing
+	if publish {
+		logrus.Debugf("Publishing %s", filename)
+		uploads, err := g.PublishFileWithResults(meta, filename)
+		result.Uploads = uploads
+		if err != nil {
+			result.Err = errors.Wrapf(err, "failed to publish %s", filename)
+			return result
+		}
+	}
+
+	// Collect up the stuff we built, and dump 'em into the cwd where we called gomason
+	if collect {
+		logrus.Debugf("Collecting %s", filename)
+
+		sigSuffixes := make([]string, 0)
+
+		identities, err := g.SigningIdentities(meta)
+		if err != nil {
+			result.Err = errors.Wrapf(err, "failed to collect %s", filename)
+			return result
+		}
+
+		for _, identity := range identities {
+			sigSuffixes = append(sigSuffixes, identity.SignatureSuffix())
+		}
+
+		err = CollectFileAndSignature(cwd, filename, sigSuffixes...)
+		if err != nil {
+			result.Err = errors.Wrapf(err, "failed to collect %s", filename)
+			return result
+		}
+	}
+
+	return result
+}
+
+// PrintPublishSummary writes a per-file summary of what was and was not published.
+func PrintPublishSummary(w io.Writer, results []ArtifactResult) {
+	_, _ = fmt.Fprintf(w, "\nPublishing Summary:\n")
+
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = "FAILED"
+		}
+
+		_, _ = fmt.Fprintf(w, "  %s: %s\n", filepath.Base(r.File), status)
+
+		if r.Err != nil && len(r.Uploads) == 0 {
+			_, _ = fmt.Fprintf(w, "    %s\n", r.Err)
+		}
+
+		for _, u := range r.Uploads {
+			if u.Err != nil {
+				_, _ = fmt.Fprintf(w, "    FAILED %s after %d attempts: %s\n", u.Upload.Destination, u.Attempts, u.Err)
+				continue
 			}
+
+			_, _ = fmt.Fprintf(w, "    ok     %s\n", u.Upload.Destination)
 		}
 	}
+}
+
+// PrintPlan prints what signing and publishing the given file would do, without signing or uploading anything.
+func (g *Gomason) PrintPlan(meta Metadata, filename string, sign bool, publish bool) (err error) {
+	plan, err := g.PlanFile(meta, filename, sign, publish)
+	if err != nil {
+		return err
+	}
+
+	plan.Print(os.Stdout)
 
 	return err
 }
@@ -325,8 +521,8 @@ func DebugPrint(filename, tag string) {
 	}
 }
 
-// CollectFileAndSignature grabs a file and the signature if it exists and copies it from the temp workspace into the CWD where gomason was called. Does nothing at all if the file is currently in cwd.
-func CollectFileAndSignature(cwd string, filename string) (err error) {
+// CollectFileAndSignature grabs a file and the signatures with the given suffixes if they exist and copies them from the temp workspace into the CWD where gomason was called. Without any suffixes, signatures by any signing program we know are collected. Does nothing at all if the file is currently in cwd.
+func CollectFileAndSignature(cwd string, filename string, sigSuffixes ...string) (err error) {
 	logrus.Debugf("Collecting Files and Signatures")
 
 	binaryDestinationPath := fmt.Sprintf("%s/%s", cwd, filepath.Base(filename))
@@ -356,31 +552,38 @@ func CollectFileAndSignature(cwd string, filename string) (err error) {
 		}
 	}
 
-	sigName := fmt.Sprintf("%s.asc", filepath.Base(filename))
-	if _, err := os.Stat(sigName); !os.IsNotExist(err) {
-		signatureDestinationPath := fmt.Sprintf("%s/%s", cwd, sigName)
-		if signatureDestinationPath != sigName {
-			fileInfo, err := os.Stat(sigName)
-			if err != nil {
-				err = errors.Wrapf(err, "failed statting file %s", sigName)
-				return err
-			}
-			contents, err := os.ReadFile(sigName)
-			if err != nil {
-				err = errors.Wrapf(err, "failed reading file %q", sigName)
-				return err
-			}
+	// if we don't know who signed it, collect whatever signatures there are
+	if len(sigSuffixes) == 0 {
+		sigSuffixes = SignatureSuffixes()
+	}
 
-			err = os.WriteFile(signatureDestinationPath, contents, fileInfo.Mode())
-			if err != nil {
-				err = errors.Wrapf(err, "failed writing file %s", signatureDestinationPath)
-				return err
-			}
+	for _, suffix := range sigSuffixes {
+		sigName := filepath.Base(filename) + suffix
+		if _, err := os.Stat(sigName); !os.IsNotExist(err) {
+			signatureDestinationPath := fmt.Sprintf("%s/%s", cwd, sigName)
+			if signatureDestinationPath != sigName {
+				fileInfo, err := os.Stat(sigName)
+				if err != nil {
+					err = errors.Wrapf(err, "failed statting file %s", sigName)
+					return err
+				}
+				contents, err := os.ReadFile(sigName)
+				if err != nil {
+					err = errors.Wrapf(err, "failed reading file %q", sigName)
+					return err
+				}
 
-			err = os.Remove(sigName)
-			if err != nil {
-				err = errors.Wrapf(err, "failed removing file %s", sigName)
-				return err
+				err = os.WriteFile(signatureDestinationPath, contents, fileInfo.Mode())
+				if err != nil {
+					err = errors.Wrapf(err, "failed writing file %s", signatureDestinationPath)
+					return err
+				}
+
+				err = os.Remove(sigName)
+				if err != nil {
+					err = errors.Wrapf(err, "failed removing file %s", sigName)
+					return err
+				}
 			}
 		}
 	}
@@ -438,17 +641,38 @@ func GetUserConfig(homedir string) (config UserConfig, err error) {
 
 		signingSection, _ := cfg.GetSection("signing")
 		if signingSection != nil {
-			signSec := UserSignInfo{}
+			config.Signing = userSignInfoFromSection(signingSection)
+		}
 
-			// program section
-			if signingSection.HasKey("program") {
-				key, _ := signingSection.GetKey("program")
-				signSec.Program = key.Value()
-			}
+		// signing identities are in sections named 'signing.<name>'
+		config.Identities = make(map[string]UserSignInfo)
 
-			config.Signing = signSec
+		for _, section := range cfg.Sections() {
+			if strings.HasPrefix(section.Name(), "signing.") {
+				config.Identities[strings.TrimPrefix(section.Name(), "signing.")] = userSignInfoFromSection(section)
+			}
 		}
 	}
 
 	return config, err
 }
+
+// userSignInfoFromSection reads signing information from a section of ~/.gomason.  Only the section's own keys are read, so a 'signing.<name>' section doesn't inherit the key file from 'signing'.
+func userSignInfoFromSection(section *ini.Section) (signSec UserSignInfo) {
+	for _, key := range section.Keys() {
+		switch key.Name() {
+		case "program":
+			signSec.Program = key.Value()
+		case "email":
+			signSec.Email = key.Value()
+		case "keyfile":
+			signSec.KeyFile = key.Value()
+		case "certfile":
+			signSec.CertFile = key.Value()
+		case "passphrasefunc":
+			signSec.PassphraseFunc = key.Value()
+		}
+	}
+
+	return signSec
+}
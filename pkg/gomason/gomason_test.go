@@ -85,6 +85,14 @@ func TestGetUserConfig(t *testing.T) {
 		},
 		Signing: UserSignInfo{
 			Program: "gpg",
+			KeyFile: "/home/nikogura/.gnupg/secring.asc",
+		},
+		// identities don't inherit from 'signing'
+		Identities: map[string]UserSignInfo{
+			"team": {
+				Program: "minisign",
+				Email:   "releases@foo.com",
+			},
 		},
 	}
 
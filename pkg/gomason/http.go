@@ -0,0 +1,218 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	RegisterPublisher("http", HTTPPublisher{})
+	RegisterPublisher("https", HTTPPublisher{})
+}
+
+var hrefRegex = regexp.MustCompile(`(?i)href\s*=\s*"([^"]+)"`)
+
+// HTTPPublisher publishes with plain HTTP requests: PUT to upload, GET to download, and so on.  Uploads carry Artifactory's checksum headers, which other repositories ignore.
+type HTTPPublisher struct {
+	Client *http.Client
+}
+
+func (p HTTPPublisher) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+
+	return &http.Client{}
+}
+
+// Put uploads data to url with an HTTP PUT.
+func (p HTTPPublisher) Put(url string, data io.Reader, checksums Checksums, username string, password string) (err error) {
+	req, err := http.NewRequest("PUT", url, data)
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("failed to create http request for target %s", url))
+		return err
+	}
+
+	// add headers  (Technically these are what Artifactory expects, but should be fine for any REST interface)
+	req.Header.Add("X-Checksum-Md5", checksums.MD5)
+	req.Header.Add("X-Checksum-Sha1", checksums.SHA1)
+	req.Header.Add("X-Checksum-Sha256", checksums.SHA256)
+	req.SetBasicAuth(username, password)
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("Failed to PUT to url %s", url))
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	logrus.Debugf("Response: %s", resp.Status)
+	logrus.Debugf("Response Code: %d", resp.StatusCode)
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return err
+	}
+
+	return err
+}
+
+// Get downloads url into out with an HTTP GET.
+func (p HTTPPublisher) Get(url string, out io.Writer, username string, password string) (err error) {
+	resp, err := p.do("GET", url, username, password)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return err
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", url)
+		return err
+	}
+
+	return err
+}
+
+// Head says whether there's anything at url, with an HTTP HEAD.
+func (p HTTPPublisher) Head(url string, username string, password string) (exists bool, err error) {
+	resp, err := p.do("HEAD", url, username, password)
+	if err != nil {
+		return exists, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return exists, err
+	}
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return exists, err
+	}
+
+	exists = true
+
+	return exists, err
+}
+
+// Delete removes url with an HTTP DELETE.  Deleting something that isn't there isn't an error.
+func (p HTTPPublisher) Delete(url string, username string, password string) (err error) {
+	resp, err := p.do("DELETE", url, username, password)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 && resp.StatusCode != http.StatusNotFound {
+		err = HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return err
+	}
+
+	return err
+}
+
+// List returns the urls of the files in the 'directory' at url.  Plain HTTP has no way of listing things, so this reads the links out of the directory's index page, as served by Artifactory, nginx or Apache.  Only links to things inside the directory are returned.
+func (p HTTPPublisher) List(dirURL string, username string, password string) (urls []string, err error) {
+	urls = make([]string, 0)
+
+	if !strings.HasSuffix(dirURL, "/") {
+		dirURL += "/"
+	}
+
+	base, err := url.Parse(dirURL)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", dirURL)
+		return urls, err
+	}
+
+	resp, err := p.do("GET", dirURL, username, password)
+	if err != nil {
+		return urls, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: dirURL, StatusCode: resp.StatusCode}
+		return urls, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", dirURL)
+		return urls, err
+	}
+
+	seen := make(map[string]bool)
+
+	for _, match := range hrefRegex.FindAllStringSubmatch(string(body), -1) {
+		ref, err := url.Parse(match[1])
+		if err != nil {
+			continue
+		}
+
+		// sorting links and the like
+		if ref.RawQuery != "" || ref.Fragment != "" {
+			continue
+		}
+
+		u := base.ResolveReference(ref)
+
+		if u.Host != base.Host || !strings.HasPrefix(u.Path, base.Path) || u.Path == base.Path {
+			continue
+		}
+
+		if !seen[u.String()] {
+			seen[u.String()] = true
+			urls = append(urls, u.String())
+		}
+	}
+
+	sort.Strings(urls)
+
+	return urls, err
+}
+
+// Method returns 'HTTP PUT'.
+func (HTTPPublisher) Method(url string) string {
+	return "HTTP PUT"
+}
+
+// do makes a request with no body, with basic auth if there are credentials.
+func (p HTTPPublisher) do(method string, url string, username string, password string) (resp *http.Response, err error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create http request for %s", url)
+		return resp, err
+	}
+
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err = p.client().Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to %s %s", method, url)
+		return resp, err
+	}
+
+	return resp, err
+}
@@ -0,0 +1,184 @@
+package gomason
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultManifestFilename is what the manifest file is called locally if a name can't be gotten from its destination.
+const DefaultManifestFilename = "manifest.json"
+
+// Manifest is a record of everything that was released by a publish run.
+type Manifest struct {
+	Package   string             `json:"package"`
+	Name      string             `json:"name,omitempty"`
+	Version   string             `json:"version"`
+	Commit    string             `json:"commit,omitempty"`
+	Created   string             `json:"created"`
+	Builder   ManifestBuilder    `json:"builder"`
+	Artifacts []ManifestArtifact `json:"artifacts"`
+}
+
+// ManifestBuilder identifies who and what built and published a release.
+type ManifestBuilder struct {
+	Gomason string           `json:"gomason"`
+	Go      string           `json:"go"`
+	User    string           `json:"user,omitempty"`
+	Host    string           `json:"host,omitempty"`
+	Signers []ManifestSigner `json:"signers,omitempty"`
+}
+
+// ManifestSigner is one of the identities a release was signed as.
+type ManifestSigner struct {
+	Name        string `json:"name,omitempty"`
+	SignProgram string `json:"sign-program"`
+	SignEntity  string `json:"sign-entity,omitempty"`
+}
+
+// ManifestArtifact is a single published file.
+type ManifestArtifact struct {
+	Name        string   `json:"name"`
+	Destination string   `json:"destination"`
+	Size        int64    `json:"size"`
+	Md5         string   `json:"md5"`
+	Sha1        string   `json:"sha1"`
+	Sha256      string   `json:"sha256"`
+	Signatures  []string `json:"signatures,omitempty"`
+}
+
+// NewManifest makes a manifest from the results of handling files.  Only files that were actually published are included.
+func (g *Gomason) NewManifest(meta Metadata, results []ArtifactResult, commit string, signed bool) (manifest Manifest, err error) {
+	manifest = Manifest{
+		Package:   meta.Package,
+		Name:      meta.Name,
+		Version:   meta.Version,
+		Commit:    commit,
+		Created:   time.Now().UTC().Format(time.RFC3339),
+		Artifacts: make([]ManifestArtifact, 0),
+		Builder: ManifestBuilder{
+			Gomason: VERSION,
+			Go:      runtime.Version(),
+		},
+	}
+
+	// who and where are nice to have, but not worth failing a release over
+	userObj, userErr := user.Current()
+	if userErr != nil {
+		logrus.Debugf("failed getting current user: %s", userErr)
+	} else {
+		manifest.Builder.User = userObj.Username
+	}
+
+	host, hostErr := os.Hostname()
+	if hostErr != nil {
+		logrus.Debugf("failed getting hostname: %s", hostErr)
+	} else {
+		manifest.Builder.Host = host
+	}
+
+	if signed {
+		identities, err := g.SigningIdentities(meta)
+		if err != nil {
+			return manifest, err
+		}
+
+		for _, identity := range identities {
+			manifest.Builder.Signers = append(manifest.Builder.Signers, ManifestSigner{
+				Name:        identity.Name,
+				SignProgram: identity.Program,
+				SignEntity:  identity.Email,
+			})
+		}
+	}
+
+	for _, r := range results {
+		artifact := ManifestArtifact{
+			Name: filepath.Base(r.File),
+		}
+
+		for _, u := range r.Uploads {
+			switch u.Upload.Kind {
+			case UploadKindArtifact:
+				artifact.Destination = u.Upload.Destination
+			case UploadKindSignature:
+				artifact.Signatures = append(artifact.Signatures, u.Upload.Destination)
+			}
+		}
+
+		// not published, so not released
+		if artifact.Destination == "" {
+			continue
+		}
+
+		info, err := os.Stat(r.File)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to stat %s", r.File)
+			return manifest, err
+		}
+
+		artifact.Size = info.Size()
+
+		artifact.Md5, artifact.Sha1, artifact.Sha256, err = AllChecksumsForFile(r.File)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to calculate checksums for %s", r.File)
+			return manifest, err
+		}
+
+		manifest.Artifacts = append(manifest.Artifacts, artifact)
+	}
+
+	return manifest, err
+}
+
+// ManifestTarget returns the publishing target for the manifest, with its destination filled in from the metadata, and what the manifest file should be called locally.
+func ManifestTarget(meta Metadata, signed bool) (target PublishTarget, filename string, err error) {
+	target, filename, err = ReleaseFileTarget(meta, meta.PublishInfo.Manifest, DefaultManifestFilename, signed)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse manifest destination %s", meta.PublishInfo.Manifest)
+		return target, filename, err
+	}
+
+	return target, filename, err
+}
+
+// PublishManifest writes a manifest of the files handled so far into dir, signs it if we're signing, and uploads it, and its signature, to 'manifest' in the publishing section of the metadata.
+func (g *Gomason) PublishManifest(meta Metadata, dir string, commit string, sign bool) (err error) {
+	target, filename, err := ManifestTarget(meta, sign)
+	if err != nil {
+		return err
+	}
+
+	err = g.publishReleaseFile(meta, "manifest", filepath.Join(dir, filename), target, sign, func() (content []byte, err error) {
+		manifest, err := g.NewManifest(meta, g.Results, commit, sign)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create manifest")
+			return content, err
+		}
+
+		content, err = json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			err = errors.Wrapf(err, "failed to marshal manifest")
+			return content, err
+		}
+
+		content = append(content, '\n')
+
+		return content, err
+	})
+
+	if err != nil || g.DryRun {
+		return err
+	}
+
+	fmt.Printf("Published release manifest to %s\n", target.Destination)
+
+	return err
+}
@@ -0,0 +1,109 @@
+package gomason
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishManifest(t *testing.T) {
+	lock := sync.Mutex{}
+	uploads := make(map[string][]byte)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		lock.Lock()
+		uploads[r.URL.Path] = body
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binary := filepath.Join(tmpDir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	meta := testMetadataObj()
+	meta.Repository = fmt.Sprintf("%s/repo", server.URL)
+	meta.PublishInfo.Manifest = "{{.Repository}}/testproject/{{.Version}}/manifest.json"
+
+	dst := fmt.Sprintf("%s/repo/testproject/0.1.0/linux/amd64/testproject", server.URL)
+
+	g := Gomason{
+		Results: []ArtifactResult{
+			{
+				File: binary,
+				Uploads: []UploadResult{
+					{Upload: PlannedUpload{Kind: UploadKindArtifact, Source: binary, Destination: dst}},
+					{Upload: PlannedUpload{Kind: UploadKindSignature, Source: binary + ".asc", Destination: dst + ".asc"}},
+				},
+			},
+			// collected but not published, so not in the manifest
+			{File: filepath.Join(tmpDir, "testproject_darwin_amd64")},
+		},
+	}
+
+	err = g.PublishManifest(meta, tmpDir, "0123456789abcdef0123456789abcdef01234567", false)
+	if err != nil {
+		t.Fatalf("Error publishing manifest: %s", err)
+	}
+
+	body, ok := uploads["/repo/testproject/0.1.0/manifest.json"]
+	if !ok {
+		t.Fatalf("Manifest was not uploaded")
+	}
+
+	var manifest Manifest
+
+	err = json.Unmarshal(body, &manifest)
+	if err != nil {
+		t.Fatalf("Error unmarshalling manifest: %s", err)
+	}
+
+	md5sum, sha1sum, sha256sum, err := AllChecksumsForFile(binary)
+	if err != nil {
+		t.Fatalf("Error getting checksums: %s", err)
+	}
+
+	assert.Equal(t, meta.Package, manifest.Package, "Package is recorded")
+	assert.Equal(t, meta.Version, manifest.Version, "Version is recorded")
+	assert.Equal(t, "0123456789abcdef0123456789abcdef01234567", manifest.Commit, "Commit is recorded")
+	assert.Equal(t, VERSION, manifest.Builder.Gomason, "Builder is recorded")
+	assert.Equal(t, []ManifestArtifact{
+		{
+			Name:        "testproject_linux_amd64",
+			Destination: dst,
+			Size:        int64(len(testFileContent())),
+			Md5:         md5sum,
+			Sha1:        sha1sum,
+			Sha256:      sha256sum,
+			Signatures:  []string{dst + ".asc"},
+		},
+	}, manifest.Artifacts, "Artifacts meet expectations")
+
+	_, err = os.Stat(filepath.Join(tmpDir, "manifest.json"))
+	assert.Nil(t, err, "Manifest was written locally")
+}
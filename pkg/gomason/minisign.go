@@ -0,0 +1,540 @@
+package gomason
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SigningProgramMinisign signs with Ed25519 keys, writing .minisig files that minisign and signify compatible tools can verify.
+const SigningProgramMinisign = "minisign"
+
+// MinisignSignatureSuffix is the suffix of minisign signature files.
+const MinisignSignatureSuffix = ".minisig"
+
+func init() {
+	signersMap[SigningProgramMinisign] = MinisignSigner{}
+}
+
+// MinisignSigner signs and verifies with minisign keys.  Minisign keys aren't tied to an identity, so no signing entity is needed.
+type MinisignSigner struct{}
+
+// Sign signs binary with the identity's minisign secret key file from ~/.gomason.
+func (MinisignSigner) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	return SignMinisign(identity, meta, binary, sigFile)
+}
+
+// Verify verifies the signature of binary against the identity's minisign public key file from the metadata file.
+func (MinisignSigner) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (ok bool, err error) {
+	return VerifyMinisign(identity, binary, sigFile)
+}
+
+// SignatureSuffix returns '.minisig'.
+func (MinisignSigner) SignatureSuffix() string {
+	return MinisignSignatureSuffix
+}
+
+const (
+	minisignUntrustedPrefix = "untrusted comment: "
+	minisignTrustedPrefix   = "trusted comment: "
+)
+
+var (
+	minisignAlgEd        = []byte("Ed")
+	minisignAlgPrehashed = []byte("ED")
+	minisignKdfScrypt    = []byte("Sc")
+	minisignKdfNone      = []byte{0, 0}
+	minisignChkBlake2b   = []byte("B2")
+)
+
+// MinisignSecretKey is a minisign secret key.
+type MinisignSecretKey struct {
+	KeyID      [8]byte
+	PrivateKey ed25519.PrivateKey
+}
+
+// MinisignPublicKey is a minisign public key.
+type MinisignPublicKey struct {
+	KeyID     [8]byte
+	PublicKey ed25519.PublicKey
+}
+
+// MinisignSignature is the content of a .minisig file.
+type MinisignSignature struct {
+	Algorithm        []byte
+	KeyID            [8]byte
+	Signature        []byte
+	UntrustedComment string
+	TrustedComment   string
+	GlobalSignature  []byte
+}
+
+// KeyIDString is the key id as minisign shows it.
+func (k MinisignPublicKey) KeyIDString() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.KeyID[:]))
+}
+
+// SignMinisign signs a given binary with the identity's minisign secret key, writing the signature to sigFile.  The trusted comment records the package and version, as well as the usual timestamp and file name.
+func SignMinisign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	keyFile := identity.KeyFile
+	if keyFile == "" {
+		err = errors.New(fmt.Sprintf("signing with minisign needs 'keyfile' set in the '%s' section of ~/.gomason", identity.ConfigSection()))
+		return err
+	}
+
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", keyFile)
+		return err
+	}
+
+	passphrase := ""
+	if identity.PassphraseFunc != "" {
+		passphrase, err = GetFunc(identity.PassphraseFunc)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get passphrase from passphrasefunc")
+			return err
+		}
+	}
+
+	key, err := ParseMinisignSecretKey(keyBytes, passphrase)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to load minisign key from %s", keyFile)
+		return err
+	}
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", binary)
+		return err
+	}
+
+	trustedComment := fmt.Sprintf("timestamp:%d\tfile:%s\tpackage:%s\tversion:%s\thashed", time.Now().Unix(), filepath.Base(binary), meta.Package, meta.Version)
+
+	sig := key.Sign(data, trustedComment)
+
+	err = os.WriteFile(sigFile, sig.Encode(), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", sigFile)
+		return err
+	}
+
+	logrus.Debugf("Signed %s with minisign key %s", binary, key.Public().KeyIDString())
+
+	return err
+}
+
+// VerifyMinisign verifies a binary's minisign signature in sigFile against the identity's minisign public key file, named as 'keyring' in the metadata file.
+func VerifyMinisign(identity SigningIdentity, binary string, sigFile string) (ok bool, err error) {
+	keyFile := identity.Keyring
+	if keyFile == "" {
+		err = errors.New("verifying with minisign needs 'keyring' set to a public key file in the 'signing' section of the metadata file")
+		return ok, err
+	}
+
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", keyFile)
+		return ok, err
+	}
+
+	key, err := ParseMinisignPublicKey(keyBytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to load minisign public key from %s", keyFile)
+		return ok, err
+	}
+
+	sigBytes, err := os.ReadFile(sigFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", sigFile)
+		return ok, err
+	}
+
+	sig, err := ParseMinisignSignature(sigBytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", sigFile)
+		return ok, err
+	}
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", binary)
+		return ok, err
+	}
+
+	if sig.KeyID != key.KeyID {
+		err = UnknownSignerError{File: binary, Signer: fmt.Sprintf("minisign key %s, not the key in %s", MinisignPublicKey{KeyID: sig.KeyID}.KeyIDString(), keyFile)}
+		return ok, err
+	}
+
+	err = key.Verify(data, sig)
+	if err != nil {
+		err = BadSignatureError{File: binary, Reason: err.Error()}
+		return ok, err
+	}
+
+	logrus.Debugf("Good signature on %s from minisign key %s.  Trusted comment: %s", binary, key.KeyIDString(), sig.TrustedComment)
+
+	ok = true
+
+	return ok, err
+}
+
+// Inspect verifies the signature of binary, and says which minisign key made it.
+func (MinisignSigner) Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (signer SignatureSigner, err error) {
+	return InspectMinisign(identity, binary, sigFile)
+}
+
+// InspectMinisign verifies a binary's minisign signature in sigFile against the identity's public key, and returns the id of the key that made it.  Minisign keys have no identities, and don't expire, so the key id is all there is to go on.
+func InspectMinisign(identity SigningIdentity, binary string, sigFile string) (signer SignatureSigner, err error) {
+	if identity.Keyring == "" {
+		err = errors.New(fmt.Sprintf("checking minisign signatures needs 'keyring' set to the minisign public key for %s in the 'signing' section of the metadata file", identity))
+		return signer, err
+	}
+
+	_, err = VerifyMinisign(identity, binary, sigFile)
+	if err != nil {
+		return signer, err
+	}
+
+	sigBytes, err := os.ReadFile(sigFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", sigFile)
+		return signer, err
+	}
+
+	sig, err := ParseMinisignSignature(sigBytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", sigFile)
+		return signer, err
+	}
+
+	signer.Fingerprint = MinisignPublicKey{KeyID: sig.KeyID}.KeyIDString()
+
+	return signer, err
+}
+
+// Sign makes a prehashed minisign signature of data.
+func (k MinisignSecretKey) Sign(data []byte, trustedComment string) (sig MinisignSignature) {
+	hash := blake2b.Sum512(data)
+
+	sig = MinisignSignature{
+		Algorithm:        minisignAlgPrehashed,
+		KeyID:            k.KeyID,
+		Signature:        ed25519.Sign(k.PrivateKey, hash[:]),
+		UntrustedComment: "signature from gomason secret key",
+		TrustedComment:   trustedComment,
+	}
+
+	sig.GlobalSignature = ed25519.Sign(k.PrivateKey, append(append([]byte{}, sig.Signature...), []byte(trustedComment)...))
+
+	return sig
+}
+
+// Verify checks a minisign signature of data, and its trusted comment.
+func (k MinisignPublicKey) Verify(data []byte, sig MinisignSignature) (err error) {
+	if sig.KeyID != k.KeyID {
+		err = errors.New(fmt.Sprintf("signature was made with key %s, not %s", MinisignPublicKey{KeyID: sig.KeyID}.KeyIDString(), k.KeyIDString()))
+		return err
+	}
+
+	message := data
+
+	switch {
+	case bytes.Equal(sig.Algorithm, minisignAlgPrehashed):
+		hash := blake2b.Sum512(data)
+		message = hash[:]
+	case bytes.Equal(sig.Algorithm, minisignAlgEd):
+	default:
+		err = errors.New(fmt.Sprintf("unsupported signature algorithm %q", sig.Algorithm))
+		return err
+	}
+
+	if !ed25519.Verify(k.PublicKey, message, sig.Signature) {
+		err = errors.New("signature verification failed")
+		return err
+	}
+
+	if !ed25519.Verify(k.PublicKey, append(append([]byte{}, sig.Signature...), []byte(sig.TrustedComment)...), sig.GlobalSignature) {
+		err = errors.New("trusted comment verification failed")
+		return err
+	}
+
+	return err
+}
+
+// Encode returns the signature in .minisig file format.
+func (s MinisignSignature) Encode() []byte {
+	sigBytes := append(append(append([]byte{}, s.Algorithm...), s.KeyID[:]...), s.Signature...)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(minisignUntrustedPrefix + s.UntrustedComment + "\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(sigBytes) + "\n")
+	buf.WriteString(minisignTrustedPrefix + s.TrustedComment + "\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(s.GlobalSignature) + "\n")
+
+	return buf.Bytes()
+}
+
+// ParseMinisignSignature parses the contents of a .minisig file.
+func ParseMinisignSignature(content []byte) (sig MinisignSignature, err error) {
+	lines, err := minisignLines(content, 4)
+	if err != nil {
+		return sig, err
+	}
+
+	if !strings.HasPrefix(lines[0], minisignUntrustedPrefix) || !strings.HasPrefix(lines[2], minisignTrustedPrefix) {
+		err = errors.New("not a minisign signature")
+		return sig, err
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sigBytes) != 2+8+ed25519.SignatureSize {
+		err = errors.New("invalid minisign signature")
+		return sig, err
+	}
+
+	sig.GlobalSignature, err = base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(sig.GlobalSignature) != ed25519.SignatureSize {
+		err = errors.New("invalid minisign global signature")
+		return sig, err
+	}
+
+	sig.Algorithm = sigBytes[:2]
+	copy(sig.KeyID[:], sigBytes[2:10])
+	sig.Signature = sigBytes[10:]
+	sig.UntrustedComment = strings.TrimPrefix(lines[0], minisignUntrustedPrefix)
+	sig.TrustedComment = strings.TrimPrefix(lines[2], minisignTrustedPrefix)
+
+	return sig, err
+}
+
+// ParseMinisignPublicKey parses a minisign public key.  Either the contents of a public key file, or just the base64 encoded key, are accepted.
+func ParseMinisignPublicKey(content []byte) (key MinisignPublicKey, err error) {
+	lines, err := minisignLines(content, 1)
+	if err != nil {
+		return key, err
+	}
+
+	encoded := lines[0]
+	if strings.HasPrefix(encoded, minisignUntrustedPrefix) {
+		if len(lines) < 2 {
+			err = errors.New("minisign public key file is missing the key")
+			return key, err
+		}
+
+		encoded = lines[1]
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(keyBytes) != 2+8+ed25519.PublicKeySize {
+		err = errors.New("invalid minisign public key")
+		return key, err
+	}
+
+	if !bytes.Equal(keyBytes[:2], minisignAlgEd) {
+		err = errors.New(fmt.Sprintf("unsupported public key algorithm %q", keyBytes[:2]))
+		return key, err
+	}
+
+	copy(key.KeyID[:], keyBytes[2:10])
+	key.PublicKey = ed25519.PublicKey(keyBytes[10:])
+
+	return key, err
+}
+
+// ParseMinisignSecretKey parses a minisign secret key file, decrypting it with the passphrase if it's encrypted.
+func ParseMinisignSecretKey(content []byte, passphrase string) (key MinisignSecretKey, err error) {
+	lines, err := minisignLines(content, 2)
+	if err != nil {
+		return key, err
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(keyBytes) != 158 {
+		err = errors.New("invalid minisign secret key")
+		return key, err
+	}
+
+	sigAlg := keyBytes[0:2]
+	kdfAlg := keyBytes[2:4]
+	chkAlg := keyBytes[4:6]
+	salt := keyBytes[6:38]
+	opsLimit := binary.LittleEndian.Uint64(keyBytes[38:46])
+	memLimit := binary.LittleEndian.Uint64(keyBytes[46:54])
+	keynum := append([]byte{}, keyBytes[54:158]...)
+
+	if !bytes.Equal(sigAlg, minisignAlgEd) || !bytes.Equal(chkAlg, minisignChkBlake2b) {
+		err = errors.New("unsupported minisign secret key algorithm")
+		return key, err
+	}
+
+	switch {
+	case bytes.Equal(kdfAlg, minisignKdfScrypt):
+		if passphrase == "" {
+			err = errors.New("minisign secret key is encrypted, and there's no 'passphrasefunc' in the 'signing' section of ~/.gomason to get the passphrase from")
+			return key, err
+		}
+
+		n, r, p := minisignScryptParams(opsLimit, memLimit)
+
+		stream, err := scrypt.Key([]byte(passphrase), salt, n, r, p, len(keynum))
+		if err != nil {
+			err = errors.Wrapf(err, "failed deriving key from passphrase")
+			return key, err
+		}
+
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+
+	case bytes.Equal(kdfAlg, minisignKdfNone):
+	default:
+		err = errors.New(fmt.Sprintf("unsupported minisign key derivation algorithm %q", kdfAlg))
+		return key, err
+	}
+
+	copy(key.KeyID[:], keynum[0:8])
+	key.PrivateKey = ed25519.PrivateKey(keynum[8:72])
+
+	chk := minisignChecksum(key)
+	if !bytes.Equal(chk[:], keynum[72:104]) {
+		err = errors.New("wrong passphrase, or corrupt minisign secret key")
+		return key, err
+	}
+
+	return key, err
+}
+
+// EncodeMinisignSecretKey writes a secret key in minisign's format, encrypted with the passphrase unless it's empty.  Minisign's own defaults for the key derivation are very expensive, so opsLimit and memLimit are given explicitly.
+func EncodeMinisignSecretKey(key MinisignSecretKey, passphrase string, salt []byte, opsLimit uint64, memLimit uint64) (content []byte, err error) {
+	chk := minisignChecksum(key)
+
+	keynum := append(append(append([]byte{}, key.KeyID[:]...), key.PrivateKey...), chk[:]...)
+	kdfAlg := minisignKdfNone
+
+	if passphrase != "" {
+		kdfAlg = minisignKdfScrypt
+
+		n, r, p := minisignScryptParams(opsLimit, memLimit)
+
+		stream, err := scrypt.Key([]byte(passphrase), salt, n, r, p, len(keynum))
+		if err != nil {
+			err = errors.Wrapf(err, "failed deriving key from passphrase")
+			return content, err
+		}
+
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	}
+
+	limits := make([]byte, 16)
+	binary.LittleEndian.PutUint64(limits[0:8], opsLimit)
+	binary.LittleEndian.PutUint64(limits[8:16], memLimit)
+
+	keyBytes := make([]byte, 0, 158)
+	keyBytes = append(keyBytes, minisignAlgEd...)
+	keyBytes = append(keyBytes, kdfAlg...)
+	keyBytes = append(keyBytes, minisignChkBlake2b...)
+	keyBytes = append(keyBytes, salt...)
+	keyBytes = append(keyBytes, limits...)
+	keyBytes = append(keyBytes, keynum...)
+
+	content = []byte(minisignUntrustedPrefix + "minisign encrypted secret key\n" + base64.StdEncoding.EncodeToString(keyBytes) + "\n")
+
+	return content, err
+}
+
+// Public returns the public half of a minisign secret key.
+func (k MinisignSecretKey) Public() (pub MinisignPublicKey) {
+	pub = MinisignPublicKey{
+		KeyID:     k.KeyID,
+		PublicKey: k.PrivateKey.Public().(ed25519.PublicKey),
+	}
+
+	return pub
+}
+
+// Encode returns the public key in minisign's public key file format.
+func (k MinisignPublicKey) Encode() []byte {
+	keyBytes := append(append(append([]byte{}, minisignAlgEd...), k.KeyID[:]...), k.PublicKey...)
+
+	return []byte(fmt.Sprintf("%sminisign public key %s\n%s\n", minisignUntrustedPrefix, k.KeyIDString(), base64.StdEncoding.EncodeToString(keyBytes)))
+}
+
+func minisignChecksum(key MinisignSecretKey) [32]byte {
+	data := append(append(append([]byte{}, minisignAlgEd...), key.KeyID[:]...), key.PrivateKey...)
+
+	return blake2b.Sum256(data)
+}
+
+// minisignScryptParams turns libsodium's opslimit and memlimit into scrypt's N, r and p, the same way libsodium does.
+func minisignScryptParams(opsLimit uint64, memLimit uint64) (n int, r int, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+
+	r = 8
+
+	var maxN uint64
+	var nLog2 uint
+
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN = opsLimit / uint64(r*4)
+	} else {
+		maxN = memLimit / uint64(r*128)
+	}
+
+	for nLog2 = 1; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+
+	if opsLimit >= memLimit/32 {
+		maxrp := (opsLimit / 4) / (uint64(1) << nLog2)
+		if maxrp > 0x3fffffff {
+			maxrp = 0x3fffffff
+		}
+
+		p = int(maxrp) / r
+	}
+
+	n = 1 << nLog2
+
+	return n, r, p
+}
+
+// minisignLines returns the non-empty lines of a minisign file, requiring at least min of them.
+func minisignLines(content []byte, min int) (lines []string, err error) {
+	lines = make([]string, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) < min {
+		err = errors.New(fmt.Sprintf("expected at least %d lines, found %d", min, len(lines)))
+		return lines, err
+	}
+
+	return lines, err
+}
@@ -0,0 +1,192 @@
+package gomason
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestMinisignKeys generates a minisign key, and writes it, encrypted with the passphrase, and its public key into dir.  Key derivation limits are kept low so the test is quick.
+func writeTestMinisignKeys(t *testing.T, dir string, passphrase string) (keyFile string, pubFile string) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	key := MinisignSecretKey{PrivateKey: priv}
+
+	_, err = rand.Read(key.KeyID[:])
+	if err != nil {
+		t.Fatalf("Error generating key id: %s", err)
+	}
+
+	salt := make([]byte, 32)
+
+	_, err = rand.Read(salt)
+	if err != nil {
+		t.Fatalf("Error generating salt: %s", err)
+	}
+
+	content, err := EncodeMinisignSecretKey(key, passphrase, salt, 32768, 16777216)
+	if err != nil {
+		t.Fatalf("Error encoding secret key: %s", err)
+	}
+
+	keyFile = filepath.Join(dir, "minisign.key")
+
+	err = os.WriteFile(keyFile, content, 0600)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", keyFile, err)
+	}
+
+	pubFile = filepath.Join(dir, "minisign.pub")
+
+	err = os.WriteFile(pubFile, key.Public().Encode(), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", pubFile, err)
+	}
+
+	return keyFile, pubFile
+}
+
+func TestSignVerifyMinisign(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, subdir := range []string{"encrypted", "unencrypted"} {
+		err = os.MkdirAll(filepath.Join(dir, subdir), 0755)
+		if err != nil {
+			t.Fatalf("Error creating %s: %s", subdir, err)
+		}
+	}
+
+	keyFile, pubFile := writeTestMinisignKeys(t, filepath.Join(dir, "encrypted"), "sekrit")
+	unencryptedKeyFile, unencryptedPubFile := writeTestMinisignKeys(t, filepath.Join(dir, "unencrypted"), "")
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	inputs := []struct {
+		name           string
+		keyFile        string
+		pubFile        string
+		passphraseFunc string
+		signErr        bool
+	}{
+		{"encrypted", keyFile, pubFile, "echo sekrit", false},
+		{"wrong passphrase", keyFile, pubFile, "echo wrong", true},
+		{"no passphrase", keyFile, pubFile, "", true},
+		{"unencrypted", unencryptedKeyFile, unencryptedPubFile, "", false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			g := Gomason{
+				Config: UserConfig{
+					Signing: UserSignInfo{Program: SigningProgramMinisign, KeyFile: tc.keyFile, PassphraseFunc: tc.passphraseFunc},
+				},
+			}
+
+			meta := testMetadataObj()
+			meta.SignInfo = SignInfo{Program: SigningProgramMinisign, Keyring: tc.pubFile}
+
+			err := g.SignBinary(meta, binary)
+			if tc.signErr {
+				assert.NotNil(t, err, "Signing fails")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			ok, err := VerifyBinary(binary, meta)
+			assert.Nil(t, err, "No error verifying signature")
+			assert.True(t, ok, "Signature verifies")
+
+			sigBytes, err := os.ReadFile(binary + MinisignSignatureSuffix)
+			if err != nil {
+				t.Fatalf("Error reading signature: %s", err)
+			}
+
+			sig, err := ParseMinisignSignature(sigBytes)
+			assert.Nil(t, err, "No error parsing signature")
+			assert.True(t, strings.Contains(sig.TrustedComment, "package:"+meta.Package), "Trusted comment has the package")
+			assert.True(t, strings.Contains(sig.TrustedComment, "version:"+meta.Version), "Trusted comment has the version")
+		})
+	}
+
+	meta := testMetadataObj()
+	meta.SignInfo = SignInfo{Program: SigningProgramMinisign, Keyring: pubFile}
+
+	// sign with the encrypted key again, then tamper with the trusted comment
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{KeyFile: keyFile, PassphraseFunc: "echo sekrit"}}}
+
+	err = g.SignBinary(meta, binary)
+	if err != nil {
+		t.Fatalf("Error signing %s: %s", binary, err)
+	}
+
+	sigBytes, err := os.ReadFile(binary + MinisignSignatureSuffix)
+	if err != nil {
+		t.Fatalf("Error reading signature: %s", err)
+	}
+
+	err = os.WriteFile(binary+MinisignSignatureSuffix, []byte(strings.Replace(string(sigBytes), "version:", "version:9", 1)), 0644)
+	if err != nil {
+		t.Fatalf("Error writing signature: %s", err)
+	}
+
+	ok, err := VerifyBinary(binary, meta)
+	assert.NotNil(t, err, "Error verifying tampered trusted comment")
+	assert.False(t, ok, "Tampered trusted comment doesn't verify")
+
+	// signed by some other key
+	meta.SignInfo.Keyring = unencryptedPubFile
+
+	err = g.SignBinary(meta, binary)
+	if err != nil {
+		t.Fatalf("Error signing %s: %s", binary, err)
+	}
+
+	ok, err = VerifyBinary(binary, meta)
+	assert.NotNil(t, err, "Error verifying with the wrong key")
+	assert.False(t, ok, "Signature from another key doesn't verify")
+}
+
+func TestPlanFileMinisign(t *testing.T) {
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{Program: SigningProgramMinisign}}}
+
+	meta := testMetadataObj()
+	meta.Repository = "http://localhost:8081/artifactory/generic-local"
+
+	plan, err := g.PlanFile(meta, "/tmp/foo/testproject_linux_amd64", true, true)
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+
+	signatures := make([]PlannedUpload, 0)
+
+	for _, u := range plan.Uploads {
+		if u.Kind == UploadKindSignature {
+			signatures = append(signatures, u)
+		}
+	}
+
+	if assert.Equal(t, 1, len(signatures), "One signature is uploaded") {
+		assert.Equal(t, "/tmp/foo/testproject_linux_amd64.minisig", signatures[0].Source, "Signature source is the .minisig")
+		assert.Equal(t, "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject.minisig", signatures[0].Destination, "Signature is uploaded next to the artifact")
+	}
+}
@@ -0,0 +1,998 @@
+package gomason
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OCIArtifactType is the artifact type of everything gomason pushes to OCI registries.
+	OCIArtifactType = "application/vnd.nikogura.gomason.artifact.v1"
+	// OCIManifestMediaType is the media type of OCI image manifests.
+	OCIManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// OCIIndexMediaType is the media type of OCI image indexes, i.e. multi-platform manifests.
+	OCIIndexMediaType = "application/vnd.oci.image.index.v1+json"
+	// OCIEmptyMediaType is the media type of the empty config of artifacts that have no config.
+	OCIEmptyMediaType = "application/vnd.oci.empty.v1+json"
+	// OCIFileMediaType is the media type of the layer holding the published file itself.
+	OCIFileMediaType = "application/octet-stream"
+	// OCIChecksumMediaType is the media type of layers holding checksums.
+	OCIChecksumMediaType = "text/plain"
+)
+
+const (
+	// OCIAnnotationTitle names a layer's file.  ORAS writes layers to files of that name when pulling.
+	OCIAnnotationTitle = "org.opencontainers.image.title"
+	// OCIAnnotationVersion is the version of the package.
+	OCIAnnotationVersion = "org.opencontainers.image.version"
+	// OCIAnnotationCreated is when the artifact was pushed.
+	OCIAnnotationCreated = "org.opencontainers.image.created"
+	// OCIAnnotationRevision is the commit the artifact was built from.
+	OCIAnnotationRevision = "org.opencontainers.image.revision"
+	// OCIAnnotationSource is where the code came from.
+	OCIAnnotationSource = "org.opencontainers.image.source"
+	// OCIAnnotationDescription is the description of the package.
+	OCIAnnotationDescription = "org.opencontainers.image.description"
+	// OCIAnnotationPackage is the package the artifact is for.
+	OCIAnnotationPackage = "com.github.nikogura.gomason.package"
+	// OCIAnnotationOS is the OS a binary is for.
+	OCIAnnotationOS = "com.github.nikogura.gomason.os"
+	// OCIAnnotationArch is the architecture a binary is for.
+	OCIAnnotationArch = "com.github.nikogura.gomason.arch"
+)
+
+// ociSignatureMediaTypes are the media types of the layers holding signatures, by suffix.
+var ociSignatureMediaTypes = map[string]string{
+	GPGSignatureSuffix:      "application/pgp-signature",
+	CMSSignatureSuffix:      "application/pkcs7-signature",
+	MinisignSignatureSuffix: "application/vnd.minisign.signature",
+	SSHSignatureSuffix:      "application/vnd.ssh.signature",
+}
+
+// ociEmptyConfig is the config of artifacts, which have none.
+var ociEmptyConfig = []byte("{}")
+
+var ociRepositoryRegex = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+var ociTagRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+var ociChallengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ociIndexMutex keeps concurrent pushes of different platforms from losing each other's updates to an index.
+var ociIndexMutex sync.Mutex
+
+func init() {
+	RegisterPublisher("oci", OCIPublisher{})
+}
+
+// OCIReference is where in an OCI registry something is pushed, i.e. oci://<registry>/<repository>:<tag>
+type OCIReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+// ParseOCIReference parses an 'oci://<registry>/<repository>:<tag>' url.  The tag defaults to 'latest'.
+func ParseOCIReference(ref string) (reference OCIReference, err error) {
+	if !strings.HasPrefix(strings.ToLower(ref), "oci://") {
+		err = errors.New(fmt.Sprintf("%s is not an oci:// url", ref))
+		return reference, err
+	}
+
+	rest := ref[len("oci://"):]
+
+	slash := strings.Index(rest, "/")
+	if slash < 1 {
+		err = errors.New(fmt.Sprintf("%s has no repository", ref))
+		return reference, err
+	}
+
+	reference.Registry = rest[:slash]
+	repository := rest[slash+1:]
+	reference.Tag = "latest"
+
+	if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		reference.Tag = repository[colon+1:]
+		repository = repository[:colon]
+	}
+
+	reference.Repository = repository
+
+	if !ociRepositoryRegex.MatchString(reference.Repository) {
+		err = errors.New(fmt.Sprintf("%q in %s is not a valid repository name", reference.Repository, ref))
+		return reference, err
+	}
+
+	if !ociTagRegex.MatchString(reference.Tag) {
+		err = errors.New(fmt.Sprintf("%q in %s is not a valid tag", reference.Tag, ref))
+		return reference, err
+	}
+
+	return reference, err
+}
+
+func (r OCIReference) String() string {
+	return fmt.Sprintf("oci://%s/%s:%s", r.Registry, r.Repository, r.Tag)
+}
+
+// OCIPlatform is the platform of a manifest in an index.
+type OCIPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// OCIDescriptor describes a blob or manifest in a registry.
+type OCIDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Data         []byte            `json:"data,omitempty"`
+	Platform     *OCIPlatform      `json:"platform,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIManifest is an OCI image manifest, or with Manifests rather than Config and Layers, an image index.
+type OCIManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        *OCIDescriptor    `json:"config,omitempty"`
+	Layers        []OCIDescriptor   `json:"layers,omitempty"`
+	Manifests     []OCIDescriptor   `json:"manifests,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIIndexError means a file doesn't fit with the multi-platform index at a tag, e.g. it isn't built for any platform, or the index has nothing for its platform.  Trying again won't help.
+type OCIIndexError struct {
+	Reference string
+	Reason    string
+}
+
+func (e OCIIndexError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reference, e.Reason)
+}
+
+// OCIPublisher pushes files to OCI registries as artifacts, the way ORAS does: a manifest whose layers are the file, its signatures and its checksums.  Binaries are added to a multi-platform index at the tag, so that each build target's binary can be pulled from the same reference.  Other files are tagged directly.
+type OCIPublisher struct {
+	Client *http.Client
+	// PlainHTTP talks to registries over http rather than https.  Registries on localhost always are.
+	PlainHTTP bool
+}
+
+// ociFile is a file to push as a layer.
+type ociFile struct {
+	title     string
+	mediaType string
+	data      []byte
+}
+
+// PutBundle pushes a file, its signatures and its checksums as the layers of an artifact.  If the file is a binary built for one of the build targets, the artifact is added to the index at the tag for the target's platform, replacing whatever was there for that platform.  Otherwise the artifact is tagged itself.
+func (p OCIPublisher) PutBundle(meta Metadata, uploads []PlannedUpload, username string, password string) (err error) {
+	if len(uploads) == 0 {
+		return err
+	}
+
+	artifact := uploads[0]
+
+	ref, err := ParseOCIReference(artifact.Destination)
+	if err != nil {
+		return err
+	}
+
+	title := filepath.Base(artifact.Source)
+	files := make([]ociFile, 0)
+
+	for _, upload := range uploads {
+		f := ociFile{
+			title:     title + strings.TrimPrefix(upload.Destination, artifact.Destination),
+			mediaType: OCIFileMediaType,
+		}
+
+		switch upload.Kind {
+		case UploadKindChecksum:
+			f.mediaType = OCIChecksumMediaType
+		case UploadKindSignature:
+			if mediaType, ok := ociSignatureMediaTypes[filepath.Ext(upload.Source)]; ok {
+				f.mediaType = mediaType
+			}
+		}
+
+		data, _, err := UploadContent(upload)
+		if err != nil {
+			return err
+		}
+
+		f.data, err = io.ReadAll(data)
+		_ = data.Close()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read %s", upload.Description())
+			return err
+		}
+
+		files = append(files, f)
+	}
+
+	annotations := map[string]string{
+		OCIAnnotationTitle:   title,
+		OCIAnnotationCreated: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if meta.Package != "" {
+		annotations[OCIAnnotationPackage] = meta.Package
+	}
+
+	if meta.Version != "" {
+		annotations[OCIAnnotationVersion] = meta.Version
+	}
+
+	if meta.Description != "" {
+		annotations[OCIAnnotationDescription] = meta.Description
+	}
+
+	if meta.Commit != "" {
+		annotations[OCIAnnotationRevision] = meta.Commit
+	}
+
+	// not a path on the releaser's machine
+	if IsRemoteGitURL(meta.GitUrl) {
+		annotations[OCIAnnotationSource] = meta.GitUrl
+	}
+
+	var platform *OCIPlatform
+
+	if artifact.Platform != nil {
+		platform = &OCIPlatform{
+			Architecture: artifact.Platform.Arch,
+			OS:           artifact.Platform.OS,
+			Variant:      artifact.Platform.Variant,
+		}
+
+		annotations[OCIAnnotationOS] = platform.OS
+		annotations[OCIAnnotationArch] = platform.Architecture
+	}
+
+	logrus.Debugf("Pushing %s to %s", title, ref)
+
+	return p.push(ref, files, annotations, platform, username, password)
+}
+
+// Put pushes data to url as an artifact with just the one layer, named after the repository, and tags it.
+func (p OCIPublisher) Put(url string, data io.Reader, checksums Checksums, username string, password string) (err error) {
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(data)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read what's being pushed to %s", url)
+		return err
+	}
+
+	title := path.Base(ref.Repository)
+
+	files := []ociFile{
+		{
+			title:     title,
+			mediaType: OCIFileMediaType,
+			data:      content,
+		},
+	}
+
+	annotations := map[string]string{
+		OCIAnnotationTitle:   title,
+		OCIAnnotationCreated: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return p.push(ref, files, annotations, nil, username, password)
+}
+
+// push pushes the files as the layers of an artifact, and tags it, or adds it to the index at the tag for the platform.
+func (p OCIPublisher) push(ref OCIReference, files []ociFile, annotations map[string]string, platform *OCIPlatform, username string, password string) (err error) {
+	c := p.client(ref, username, password)
+
+	config, err := c.pushBlob(OCIEmptyMediaType, ociEmptyConfig)
+	if err != nil {
+		return err
+	}
+
+	config.Data = ociEmptyConfig
+
+	manifest := OCIManifest{
+		SchemaVersion: 2,
+		MediaType:     OCIManifestMediaType,
+		ArtifactType:  OCIArtifactType,
+		Config:        &config,
+		Layers:        make([]OCIDescriptor, 0),
+		Annotations:   annotations,
+	}
+
+	for _, f := range files {
+		layer, err := c.pushBlob(f.mediaType, f.data)
+		if err != nil {
+			return err
+		}
+
+		layer.Annotations = map[string]string{OCIAnnotationTitle: f.title}
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to marshal manifest for %s", ref)
+		return err
+	}
+
+	if platform == nil {
+		// other targets' binaries would be lost
+		existing, found, err := c.getManifest(ref.Tag)
+		if err != nil {
+			return err
+		}
+
+		if found && existing.MediaType == OCIIndexMediaType {
+			err = OCIIndexError{Reference: ref.String(), Reason: fmt.Sprintf("it's an index of %d platforms.  Refusing to replace it with a file that isn't built for any of them", len(existing.Manifests))}
+			return err
+		}
+
+		return c.putManifest(ref.Tag, OCIManifestMediaType, body)
+	}
+
+	digest := ociDigest(body)
+
+	err = c.putManifest(digest, OCIManifestMediaType, body)
+	if err != nil {
+		return err
+	}
+
+	ociIndexMutex.Lock()
+	defer ociIndexMutex.Unlock()
+
+	index, found, err := c.getManifest(ref.Tag)
+	if err != nil {
+		return err
+	}
+
+	if !found || index.MediaType != OCIIndexMediaType {
+		if found {
+			logrus.Warnf("%s is not an index.  Replacing it with one.", ref)
+		}
+
+		index = OCIManifest{
+			SchemaVersion: 2,
+			MediaType:     OCIIndexMediaType,
+			ArtifactType:  OCIArtifactType,
+		}
+	}
+
+	manifests := make([]OCIDescriptor, 0)
+
+	for _, m := range index.Manifests {
+		if m.Platform != nil && *m.Platform == *platform {
+			continue
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	manifests = append(manifests, OCIDescriptor{
+		MediaType:    OCIManifestMediaType,
+		ArtifactType: OCIArtifactType,
+		Digest:       digest,
+		Size:         int64(len(body)),
+		Platform:     platform,
+		Annotations:  map[string]string{OCIAnnotationTitle: annotations[OCIAnnotationTitle]},
+	})
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return ociPlatformString(manifests[i].Platform) < ociPlatformString(manifests[j].Platform)
+	})
+
+	index.Manifests = manifests
+
+	// the index is for the package and version, not any one file or platform
+	index.Annotations = make(map[string]string)
+
+	for _, k := range []string{OCIAnnotationPackage, OCIAnnotationVersion, OCIAnnotationDescription, OCIAnnotationRevision, OCIAnnotationSource, OCIAnnotationCreated} {
+		if v, ok := annotations[k]; ok {
+			index.Annotations[k] = v
+		}
+	}
+
+	body, err = json.Marshal(index)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to marshal index for %s", ref)
+		return err
+	}
+
+	return c.putManifest(ref.Tag, OCIIndexMediaType, body)
+}
+
+// Get fetches the file in the artifact at url, i.e. its first layer.  Indexes are refused, as they hold a file for each platform.  GetBundle fetches from those.
+func (p OCIPublisher) Get(url string, out io.Writer, username string, password string) (err error) {
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return err
+	}
+
+	c := p.client(ref, username, password)
+
+	manifest, found, err := c.getManifest(ref.Tag)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		err = HTTPStatusError{URL: url, StatusCode: http.StatusNotFound}
+		return err
+	}
+
+	if manifest.MediaType == OCIIndexMediaType {
+		err = errors.New(fmt.Sprintf("%s is an index of %d platforms.  Pull the one you want by digest", url, len(manifest.Manifests)))
+		return err
+	}
+
+	if len(manifest.Layers) == 0 {
+		err = errors.New(fmt.Sprintf("%s has no layers", url))
+		return err
+	}
+
+	return c.getBlob(manifest.Layers[0].Digest, out)
+}
+
+// GetBundle fetches the file, signatures and checksums that PutBundle pushed to url.  If url is an index, the artifact for the platform is the one fetched.
+func (p OCIPublisher) GetBundle(url string, platform *BuildPlatform, outs map[string]io.Writer, username string, password string) (err error) {
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return err
+	}
+
+	c := p.client(ref, username, password)
+
+	manifest, found, err := c.getManifest(ref.Tag)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		err = HTTPStatusError{URL: url, StatusCode: http.StatusNotFound}
+		return err
+	}
+
+	if manifest.MediaType == OCIIndexMediaType {
+		if platform == nil {
+			err = OCIIndexError{Reference: url, Reason: fmt.Sprintf("it's an index of %d platforms, and the file isn't built for any of them", len(manifest.Manifests))}
+			return err
+		}
+
+		digest := ""
+
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil && m.Platform.OS == platform.OS && m.Platform.Architecture == platform.Arch && m.Platform.Variant == platform.Variant {
+				digest = m.Digest
+			}
+		}
+
+		if digest == "" {
+			err = OCIIndexError{Reference: url, Reason: fmt.Sprintf("its index has nothing for %s", platform)}
+			return err
+		}
+
+		manifest, found, err = c.getManifest(digest)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			err = HTTPStatusError{URL: fmt.Sprintf("%s@%s", url, digest), StatusCode: http.StatusNotFound}
+			return err
+		}
+	}
+
+	if len(manifest.Layers) == 0 {
+		err = errors.New(fmt.Sprintf("%s has no layers", url))
+		return err
+	}
+
+	// the file is the first layer, and the others are named after it
+	title := manifest.Layers[0].Annotations[OCIAnnotationTitle]
+
+	for suffix, out := range outs {
+		digest := ""
+
+		for _, layer := range manifest.Layers {
+			if layer.Annotations[OCIAnnotationTitle] == title+suffix {
+				digest = layer.Digest
+			}
+		}
+
+		if digest == "" {
+			err = errors.New(fmt.Sprintf("%s has no %s layer", url, title+suffix))
+			return err
+		}
+
+		err = c.getBlob(digest, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Head says whether there's anything tagged at url.
+func (p OCIPublisher) Head(url string, username string, password string) (exists bool, err error) {
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return exists, err
+	}
+
+	_, exists, err = p.client(ref, username, password).headManifest(ref.Tag)
+
+	return exists, err
+}
+
+// Delete deletes the manifest tagged at url.  Deleting something that isn't there isn't an error.
+func (p OCIPublisher) Delete(url string, username string, password string) (err error) {
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return err
+	}
+
+	c := p.client(ref, username, password)
+
+	digest, exists, err := c.headManifest(ref.Tag)
+	if err != nil || !exists {
+		return err
+	}
+
+	if digest == "" {
+		err = errors.New(fmt.Sprintf("registry didn't say what the digest of %s is, so it can't be deleted", url))
+		return err
+	}
+
+	resp, err := c.do("DELETE", c.path("manifests", digest), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 && resp.StatusCode != http.StatusNotFound {
+		err = HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return err
+	}
+
+	return err
+}
+
+// List returns a url for each tag in the repository of url.
+func (p OCIPublisher) List(url string, username string, password string) (urls []string, err error) {
+	urls = make([]string, 0)
+
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return urls, err
+	}
+
+	c := p.client(ref, username, password)
+
+	resp, err := c.do("GET", c.path("tags", "list"), nil, nil)
+	if err != nil {
+		return urls, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return urls, err
+	}
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: url, StatusCode: resp.StatusCode}
+		return urls, err
+	}
+
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&tags)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse tags of %s", url)
+		return urls, err
+	}
+
+	for _, tag := range tags.Tags {
+		urls = append(urls, OCIReference{Registry: ref.Registry, Repository: ref.Repository, Tag: tag}.String())
+	}
+
+	sort.Strings(urls)
+
+	return urls, err
+}
+
+// Method describes where in which registry url is.
+func (OCIPublisher) Method(url string) string {
+	ref, err := ParseOCIReference(url)
+	if err != nil {
+		return fmt.Sprintf("unsupported: %s", err)
+	}
+
+	return fmt.Sprintf("OCI (registry: %s, repository: %s, tag: %s)", ref.Registry, ref.Repository, ref.Tag)
+}
+
+func (p OCIPublisher) client(ref OCIReference, username string, password string) *ociClient {
+	scheme := "https"
+	if p.PlainHTTP || ociLocalhost(ref.Registry) {
+		scheme = "http"
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	return &ociClient{
+		http:       client,
+		base:       fmt.Sprintf("%s://%s", scheme, ref.Registry),
+		repository: ref.Repository,
+		username:   username,
+		password:   password,
+	}
+}
+
+// ociClient talks to a repository in a registry with the OCI distribution api.
+type ociClient struct {
+	http       *http.Client
+	base       string
+	repository string
+	username   string
+	password   string
+	token      string
+}
+
+// path returns the url of something in the repository, e.g. path("blobs", digest)
+func (c *ociClient) path(elements ...string) string {
+	return fmt.Sprintf("%s/v2/%s/%s", c.base, c.repository, strings.Join(elements, "/"))
+}
+
+// do makes a request, authenticating with basic auth, or a bearer token if the registry wants one.
+func (c *ociClient) do(method string, url string, body []byte, headers map[string]string) (resp *http.Response, err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequest(method, url, reader)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create http request for %s", url)
+			return resp, err
+		}
+
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		} else if c.username != "" || c.password != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		resp, err = c.http.Do(req)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to %s %s", method, url)
+			return resp, err
+		}
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+
+		if resp.StatusCode != http.StatusUnauthorized || c.token != "" || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return resp, err
+		}
+
+		_ = resp.Body.Close()
+
+		c.token, err = c.fetchToken(challenge)
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	return resp, err
+}
+
+// fetchToken gets a bearer token from the auth service named in a registry's challenge, with basic auth.
+func (c *ociClient) fetchToken(challenge string) (token string, err error) {
+	params := make(map[string]string)
+
+	for _, match := range ociChallengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+
+	realm, ok := params["realm"]
+	if !ok {
+		err = errors.New(fmt.Sprintf("registry auth challenge %q has no realm", challenge))
+		return token, err
+	}
+
+	query := url.Values{}
+
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+
+	scope, ok := params["scope"]
+	if !ok {
+		scope = fmt.Sprintf("repository:%s:pull,push", c.repository)
+	}
+
+	query.Set("scope", scope)
+
+	req, err := http.NewRequest("GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create token request for %s", realm)
+		return token, err
+	}
+
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get token from %s", realm)
+		return token, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: realm, StatusCode: resp.StatusCode}
+		return token, err
+	}
+
+	var tokens struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse token from %s", realm)
+		return token, err
+	}
+
+	token = tokens.Token
+	if token == "" {
+		token = tokens.AccessToken
+	}
+
+	if token == "" {
+		err = errors.New(fmt.Sprintf("no token from %s", realm))
+		return token, err
+	}
+
+	return token, err
+}
+
+// pushBlob uploads a blob, unless the registry already has it, and returns its descriptor.
+func (c *ociClient) pushBlob(mediaType string, data []byte) (desc OCIDescriptor, err error) {
+	desc = OCIDescriptor{
+		MediaType: mediaType,
+		Digest:    ociDigest(data),
+		Size:      int64(len(data)),
+	}
+
+	resp, err := c.do("HEAD", c.path("blobs", desc.Digest), nil, nil)
+	if err != nil {
+		return desc, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return desc, err
+	}
+
+	uploads := c.path("blobs", "uploads") + "/"
+
+	resp, err = c.do("POST", uploads, nil, nil)
+	if err != nil {
+		return desc, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		err = HTTPStatusError{URL: uploads, StatusCode: resp.StatusCode}
+		return desc, err
+	}
+
+	base, err := url.Parse(uploads)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", uploads)
+		return desc, err
+	}
+
+	location, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		err = errors.Wrapf(err, "bad upload location %q from %s", resp.Header.Get("Location"), uploads)
+		return desc, err
+	}
+
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	resp, err = c.do("PUT", location.String(), data, map[string]string{"Content-Type": "application/octet-stream"})
+	if err != nil {
+		return desc, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: location.String(), StatusCode: resp.StatusCode}
+		return desc, err
+	}
+
+	return desc, err
+}
+
+// getBlob writes a blob to out.
+func (c *ociClient) getBlob(digest string, out io.Writer) (err error) {
+	blob := c.path("blobs", digest)
+
+	resp, err := c.do("GET", blob, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: blob, StatusCode: resp.StatusCode}
+		return err
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", blob)
+		return err
+	}
+
+	return err
+}
+
+// putManifest uploads a manifest, tagged, or by its digest.
+func (c *ociClient) putManifest(reference string, mediaType string, body []byte) (err error) {
+	manifest := c.path("manifests", reference)
+
+	resp, err := c.do("PUT", manifest, body, map[string]string{"Content-Type": mediaType})
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: manifest, StatusCode: resp.StatusCode}
+		return err
+	}
+
+	return err
+}
+
+// getManifest fetches a manifest or index.  found is false if there's nothing there.
+func (c *ociClient) getManifest(reference string) (manifest OCIManifest, found bool, err error) {
+	u := c.path("manifests", reference)
+
+	resp, err := c.do("GET", u, nil, map[string]string{"Accept": OCIIndexMediaType + ", " + OCIManifestMediaType})
+	if err != nil {
+		return manifest, found, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return manifest, found, err
+	}
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: u, StatusCode: resp.StatusCode}
+		return manifest, found, err
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse manifest %s", u)
+		return manifest, found, err
+	}
+
+	if manifest.MediaType == "" {
+		manifest.MediaType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	}
+
+	found = true
+
+	return manifest, found, err
+}
+
+// headManifest says whether there's a manifest at reference, and returns its digest.
+func (c *ociClient) headManifest(reference string) (digest string, exists bool, err error) {
+	u := c.path("manifests", reference)
+
+	resp, err := c.do("HEAD", u, nil, map[string]string{"Accept": OCIIndexMediaType + ", " + OCIManifestMediaType})
+	if err != nil {
+		return digest, exists, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return digest, exists, err
+	}
+
+	if resp.StatusCode > 299 {
+		err = HTTPStatusError{URL: u, StatusCode: resp.StatusCode}
+		return digest, exists, err
+	}
+
+	digest = resp.Header.Get("Docker-Content-Digest")
+	exists = true
+
+	return digest, exists, err
+}
+
+// ociDigest returns the sha256 digest of data, as registries write it.
+func ociDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ociPlatformString returns 'os/arch/variant' for sorting index entries.
+func ociPlatformString(platform *OCIPlatform) string {
+	if platform == nil {
+		return ""
+	}
+
+	return platform.OS + "/" + platform.Architecture + "/" + platform.Variant
+}
+
+// ociLocalhost says whether a registry is on this machine, where registries generally don't have certificates.
+func ociLocalhost(registry string) bool {
+	host := registry
+
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+
+	return ip != nil && ip.IsLoopback()
+}
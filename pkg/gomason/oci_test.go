@@ -0,0 +1,540 @@
+package gomason
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testOCIManifest is a manifest as stored by the stand in registry.
+type testOCIManifest struct {
+	contentType string
+	body        []byte
+}
+
+// testOCIStore is an in memory stand in for what a registry holds.
+type testOCIStore struct {
+	lock      sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]testOCIManifest
+	tags      map[string]string
+	uploads   int
+	auth      bool
+}
+
+func newTestOCIStore(auth bool) *testOCIStore {
+	return &testOCIStore{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]testOCIManifest),
+		tags:      make(map[string]string),
+		auth:      auth,
+	}
+}
+
+// manifest returns what's tagged at repo:tag, parsed.
+func (s *testOCIStore) manifest(t *testing.T, repo string, reference string) (manifest OCIManifest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	digest := reference
+	if !strings.HasPrefix(reference, "sha256:") {
+		digest = s.tags[repo+":"+reference]
+	}
+
+	stored, ok := s.manifests[repo+"@"+digest]
+	if !ok {
+		t.Fatalf("No manifest %s in %s", reference, repo)
+	}
+
+	err := json.Unmarshal(stored.body, &manifest)
+	if err != nil {
+		t.Fatalf("Error parsing manifest %s: %s", reference, err)
+	}
+
+	return manifest
+}
+
+var testOCIRoutes = struct {
+	uploads   *regexp.Regexp
+	upload    *regexp.Regexp
+	blob      *regexp.Regexp
+	manifest  *regexp.Regexp
+	tagsList  *regexp.Regexp
+	namespace *regexp.Regexp
+}{
+	uploads:  regexp.MustCompile(`^/v2/(.+)/blobs/uploads/$`),
+	upload:   regexp.MustCompile(`^/v2/(.+)/blobs/uploads/([^/]+)$`),
+	blob:     regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-f0-9]{64})$`),
+	manifest: regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`),
+	tagsList: regexp.MustCompile(`^/v2/(.+)/tags/list$`),
+}
+
+// testOCIRegistry serves a testOCIStore like a registry:2 does, with enough of the distribution api for what gomason does.  If the store wants auth, it hands out bearer tokens to 'tester' with password 'sekrit', the way Docker Hub and friends do.
+func testOCIRegistry(store *testOCIStore) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "tester" || pass != "sekrit" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"token": "testtoken"}`))
+			return
+		}
+
+		if store.auth && r.Header.Get("Authorization") != "Bearer testtoken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="test"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if m := testOCIRoutes.uploads.FindStringSubmatch(r.URL.Path); m != nil && r.Method == "POST" {
+			store.uploads++
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d?state=foo", m[1], store.uploads))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		if m := testOCIRoutes.upload.FindStringSubmatch(r.URL.Path); m != nil && r.Method == "PUT" {
+			body, _ := io.ReadAll(r.Body)
+			sum := sha256.Sum256(body)
+			digest := "sha256:" + hex.EncodeToString(sum[:])
+
+			if r.URL.Query().Get("state") != "foo" || r.URL.Query().Get("digest") != digest {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			store.blobs[digest] = body
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		if m := testOCIRoutes.blob.FindStringSubmatch(r.URL.Path); m != nil {
+			body, ok := store.blobs[m[2]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+
+			if r.Method == "GET" {
+				_, _ = w.Write(body)
+			}
+
+			return
+		}
+
+		if m := testOCIRoutes.manifest.FindStringSubmatch(r.URL.Path); m != nil {
+			repo, reference := m[1], m[2]
+
+			digest := reference
+			if !strings.HasPrefix(reference, "sha256:") {
+				digest = store.tags[repo+":"+reference]
+			}
+
+			switch r.Method {
+			case "PUT":
+				body, _ := io.ReadAll(r.Body)
+				sum := sha256.Sum256(body)
+				digest = "sha256:" + hex.EncodeToString(sum[:])
+
+				var manifest OCIManifest
+
+				err := json.Unmarshal(body, &manifest)
+				if err != nil || manifest.MediaType != r.Header.Get("Content-Type") {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				// everything referred to has to be there already
+				refs := append([]OCIDescriptor{}, manifest.Layers...)
+				if manifest.Config != nil {
+					refs = append(refs, *manifest.Config)
+				}
+
+				for _, ref := range refs {
+					if _, ok := store.blobs[ref.Digest]; !ok {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+				}
+
+				for _, ref := range manifest.Manifests {
+					if _, ok := store.manifests[repo+"@"+ref.Digest]; !ok {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+				}
+
+				if strings.HasPrefix(reference, "sha256:") && reference != digest {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				store.manifests[repo+"@"+digest] = testOCIManifest{contentType: r.Header.Get("Content-Type"), body: body}
+
+				if !strings.HasPrefix(reference, "sha256:") {
+					store.tags[repo+":"+reference] = digest
+				}
+
+				w.Header().Set("Docker-Content-Digest", digest)
+				w.WriteHeader(http.StatusCreated)
+
+			case "GET", "HEAD":
+				stored, ok := store.manifests[repo+"@"+digest]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				w.Header().Set("Content-Type", stored.contentType)
+				w.Header().Set("Docker-Content-Digest", digest)
+
+				if r.Method == "GET" {
+					_, _ = w.Write(stored.body)
+				}
+
+			case "DELETE":
+				if !strings.HasPrefix(reference, "sha256:") {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				delete(store.manifests, repo+"@"+digest)
+
+				for k, v := range store.tags {
+					if v == digest {
+						delete(store.tags, k)
+					}
+				}
+
+				w.WriteHeader(http.StatusAccepted)
+			}
+
+			return
+		}
+
+		if m := testOCIRoutes.tagsList.FindStringSubmatch(r.URL.Path); m != nil {
+			tags := make([]string, 0)
+
+			for k := range store.tags {
+				if strings.HasPrefix(k, m[1]+":") {
+					tags = append(tags, strings.TrimPrefix(k, m[1]+":"))
+				}
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": m[1], "tags": tags})
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+func TestParseOCIReference(t *testing.T) {
+	inputs := []struct {
+		name string
+		url  string
+		ref  OCIReference
+		errs bool
+	}{
+		{"tagged", "oci://registry.example.com/team/gomason:1.2.3", OCIReference{"registry.example.com", "team/gomason", "1.2.3"}, false},
+		{"port", "oci://localhost:5000/gomason:1.2.3", OCIReference{"localhost:5000", "gomason", "1.2.3"}, false},
+		{"latest", "OCI://localhost:5000/gomason", OCIReference{"localhost:5000", "gomason", "latest"}, false},
+		{"no repository", "oci://localhost:5000", OCIReference{}, true},
+		{"bad repository", "oci://localhost:5000/GoMason:1.2.3", OCIReference{}, true},
+		{"bad tag", "oci://localhost:5000/gomason:+1", OCIReference{}, true},
+		{"not oci", "https://localhost:5000/gomason:1.2.3", OCIReference{}, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			ref, err := ParseOCIReference(tc.url)
+			if tc.errs {
+				assert.NotNil(t, err, "Bad reference is refused")
+				return
+			}
+
+			assert.Nil(t, err, "No error parsing reference")
+			assert.Equal(t, tc.ref, ref, "Reference meets expectations")
+		})
+	}
+}
+
+func TestPublishOCI(t *testing.T) {
+	store := newTestOCIStore(true)
+	server := testOCIRegistry(store)
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	email := "gomason-tester@foo.com"
+	keyFile, keyring := writeTestOpenPGPKeys(t, tmpDir, email, "sekrit")
+
+	meta := testMetadataObj()
+	meta.Repository = fmt.Sprintf("oci://%s/nikogura/testproject", registry)
+	meta.PublishInfo.Username = "tester"
+	meta.PublishInfo.Password = "sekrit"
+	meta.Commit = "abc123"
+	meta.GitUrl = "https://github.com/nikogura/testproject"
+	meta.LocalRepo = tmpDir
+	meta.SignInfo = SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring}
+	meta.BuildInfo.Targets = []BuildTarget{
+		{Name: "linux/amd64"},
+		{Name: "darwin/arm64"},
+		{Name: "linux/arm", Flags: map[string]string{"GOARM": "6"}},
+		{Name: "linux/mips64le"},
+	}
+	meta.PublishInfo.TargetsMap = make(map[string]PublishTarget)
+
+	binaries := []struct {
+		name     string
+		platform OCIPlatform
+	}{
+		{"testproject_darwin_arm64", OCIPlatform{OS: "darwin", Architecture: "arm64"}},
+		{"testproject_linux_amd64", OCIPlatform{OS: "linux", Architecture: "amd64"}},
+		{"testproject_linux_arm", OCIPlatform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{"testproject_linux_mips64le", OCIPlatform{OS: "linux", Architecture: "mips64le"}},
+	}
+
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{KeyFile: keyFile, PassphraseFunc: "echo sekrit"}}}
+
+	for _, b := range binaries {
+		meta.PublishInfo.TargetsMap[b.name] = PublishTarget{
+			Source:      b.name,
+			Destination: "{{.Repository}}:{{.Version}}",
+			Signature:   true,
+			Checksums:   true,
+		}
+
+		file := filepath.Join(tmpDir, b.name)
+
+		err = os.WriteFile(file, []byte(testFileContent()+b.name), 0755)
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", file, err)
+		}
+
+		err = g.SignBinary(meta, file)
+		if err != nil {
+			t.Fatalf("Error signing %s: %s", file, err)
+		}
+	}
+
+	plan, err := g.PlanFile(meta, filepath.Join(tmpDir, "testproject_linux_amd64"), false, true)
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+
+	assert.Equal(t, fmt.Sprintf("OCI (registry: %s, repository: nikogura/testproject, tag: 0.1.0)", registry), plan.Uploads[0].Method, "Plan describes the push")
+	assert.Equal(t, fmt.Sprintf("with testproject_linux_amd64, in oci://%s/nikogura/testproject:0.1.0", registry), plan.Uploads[1].Method, "Plan describes the signature going along")
+
+	// the linux binary twice, to check it's replaced in the index rather than added again
+	for _, name := range []string{"testproject_linux_amd64", "testproject_linux_mips64le", "testproject_darwin_arm64", "testproject_linux_arm", "testproject_linux_amd64"} {
+		results, err := g.PublishFileWithResults(meta, filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatalf("Error publishing %s: %s", name, err)
+		}
+
+		assert.Equal(t, 5, len(results), "Every upload has a result")
+	}
+
+	index := store.manifest(t, "nikogura/testproject", "0.1.0")
+	assert.Equal(t, OCIIndexMediaType, index.MediaType, "Tag is an index")
+	assert.Equal(t, "0.1.0", index.Annotations[OCIAnnotationVersion], "Index has the version")
+	assert.Equal(t, len(binaries), len(index.Manifests), "Index has each platform once")
+
+	for i, binary := range binaries {
+		t.Run(binary.name, func(t *testing.T) {
+			desc := index.Manifests[i]
+			assert.Equal(t, binary.platform, *desc.Platform, "Platforms are sorted")
+
+			manifest := store.manifest(t, "nikogura/testproject", desc.Digest)
+			assert.Equal(t, OCIArtifactType, manifest.ArtifactType, "Manifest is a gomason artifact")
+			assert.Equal(t, OCIEmptyMediaType, manifest.Config.MediaType, "Artifact has an empty config")
+
+			expectedAnnotations := map[string]string{
+				OCIAnnotationTitle:       binary.name,
+				OCIAnnotationPackage:     testModuleName(),
+				OCIAnnotationVersion:     "0.1.0",
+				OCIAnnotationRevision:    "abc123",
+				OCIAnnotationSource:      "https://github.com/nikogura/testproject",
+				OCIAnnotationDescription: "Test Project for Gomason.",
+				OCIAnnotationOS:          binary.platform.OS,
+				OCIAnnotationArch:        binary.platform.Architecture,
+			}
+
+			for k, v := range expectedAnnotations {
+				assert.Equal(t, v, manifest.Annotations[k], "Annotation %s meets expectations", k)
+			}
+
+			layers := make(map[string]string)
+
+			for _, layer := range manifest.Layers {
+				layers[layer.Annotations[OCIAnnotationTitle]] = layer.MediaType
+			}
+
+			expectedLayers := map[string]string{
+				binary.name:             OCIFileMediaType,
+				binary.name + ".asc":    "application/pgp-signature",
+				binary.name + ".md5":    OCIChecksumMediaType,
+				binary.name + ".sha1":   OCIChecksumMediaType,
+				binary.name + ".sha256": OCIChecksumMediaType,
+			}
+
+			assert.Equal(t, expectedLayers, layers, "Layers are the file, its signature and its checksums")
+
+			sha256sum, _ := FileSha256(filepath.Join(tmpDir, binary.name))
+			assert.Equal(t, sha256sum, string(store.blobs[manifest.Layers[4].Digest]), "Checksum was pushed")
+
+			// what was published is what verify checks, for the right platform
+			verifyDir := filepath.Join(tmpDir, "verify", binary.name)
+
+			err := os.MkdirAll(verifyDir, 0755)
+			if err != nil {
+				t.Fatalf("Error creating %s: %s", verifyDir, err)
+			}
+
+			result, err := g.VerifyTarget(meta, binary.name, verifyDir)
+			assert.Nil(t, err, "No error verifying what was published")
+			assert.Equal(t, ChecksumTypes, result.Checksums, "Checksums were checked")
+			assert.Equal(t, 1, len(result.Signatures), "Signature was checked")
+
+			content, _ := os.ReadFile(filepath.Join(verifyDir, binary.name))
+			assert.Equal(t, testFileContent()+binary.name, string(content), "Got the platform's own binary")
+		})
+	}
+
+	// a file that isn't one of the build targets' binaries can't clobber the index
+	notes := filepath.Join(tmpDir, "notes.txt")
+
+	err = os.WriteFile(notes, []byte("release notes"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", notes, err)
+	}
+
+	meta.PublishInfo.TargetsMap["notes.txt"] = PublishTarget{Source: "notes.txt", Destination: "{{.Repository}}:{{.Version}}"}
+
+	_, err = g.PublishFileWithResults(meta, notes)
+	assert.IsType(t, OCIIndexError{}, errors.Cause(err), "Tagging over the index is refused")
+
+	index = store.manifest(t, "nikogura/testproject", "0.1.0")
+	assert.Equal(t, len(binaries), len(index.Manifests), "Index is untouched")
+
+	_, err = g.VerifyTarget(meta, "notes.txt", tmpDir)
+	assert.IsType(t, OCIIndexError{}, errors.Cause(err), "Verifying a file that isn't in the index fails")
+}
+
+func TestOCISourceAnnotation(t *testing.T) {
+	store := newTestOCIStore(false)
+	server := testOCIRegistry(store)
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	notes := filepath.Join(tmpDir, "notes.txt")
+
+	err = os.WriteFile(notes, []byte(testFileContent()), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", notes, err)
+	}
+
+	dst := fmt.Sprintf("oci://%s/nikogura/notes:0.1.0", strings.TrimPrefix(server.URL, "http://"))
+
+	publisher, err := GetPublisher(dst)
+	if err != nil {
+		t.Fatalf("Error getting publisher: %s", err)
+	}
+
+	// where a releaser's checkout is isn't anyone else's business
+	meta := testMetadataObj()
+	meta.GitUrl = "/home/tester/src/testproject"
+
+	err = publisher.(BundlePublisher).PutBundle(meta, []PlannedUpload{{Kind: UploadKindArtifact, Source: notes, Destination: dst}}, "", "")
+	if err != nil {
+		t.Fatalf("Error putting %s: %s", dst, err)
+	}
+
+	manifest := store.manifest(t, "nikogura/notes", "0.1.0")
+	assert.NotContains(t, manifest.Annotations, OCIAnnotationSource, "Local paths aren't published as the source")
+}
+
+func TestOCIPublisher(t *testing.T) {
+	store := newTestOCIStore(false)
+	server := testOCIRegistry(store)
+	defer server.Close()
+
+	base := fmt.Sprintf("oci://%s/nikogura/notes", strings.TrimPrefix(server.URL, "http://"))
+	file := base + ":0.1.0"
+
+	publisher, err := GetPublisher(file)
+	if err != nil {
+		t.Fatalf("Error getting publisher: %s", err)
+	}
+
+	exists, err := publisher.Head(file, "", "")
+	assert.Nil(t, err, "No error checking for missing artifact")
+	assert.False(t, exists, "Artifact isn't there yet")
+
+	err = publisher.Put(file, strings.NewReader(testFileContent()), Checksums{}, "", "")
+	if err != nil {
+		t.Fatalf("Error putting %s: %s", file, err)
+	}
+
+	exists, err = publisher.Head(file, "", "")
+	assert.Nil(t, err, "No error checking for artifact")
+	assert.True(t, exists, "Artifact is there")
+
+	manifest := store.manifest(t, "nikogura/notes", "0.1.0")
+	assert.Equal(t, OCIManifestMediaType, manifest.MediaType, "Plain files are tagged directly")
+	assert.Equal(t, "notes", manifest.Layers[0].Annotations[OCIAnnotationTitle], "Layer is named for the repository")
+
+	var out bytes.Buffer
+
+	err = publisher.Get(file, &out, "", "")
+	assert.Nil(t, err, "No error getting artifact")
+	assert.Equal(t, testFileContent(), out.String(), "Got what was put")
+
+	urls, err := publisher.List(base, "", "")
+	assert.Nil(t, err, "No error listing")
+	assert.Equal(t, []string{file}, urls, "Listing has the tag")
+
+	err = publisher.Delete(file, "", "")
+	assert.Nil(t, err, "No error deleting")
+
+	exists, err = publisher.Head(file, "", "")
+	assert.Nil(t, err, "No error checking for deleted artifact")
+	assert.False(t, exists, "Deleted artifact is gone")
+
+	err = publisher.Delete(file, "", "")
+	assert.Nil(t, err, "Deleting something that's not there is fine")
+}
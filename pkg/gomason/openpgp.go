@@ -0,0 +1,254 @@
+package gomason
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SigningProgramOpenPGP signs in process with an armored private key, rather than with the gpg binary.  The signatures are the same detached, armored .asc files gpg makes.
+const SigningProgramOpenPGP = "openpgp"
+
+func init() {
+	signersMap[SigningProgramOpenPGP] = OpenPGPSigner{}
+}
+
+// OpenPGPSigner signs and verifies in process with armored openpgp keys.
+type OpenPGPSigner struct{}
+
+// Sign signs binary with the key for the identity's email in its key file from ~/.gomason.
+func (OpenPGPSigner) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	if identity.Email == "" {
+		return ErrNoSigningEntity
+	}
+
+	return SignOpenPGP(identity, binary, sigFile)
+}
+
+// Verify verifies the signature of binary against the identity's keyring from the metadata file.
+func (OpenPGPSigner) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (ok bool, err error) {
+	return VerifyOpenPGP(identity, binary, sigFile)
+}
+
+// SignatureSuffix returns '.asc', the same as gpg.
+func (OpenPGPSigner) SignatureSuffix() string {
+	return GPGSignatureSuffix
+}
+
+// SignOpenPGP signs a given binary with the key for the identity's email from its armored private key file, writing the signature to sigFile.  If the key is encrypted, the passphrase is gotten from the identity's passphrasefunc.
+func SignOpenPGP(identity SigningIdentity, binary string, sigFile string) (err error) {
+	keyFile := identity.KeyFile
+	if keyFile == "" {
+		err = errors.New(fmt.Sprintf("signing with openpgp needs 'keyfile' set in the '%s' section of ~/.gomason", identity.ConfigSection()))
+		return err
+	}
+
+	entity, err := OpenPGPSigningEntity(keyFile, identity.Email)
+	if err != nil {
+		return err
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if identity.PassphraseFunc == "" {
+			err = errors.New(fmt.Sprintf("key in %s is encrypted, and there's no 'passphrasefunc' in the '%s' section of ~/.gomason to get the passphrase from", keyFile, identity.ConfigSection()))
+			return err
+		}
+
+		passphrase, err := GetFunc(identity.PassphraseFunc)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get passphrase from passphrasefunc")
+			return err
+		}
+
+		err = entity.DecryptPrivateKeys([]byte(passphrase))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to decrypt key in %s", keyFile)
+			return err
+		}
+	}
+
+	data, err := os.Open(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", binary)
+		return err
+	}
+
+	defer data.Close()
+
+	sig, err := os.Create(sigFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create %s", sigFile)
+		return err
+	}
+
+	defer sig.Close()
+
+	logrus.Debugf("Signing %s with openpgp key %s", binary, entity.PrimaryKey.KeyIdString())
+
+	err = openpgp.ArmoredDetachSign(sig, entity, data, nil)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sign %s", binary)
+		return err
+	}
+
+	return err
+}
+
+// OpenPGPSigningEntity reads an armored private key file, and returns the key that has an identity with the given email address.
+func OpenPGPSigningEntity(keyFile string, email string) (entity *openpgp.Entity, err error) {
+	entities, err := ReadArmoredKeyRing(keyFile)
+	if err != nil {
+		return entity, err
+	}
+
+	for _, e := range entities {
+		if e.PrivateKey == nil {
+			continue
+		}
+
+		for _, identity := range e.Identities {
+			if identity.UserId != nil && strings.EqualFold(identity.UserId.Email, email) {
+				return e, err
+			}
+		}
+	}
+
+	err = errors.New(fmt.Sprintf("no private key for %s in %s", email, keyFile))
+
+	return entity, err
+}
+
+// ReadArmoredKeyRing reads an armored keyring from a file.  It can hold public or private keys.
+func ReadArmoredKeyRing(keyFile string) (entities openpgp.EntityList, err error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", keyFile)
+		return entities, err
+	}
+
+	defer f.Close()
+
+	entities, err = openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read armored keyring %s", keyFile)
+		return entities, err
+	}
+
+	return entities, err
+}
+
+// VerifyOpenPGP verifies the detached, armored signature of a binary in sigFile against the identity's armored public keyring.
+func VerifyOpenPGP(identity SigningIdentity, binary string, sigFile string) (ok bool, err error) {
+	keyring := identity.Keyring
+	if keyring == "" {
+		err = errors.New("verifying with openpgp needs 'keyring' set in the 'signing' section of the metadata file")
+		return ok, err
+	}
+
+	signer, err := checkOpenPGPSignature(keyring, binary, sigFile)
+	if err != nil {
+		if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+			err = UnknownSignerError{File: binary, Signer: "a key not in " + keyring}
+			return ok, err
+		}
+
+		err = BadSignatureError{File: binary, Reason: err.Error()}
+		return ok, err
+	}
+
+	logrus.Debugf("Good signature on %s from key %s", binary, signer.PrimaryKey.KeyIdString())
+
+	ok = true
+
+	return ok, err
+}
+
+// Inspect verifies the signature of binary, and says which key made it.
+func (OpenPGPSigner) Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (signer SignatureSigner, err error) {
+	return InspectOpenPGP(identity, binary, sigFile)
+}
+
+// InspectOpenPGP verifies the signature of a binary in sigFile against the identity's keyring, and returns the key that made it, with whichever of its identities aren't revoked.  The signer's own key file is never used, as the identities in it are only what the signer says they are.  A signature by an expired or revoked key is reported as such, rather than as an error.
+func InspectOpenPGP(identity SigningIdentity, binary string, sigFile string) (signer SignatureSigner, err error) {
+	keyring := identity.Keyring
+	if keyring == "" {
+		err = errors.New(fmt.Sprintf("checking who made openpgp signatures needs 'keyring' set to the keys you trust for %s in the 'signing' section of the metadata file", identity))
+		return signer, err
+	}
+
+	entity, err := checkOpenPGPSignature(keyring, binary, sigFile)
+	if err != nil {
+		switch {
+		case entity == nil && errors.Is(err, pgperrors.ErrUnknownIssuer):
+			err = UnknownSignerError{File: binary, Signer: "a key not in " + keyring}
+			return signer, err
+		case entity == nil:
+			err = BadSignatureError{File: binary, Reason: err.Error()}
+			return signer, err
+		case errors.Is(err, pgperrors.ErrKeyRevoked):
+			signer.Revoked = true
+		case errors.Is(err, pgperrors.ErrKeyExpired), errors.Is(err, pgperrors.ErrSignatureExpired):
+			signer.Expired = true
+		default:
+			err = BadSignatureError{File: binary, Reason: err.Error()}
+			return signer, err
+		}
+
+		err = nil
+	}
+
+	signer.Fingerprint = fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+	signer.Identities = make([]string, 0)
+
+	now := time.Now()
+
+	for name, id := range entity.Identities {
+		if !id.Revoked(now) {
+			signer.Identities = append(signer.Identities, name)
+		}
+	}
+
+	sort.Strings(signer.Identities)
+
+	return signer, err
+}
+
+// checkOpenPGPSignature checks the detached, armored signature of a binary in sigFile against the armored keyring.  As with openpgp.CheckDetachedSignature, the entity that made the signature is returned along with any error about its key being expired or revoked.
+func checkOpenPGPSignature(keyring string, binary string, sigFile string) (signer *openpgp.Entity, err error) {
+	entities, err := ReadArmoredKeyRing(keyring)
+	if err != nil {
+		return signer, err
+	}
+
+	data, err := os.Open(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", binary)
+		return signer, err
+	}
+
+	defer data.Close()
+
+	sig, err := os.Open(sigFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open %s", sigFile)
+		return signer, err
+	}
+
+	defer sig.Close()
+
+	block, err := armor.Decode(sig)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to decode %s", sigFile)
+		return signer, err
+	}
+
+	return openpgp.CheckDetachedSignature(entities, data, block.Body, nil)
+}
@@ -0,0 +1,134 @@
+package gomason
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestOpenPGPKeys generates a key for the given email, and writes it, encrypted with the passphrase, and its public keyring into dir.
+func writeTestOpenPGPKeys(t *testing.T, dir string, email string, passphrase string) (keyFile string, keyring string) {
+	entity, err := openpgp.NewEntity("Gomason Tester", "with a passphrase", email, nil)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	keyring = filepath.Join(dir, "pubring.asc")
+
+	f, err := os.Create(keyring)
+	if err != nil {
+		t.Fatalf("Error creating %s: %s", keyring, err)
+	}
+
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Error encoding public key: %s", err)
+	}
+
+	err = entity.Serialize(w)
+	if err != nil {
+		t.Fatalf("Error serializing public key: %s", err)
+	}
+
+	_ = w.Close()
+	_ = f.Close()
+
+	err = entity.EncryptPrivateKeys([]byte(passphrase), nil)
+	if err != nil {
+		t.Fatalf("Error encrypting private key: %s", err)
+	}
+
+	keyFile = filepath.Join(dir, "secring.asc")
+
+	f, err = os.Create(keyFile)
+	if err != nil {
+		t.Fatalf("Error creating %s: %s", keyFile, err)
+	}
+
+	w, err = armor.Encode(f, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("Error encoding private key: %s", err)
+	}
+
+	err = entity.SerializePrivateWithoutSigning(w, nil)
+	if err != nil {
+		t.Fatalf("Error serializing private key: %s", err)
+	}
+
+	_ = w.Close()
+	_ = f.Close()
+
+	return keyFile, keyring
+}
+
+func TestSignVerifyOpenPGP(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	email := "gomason-tester@foo.com"
+	keyFile, keyring := writeTestOpenPGPKeys(t, dir, email, "sekrit")
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	meta := testMetadataObj()
+	meta.SignInfo = SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring}
+
+	inputs := []struct {
+		name           string
+		email          string
+		passphraseFunc string
+		signErr        bool
+	}{
+		{"good", email, "echo sekrit", false},
+		{"wrong passphrase", email, "echo wrong", true},
+		{"no passphrase", email, "", true},
+		{"no key for email", "someone-else@foo.com", "echo sekrit", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			g := Gomason{
+				Config: UserConfig{
+					User:    UserInfo{Email: tc.email},
+					Signing: UserSignInfo{KeyFile: keyFile, PassphraseFunc: tc.passphraseFunc},
+				},
+			}
+
+			err := g.SignBinary(meta, binary)
+			if tc.signErr {
+				assert.NotNil(t, err, "Signing fails")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			ok, err := VerifyBinary(binary, meta)
+			assert.Nil(t, err, "No error verifying signature")
+			assert.True(t, ok, "Signature verifies")
+		})
+	}
+
+	// tamper with the binary
+	err = os.WriteFile(binary, []byte("not what was signed"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	ok, err := VerifyBinary(binary, meta)
+	assert.NotNil(t, err, "Error verifying tampered binary")
+	assert.False(t, ok, "Tampered binary doesn't verify")
+}
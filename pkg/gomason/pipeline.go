@@ -0,0 +1,328 @@
+package gomason
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StageCheckout checks the code out into the workspace
+	StageCheckout = "checkout"
+	// StagePrep runs the prep commands from the metadata file
+	StagePrep = "prep"
+	// StageTest runs the tests
+	StageTest = "test"
+	// StageBuild builds the binaries and extras
+	StageBuild = "build"
+	// StageArtifacts signs, publishes and/or collects the built binaries
+	StageArtifacts = "artifacts"
+	// StageExtras signs, publishes and/or collects the built extras
+	StageExtras = "extras"
+	// StagePrebuilt signs and/or publishes files that were built outside of gomason
+	StagePrebuilt = "prebuilt"
+	// StageSha256Sums publishes a signed SHA256SUMS file covering everything that was built
+	StageSha256Sums = "sha256sums"
+	// StageManifest publishes a manifest of everything that was published
+	StageManifest = "manifest"
+)
+
+// PipelineOptions controls which stages of a Pipeline are run, and how.
+type PipelineOptions struct {
+	// Branch to check out.  Empty means the default branch.
+	Branch string
+	// Local does all the work in the current working directory with whatever is there, rather than checking code out into the workspace.
+	Local bool
+	// Committed checks out what's committed in the local git repository, at Branch or HEAD, rather than fetching from the remote.  Nothing uncommitted makes it into the workspace.
+	Committed bool
+	// WorkDir is a persistent workspace to use.  Empty means an ephemeral temp dir.
+	WorkDir string
+	// KeepOnFailure keeps an ephemeral workspace if anything fails.
+	KeepOnFailure bool
+	// SkipTests skips the test stage.
+	SkipTests bool
+	// Build runs the build stage, and handles the artifacts thus built.
+	Build bool
+	// Prebuilt skips checking out, testing and building altogether, and signs and/or publishes the publishing targets as found in the current working directory.
+	Prebuilt bool
+	// Sign signs the artifacts.
+	Sign bool
+	// Publish publishes the artifacts.
+	Publish bool
+	// Collect copies the artifacts into the current working directory.
+	Collect bool
+	// SkipTargets is a comma separated list of build targets to skip.
+	SkipTargets string
+	// TestTimeout is passed to the language's test command.
+	TestTimeout string
+	// Parallelism overrides the number of targets built at once, if set.
+	Parallelism int
+	// DryRun prints what signing and publishing would do, rather than doing it.
+	DryRun bool
+}
+
+// BeforeStageFunc is called before a stage runs.  Returning an error stops the pipeline.
+type BeforeStageFunc func(p *Pipeline, stage string) error
+
+// AfterStageFunc is called after a stage runs, with the error the stage returned, if any.  Returning an error stops the pipeline.
+type AfterStageFunc func(p *Pipeline, stage string, stageErr error) error
+
+// Pipeline runs the stages of testing, building, signing and publishing code in a clean workspace.  It's what the gomason commands use, and it can be embedded in other tools.
+type Pipeline struct {
+	Gomason     *Gomason
+	Meta        Metadata
+	Language    Language
+	Options     PipelineOptions
+	Cwd         string
+	Workspace   *Workspace
+	WorkDir     string
+	Kept        bool
+	BeforeStage []BeforeStageFunc
+	AfterStage  []AfterStageFunc
+}
+
+// NewPipeline creates a Pipeline for the given metadata.  Options on the command line, such as parallelism, override what's in the metadata.
+func NewPipeline(g *Gomason, meta Metadata, opts PipelineOptions) (p *Pipeline, err error) {
+	lang, err := GetByName(meta.GetLanguage())
+	if err != nil {
+		err = errors.Wrapf(err, "invalid language")
+		return p, err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		err = errors.Wrapf(err, "failed getting CWD")
+		return p, err
+	}
+
+	if opts.Committed {
+		if opts.Local {
+			err = errors.New("can't build both in place and from what's committed.  Pick one")
+			return p, err
+		}
+
+		repoDir, err := GitTopLevel(cwd)
+		if err != nil {
+			err = errors.Wrapf(err, "failed finding the git repository containing %s", cwd)
+			return p, err
+		}
+
+		dirty, err := GitDirty(repoDir)
+		if err != nil {
+			err = errors.Wrapf(err, "failed checking %s for uncommitted changes", repoDir)
+			return p, err
+		}
+
+		if dirty {
+			logrus.Warnf("%s has uncommitted changes.  They will not be tested, built or published.", repoDir)
+		}
+
+		// check out from the local repository instead of the remote
+		meta.LocalRepo = repoDir
+	}
+
+	if opts.Parallelism > 0 {
+		meta.BuildInfo.Parallelism = opts.Parallelism
+	}
+
+	if opts.DryRun {
+		g.DryRun = true
+	}
+
+	p = &Pipeline{
+		Gomason:     g,
+		Meta:        meta,
+		Language:    lang,
+		Options:     opts,
+		Cwd:         cwd,
+		BeforeStage: make([]BeforeStageFunc, 0),
+		AfterStage:  make([]AfterStageFunc, 0),
+	}
+
+	return p, err
+}
+
+// Stages returns the names of the stages the pipeline will run, in order.
+func (p *Pipeline) Stages() (stages []string) {
+	stages = make([]string, 0)
+
+	// release level files, once everything else is published
+	release := make([]string, 0)
+
+	if p.Options.Publish && p.Meta.PublishInfo.Sha256Sums != "" {
+		release = append(release, StageSha256Sums)
+	}
+
+	if p.Options.Publish && p.Meta.PublishInfo.Manifest != "" {
+		release = append(release, StageManifest)
+	}
+
+	if p.Options.Prebuilt {
+		stages = append(stages, StagePrebuilt)
+		stages = append(stages, release...)
+
+		return stages
+	}
+
+	if !p.Options.Local {
+		stages = append(stages, StageCheckout)
+	}
+
+	stages = append(stages, StagePrep)
+
+	if !p.Options.SkipTests {
+		stages = append(stages, StageTest)
+	}
+
+	if p.Options.Build {
+		stages = append(stages, StageBuild, StageArtifacts, StageExtras)
+		stages = append(stages, release...)
+	}
+
+	return stages
+}
+
+// Run creates the workspace, runs each stage in order, and cleans up.  The workspace is kept if it's persistent, or if something failed and we were asked to keep it.  p.Kept says whether it was.
+func (p *Pipeline) Run() (err error) {
+	p.Workspace, err = NewWorkspace(p.Options.WorkDir, p.Options.KeepOnFailure)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create workspace")
+		return err
+	}
+
+	err = p.run()
+
+	// stages change directories.  Don't leave us sitting in a workspace that's about to be removed.
+	_ = os.Chdir(p.Cwd)
+
+	kept, cleanupErr := p.Workspace.Cleanup(err != nil)
+	if cleanupErr != nil {
+		logrus.Warnf("Failed to clean up workspace: %s", cleanupErr)
+	}
+
+	p.Kept = kept
+
+	return err
+}
+
+func (p *Pipeline) run() (err error) {
+	p.WorkDir, err = p.Language.CreateWorkDir(p.Workspace.Dir)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create work dir in %s", p.Workspace.Dir)
+		return err
+	}
+
+	// the code's already there, so there's no checkout to find the commit after
+	if p.Options.Local || p.Options.Prebuilt {
+		p.resolveCommit()
+	}
+
+	for _, stage := range p.Stages() {
+		for _, hook := range p.BeforeStage {
+			err = hook(p, stage)
+			if err != nil {
+				err = errors.Wrapf(err, "before %s hook failed", stage)
+				return err
+			}
+		}
+
+		logrus.Debugf("Running stage %s", stage)
+
+		stageErr := p.RunStage(stage)
+
+		for _, hook := range p.AfterStage {
+			err = hook(p, stage, stageErr)
+			if err != nil && stageErr == nil {
+				err = errors.Wrapf(err, "after %s hook failed", stage)
+				return err
+			}
+		}
+
+		if stageErr != nil {
+			err = errors.Wrapf(stageErr, "%s failed", stage)
+			return err
+		}
+	}
+
+	return err
+}
+
+// resolveCommit records the commit being built in the metadata, for templates such as '{{.Commit}}'.  Code that isn't in a git repository has no commit.
+func (p *Pipeline) resolveCommit() {
+	commit, err := GitCommit(p.CodeDir())
+	if err != nil {
+		logrus.Debugf("Failed to get the commit of %s: %s", p.CodeDir(), err)
+		return
+	}
+
+	p.Meta.Commit = commit
+}
+
+// CodeDir returns the directory the code is built in.  That's the current working directory if we're working locally, or where the code was checked out into the workspace otherwise.
+func (p *Pipeline) CodeDir() string {
+	if p.Options.Local || p.Options.Prebuilt {
+		return p.Cwd
+	}
+
+	return filepath.Join(p.WorkDir, "src", p.Meta.Package)
+}
+
+// RunStage runs a single named stage.
+func (p *Pipeline) RunStage(stage string) (err error) {
+	meta := p.Meta
+	opts := p.Options
+
+	switch stage {
+	case StageCheckout:
+		err = p.Language.Checkout(p.WorkDir, meta, opts.Branch)
+		if err != nil {
+			return err
+		}
+
+		p.resolveCommit()
+
+		return err
+
+	case StagePrep:
+		return p.Language.Prep(p.WorkDir, meta, opts.Local)
+
+	case StageTest:
+		return p.Language.Test(p.WorkDir, meta.Package, opts.TestTimeout, opts.Local)
+
+	case StageBuild:
+		return p.Language.Build(p.WorkDir, meta, opts.SkipTargets, opts.Local)
+
+	case StageArtifacts:
+		return p.Gomason.HandleArtifacts(meta, p.WorkDir, p.Cwd, opts.Sign, opts.Publish, opts.Collect, opts.SkipTargets, opts.Local)
+
+	case StageExtras:
+		return p.Gomason.HandleExtras(meta, p.WorkDir, p.Cwd, opts.Sign, opts.Publish, opts.Collect, opts.Local)
+
+	case StagePrebuilt:
+		sources := make([]string, 0)
+
+		for _, t := range meta.PublishInfo.Targets {
+			sources = append(sources, t.Source)
+		}
+
+		return p.Gomason.HandleFiles(meta, sources, p.Cwd, opts.Sign, opts.Publish, false)
+
+	case StageSha256Sums:
+		return p.Gomason.PublishSha256Sums(meta, p.Workspace.Dir, opts.Sign)
+
+	case StageManifest:
+		commit, err := GitCommit(p.CodeDir())
+		if err != nil {
+			logrus.Warnf("Failed to get the commit of %s.  It won't be in the manifest: %s", p.CodeDir(), err)
+		}
+
+		return p.Gomason.PublishManifest(meta, p.Workspace.Dir, commit, opts.Sign)
+	}
+
+	err = errors.New(fmt.Sprintf("unknown stage %q", stage))
+
+	return err
+}
@@ -0,0 +1,311 @@
+package gomason
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingLanguage is a Language that just records what it was asked to do
+type recordingLanguage struct {
+	NoLanguage
+	calls  []string
+	failAt string
+}
+
+func (l *recordingLanguage) record(call string) error {
+	l.calls = append(l.calls, call)
+	if call == l.failAt {
+		return errors.New("boom")
+	}
+
+	return nil
+}
+
+func (l *recordingLanguage) Checkout(workdir string, meta Metadata, branch string) error {
+	return l.record(StageCheckout)
+}
+
+func (l *recordingLanguage) Prep(workdir string, meta Metadata, local bool) error {
+	return l.record(StagePrep)
+}
+
+func (l *recordingLanguage) Test(workdir string, module string, timeout string, local bool) error {
+	return l.record(StageTest)
+}
+
+func (l *recordingLanguage) Build(workdir string, meta Metadata, skipTargets string, local bool) error {
+	return l.record(StageBuild)
+}
+
+func TestPipelineStages(t *testing.T) {
+	inputs := []struct {
+		name     string
+		opts     PipelineOptions
+		manifest string
+		sums     string
+		expected []string
+	}{
+		{
+			"test",
+			PipelineOptions{},
+			"",
+			"",
+			[]string{StageCheckout, StagePrep, StageTest},
+		},
+		{
+			"build local skip tests",
+			PipelineOptions{Local: true, SkipTests: true, Build: true},
+			"",
+			"",
+			[]string{StagePrep, StageBuild, StageArtifacts, StageExtras},
+		},
+		{
+			"publish prebuilt",
+			PipelineOptions{Prebuilt: true, Build: true, Publish: true},
+			"",
+			"",
+			[]string{StagePrebuilt},
+		},
+		{
+			"publish with manifest",
+			PipelineOptions{Build: true, Publish: true},
+			"{{.Repository}}/manifest.json",
+			"",
+			[]string{StageCheckout, StagePrep, StageTest, StageBuild, StageArtifacts, StageExtras, StageManifest},
+		},
+		{
+			"publish with sha256sums and manifest",
+			PipelineOptions{Build: true, Publish: true},
+			"{{.Repository}}/manifest.json",
+			"{{.Repository}}/SHA256SUMS",
+			[]string{StageCheckout, StagePrep, StageTest, StageBuild, StageArtifacts, StageExtras, StageSha256Sums, StageManifest},
+		},
+		{
+			"publish prebuilt with sha256sums",
+			PipelineOptions{Prebuilt: true, Publish: true},
+			"",
+			"{{.Repository}}/SHA256SUMS",
+			[]string{StagePrebuilt, StageSha256Sums},
+		},
+		{
+			"build with manifest",
+			PipelineOptions{Build: true},
+			"{{.Repository}}/manifest.json",
+			"",
+			[]string{StageCheckout, StagePrep, StageTest, StageBuild, StageArtifacts, StageExtras},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := testMetadataObj()
+			meta.PublishInfo.Manifest = tc.manifest
+			meta.PublishInfo.Sha256Sums = tc.sums
+
+			p, err := NewPipeline(&Gomason{}, meta, tc.opts)
+			if err != nil {
+				t.Fatalf("Error creating pipeline: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, p.Stages(), "Stages meet expectations")
+		})
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	inputs := []struct {
+		name     string
+		opts     PipelineOptions
+		failAt   string
+		calls    []string
+		hooks    []string
+		failed   bool
+		kept     bool
+		parallel int
+	}{
+		{
+			"success",
+			PipelineOptions{Parallelism: 3},
+			"",
+			[]string{StageCheckout, StagePrep, StageTest},
+			[]string{"before checkout", "after checkout", "before prep", "after prep", "before test", "after test"},
+			false,
+			false,
+			3,
+		},
+		{
+			"failure",
+			PipelineOptions{},
+			StagePrep,
+			[]string{StageCheckout, StagePrep},
+			[]string{"before checkout", "after checkout", "before prep", "after prep failed"},
+			true,
+			false,
+			0,
+		},
+		{
+			"failure keep",
+			PipelineOptions{KeepOnFailure: true},
+			StageTest,
+			[]string{StageCheckout, StagePrep, StageTest},
+			[]string{"before checkout", "after checkout", "before prep", "after prep", "before test", "after test failed"},
+			true,
+			true,
+			0,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewPipeline(&Gomason{}, testMetadataObj(), tc.opts)
+			if err != nil {
+				t.Fatalf("Error creating pipeline: %s", err)
+			}
+
+			lang := &recordingLanguage{calls: make([]string, 0), failAt: tc.failAt}
+			p.Language = lang
+
+			hooks := make([]string, 0)
+
+			p.BeforeStage = append(p.BeforeStage, func(p *Pipeline, stage string) error {
+				hooks = append(hooks, "before "+stage)
+				return nil
+			})
+
+			p.AfterStage = append(p.AfterStage, func(p *Pipeline, stage string, stageErr error) error {
+				if stageErr != nil {
+					hooks = append(hooks, "after "+stage+" failed")
+					return nil
+				}
+
+				hooks = append(hooks, "after "+stage)
+				return nil
+			})
+
+			err = p.Run()
+			assert.Equal(t, tc.failed, err != nil, "Pipeline error meets expectations")
+			assert.Equal(t, tc.calls, lang.calls, "Stages run meet expectations")
+			assert.Equal(t, tc.hooks, hooks, "Hooks called meet expectations")
+			assert.Equal(t, tc.kept, p.Kept, "Workspace retention meets expectations")
+			assert.Equal(t, tc.parallel, p.Meta.BuildInfo.Parallelism, "Parallelism option is applied to metadata")
+
+			_, err = os.Stat(p.Workspace.Dir)
+			assert.Equal(t, tc.kept, err == nil, "Workspace existence meets expectations")
+
+			if p.Kept {
+				_ = os.RemoveAll(p.Workspace.Dir)
+			}
+		})
+	}
+}
+
+func TestPipelineBeforeHookStops(t *testing.T) {
+	p, err := NewPipeline(&Gomason{}, testMetadataObj(), PipelineOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pipeline: %s", err)
+	}
+
+	lang := &recordingLanguage{calls: make([]string, 0)}
+	p.Language = lang
+
+	p.BeforeStage = append(p.BeforeStage, func(p *Pipeline, stage string) error {
+		if stage == StageTest {
+			return errors.New("not today")
+		}
+
+		return nil
+	})
+
+	err = p.Run()
+	assert.NotNil(t, err, "Before hook error stops the pipeline")
+	assert.Equal(t, []string{StageCheckout, StagePrep}, lang.calls, "Test stage did not run")
+}
+
+func TestPipelineCommitted(t *testing.T) {
+	repo, refs := testGitRepo(t)
+	defer os.RemoveAll(repo)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting cwd: %s", err)
+	}
+	defer os.Chdir(cwd)
+
+	err = os.Chdir(repo)
+	if err != nil {
+		t.Fatalf("Error changing to %s: %s", repo, err)
+	}
+
+	// uncommitted work shouldn't make it into the workspace
+	err = os.WriteFile(filepath.Join(repo, "uncommitted"), []byte("uncommitted"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing uncommitted file: %s", err)
+	}
+
+	_, err = NewPipeline(&Gomason{}, testMetadataObj(), PipelineOptions{Committed: true, Local: true})
+	assert.NotNil(t, err, "Committed and local together is an error")
+
+	p, err := NewPipeline(&Gomason{}, testMetadataObj(), PipelineOptions{Committed: true})
+	if err != nil {
+		t.Fatalf("Error creating pipeline: %s", err)
+	}
+
+	expectedRepo, _ := filepath.EvalSymlinks(repo)
+	actualRepo, _ := filepath.EvalSymlinks(p.Meta.CheckoutURL())
+	assert.Equal(t, expectedRepo, actualRepo, "Code is checked out from the local repository")
+	assert.Equal(t, testMetadataObj().GitURL(), p.Meta.GitURL(), "Where the code comes from is still the remote")
+
+	gopath, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(gopath)
+
+	err = Golang{}.Checkout(gopath, p.Meta, "")
+	if err != nil {
+		t.Fatalf("Error checking out committed code: %s", err)
+	}
+
+	codepath := filepath.Join(gopath, "src", p.Meta.Package)
+
+	commit, err := RunGit("git", codepath, "rev-parse", "HEAD")
+	assert.Nil(t, err, "No error getting checked out commit")
+	assert.Equal(t, refs["main"], commit, "Local HEAD is checked out")
+
+	_, err = os.Stat(filepath.Join(codepath, "uncommitted"))
+	assert.True(t, os.IsNotExist(err), "Uncommitted files are left out")
+}
+
+func TestPipelineCommit(t *testing.T) {
+	repo, refs := testGitRepo(t)
+	defer os.RemoveAll(repo)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting cwd: %s", err)
+	}
+	defer os.Chdir(cwd)
+
+	err = os.Chdir(repo)
+	if err != nil {
+		t.Fatalf("Error changing to %s: %s", repo, err)
+	}
+
+	p, err := NewPipeline(&Gomason{}, testMetadataObj(), PipelineOptions{Local: true, SkipTests: true})
+	if err != nil {
+		t.Fatalf("Error creating pipeline: %s", err)
+	}
+
+	p.Language = &recordingLanguage{calls: make([]string, 0)}
+
+	err = p.Run()
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, refs["main"], p.Meta.Commit, "Commit being built is available to templates")
+}
@@ -0,0 +1,294 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// UploadKindArtifact an upload of the built file itself
+	UploadKindArtifact = "artifact"
+	// UploadKindSignature an upload of the detached signature of a built file
+	UploadKindSignature = "signature"
+	// UploadKindChecksum an upload of a checksum file for a built file
+	UploadKindChecksum = "checksum"
+)
+
+// PlannedUpload is a single file that would be uploaded when publishing.  For checksums, Source is the file that was checksummed, and SumType is the type of checksum.  AWS is the AWS settings for the target, for uploads to S3.  Platform is the build target a binary was built for, and is nil for anything else.
+type PlannedUpload struct {
+	Kind        string
+	Source      string
+	SumType     string
+	Destination string
+	Method      string
+	AWS         AWSConfig
+	Platform    *BuildPlatform
+}
+
+// BuildPlatform is the OS and architecture of a build target, and for arm, which version of it, e.g. 'v7'.
+type BuildPlatform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// String returns the platform as 'os/arch', or 'os/arch/variant'.
+func (p BuildPlatform) String() string {
+	if p.Variant == "" {
+		return fmt.Sprintf("%s/%s", p.OS, p.Arch)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", p.OS, p.Arch, p.Variant)
+}
+
+// BuildPlatformFor figures out which build target a file was built for, the same way HandleArtifacts does: it's one of the binaries named for the target, or if none are, its name ends in '_<os>_<arch>'.  ok is false if the file isn't a binary built for any of them.
+func (m Metadata) BuildPlatformFor(filePath string) (platform BuildPlatform, ok bool) {
+	name := filepath.Base(filePath)
+
+	for _, target := range m.BuildInfo.Targets {
+		archparts := strings.Split(target.Name, "/")
+		if len(archparts) != 2 {
+			continue
+		}
+
+		osname := archparts[0]
+		archname := archparts[1]
+
+		matched := false
+
+		if len(m.BuildInfo.Binaries) > 0 {
+			outputs, err := BinaryOutputs(m.BuildInfo.Binaries, filepath.Dir(filePath), osname, archname)
+			if err == nil {
+				for _, output := range outputs {
+					matched = matched || output.File == name
+				}
+			}
+		}
+
+		if !matched {
+			targetRegex := regexp.MustCompile(fmt.Sprintf("^.+_%s_%s(%s)?$", regexp.QuoteMeta(osname), regexp.QuoteMeta(archname), regexp.QuoteMeta(ExecutableExtension(osname))))
+			matched = targetRegex.MatchString(name)
+		}
+
+		if !matched {
+			continue
+		}
+
+		platform = BuildPlatform{OS: osname, Arch: archname}
+
+		// the same default as go itself, when cross compiling
+		if archname == "arm" {
+			goarm := "7"
+
+			if v, ok := target.Flags["GOARM"]; ok && v != "" {
+				goarm = strings.Split(v, ",")[0]
+			}
+
+			platform.Variant = "v" + goarm
+		}
+
+		return platform, true
+	}
+
+	return platform, ok
+}
+
+// Description is a human readable description of what's being uploaded.
+func (u PlannedUpload) Description() string {
+	if u.Kind == UploadKindChecksum {
+		return fmt.Sprintf("%s of %s", u.SumType, u.Source)
+	}
+
+	return u.Source
+}
+
+// PublishPlan describes everything that signing and publishing a file would do, without actually doing any of it.
+type PublishPlan struct {
+	Source         string
+	Sign           bool
+	SignIdentities []SigningIdentity
+	Publish        bool
+	HasTarget      bool
+	Uploads        []PlannedUpload
+	UsernameSource string
+	PasswordSource string
+}
+
+// UploadMethod describes how a file would be uploaded to the given url.
+func UploadMethod(url string) (method string) {
+	return UploadMethodWithAWS(url, AWSConfig{})
+}
+
+// UploadMethodWithAWS describes how a file would be uploaded to the given url, with the given AWS settings.
+func UploadMethodWithAWS(url string, config AWSConfig) (method string) {
+	publisher, err := GetPublisherWithAWS(url, config)
+	if err != nil {
+		return fmt.Sprintf("unsupported: %s", err)
+	}
+
+	return publisher.Method(url)
+}
+
+// PlanFile figures out what SignBinary and PublishFile would do with the given file.  Nothing is signed or uploaded.
+func (g *Gomason) PlanFile(meta Metadata, filePath string, sign bool, publish bool) (plan PublishPlan, err error) {
+	plan = PublishPlan{
+		Source:  filePath,
+		Sign:    sign,
+		Publish: publish,
+		Uploads: make([]PlannedUpload, 0),
+	}
+
+	if sign {
+		plan.SignIdentities, err = g.SigningIdentities(meta)
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	if !publish {
+		return plan, err
+	}
+
+	target, ok := meta.PublishInfo.TargetsMap[filepath.Base(filePath)]
+	if !ok {
+		plan.UsernameSource, plan.PasswordSource = g.CredentialSources(meta)
+		return plan, err
+	}
+
+	return g.PlanFileForTarget(meta, filePath, target, sign)
+}
+
+// PlanFileForTarget figures out what signing the given file and publishing it to the given target would do.  It's PlanFile for files that aren't listed in the publishing targets, such as the release manifest.
+func (g *Gomason) PlanFileForTarget(meta Metadata, filePath string, target PublishTarget, sign bool) (plan PublishPlan, err error) {
+	plan = PublishPlan{
+		Source:    filePath,
+		Sign:      sign,
+		Publish:   true,
+		HasTarget: true,
+		Uploads:   make([]PlannedUpload, 0),
+	}
+
+	identities, err := g.SigningIdentities(meta)
+	if err != nil {
+		return plan, err
+	}
+
+	if sign {
+		plan.SignIdentities = identities
+	}
+
+	plan.UsernameSource, plan.PasswordSource = g.CredentialSources(meta)
+
+	parsedDestination, err := ParseTemplateForMetadata(target.Destination, meta)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse destination url %s", target.Destination)
+		return plan, err
+	}
+
+	awsConfig := meta.AWSConfigFor(target)
+
+	awsConfig.Tags, err = ParseTagsForMetadata(awsConfig.Tags, meta)
+	if err != nil {
+		return plan, err
+	}
+
+	// the content type is the artifact's.  Signatures and checksums are something else.
+	sidecarAWSConfig := awsConfig
+	sidecarAWSConfig.ContentType = ""
+
+	// bundle publishers push signatures and checksums along with the file, rather than to destinations of their own
+	sidecarMethod := func(dst string) string {
+		return UploadMethodWithAWS(dst, sidecarAWSConfig)
+	}
+
+	if publisher, err := GetPublisherWithAWS(parsedDestination, awsConfig); err == nil {
+		if _, ok := publisher.(BundlePublisher); ok {
+			sidecarMethod = func(dst string) string {
+				return fmt.Sprintf("with %s, in %s", filepath.Base(filePath), parsedDestination)
+			}
+		}
+	}
+
+	artifact := PlannedUpload{
+		Kind:        UploadKindArtifact,
+		Source:      filePath,
+		Destination: parsedDestination,
+		Method:      UploadMethodWithAWS(parsedDestination, awsConfig),
+		AWS:         awsConfig,
+	}
+
+	if platform, ok := meta.BuildPlatformFor(filePath); ok {
+		artifact.Platform = &platform
+	}
+
+	plan.Uploads = append(plan.Uploads, artifact)
+
+	if target.Signature {
+		for _, identity := range identities {
+			suffix := identity.SignatureSuffix()
+
+			dst := parsedDestination + suffix
+			plan.Uploads = append(plan.Uploads, PlannedUpload{
+				Kind:        UploadKindSignature,
+				Source:      filePath + suffix,
+				Destination: dst,
+				Method:      sidecarMethod(dst),
+				AWS:         sidecarAWSConfig,
+			})
+		}
+	}
+
+	if target.Checksums {
+		for _, sumtype := range []string{"md5", "sha1", "sha256"} {
+			dst := fmt.Sprintf("%s.%s", parsedDestination, sumtype)
+			plan.Uploads = append(plan.Uploads, PlannedUpload{
+				Kind:        UploadKindChecksum,
+				Source:      filePath,
+				SumType:     sumtype,
+				Destination: dst,
+				Method:      sidecarMethod(dst),
+				AWS:         sidecarAWSConfig,
+			})
+		}
+	}
+
+	return plan, err
+}
+
+// Print writes a human readable version of the plan to the given writer.
+func (p PublishPlan) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%s\n", filepath.Base(p.Source))
+	_, _ = fmt.Fprintf(w, "  source:      %s\n", p.Source)
+
+	if p.Sign {
+		for _, identity := range p.SignIdentities {
+			desc := identity.String()
+			if identity.Email == "" {
+				desc = fmt.Sprintf("%s as <no signing entity configured - signing with %s may fail>", desc, identity.Program)
+			}
+
+			_, _ = fmt.Fprintf(w, "  sign:        %s\n", desc)
+		}
+	}
+
+	if !p.Publish {
+		return
+	}
+
+	if !p.HasTarget {
+		_, _ = fmt.Fprintf(w, "  publish:     no publishing target for %s, would not be uploaded\n", filepath.Base(p.Source))
+		return
+	}
+
+	for _, u := range p.Uploads {
+		_, _ = fmt.Fprintf(w, "  %-12s %s\n", u.Kind+":", u.Description())
+		_, _ = fmt.Fprintf(w, "    -> %s via %s\n", u.Destination, u.Method)
+	}
+
+	_, _ = fmt.Fprintf(w, "  credentials: username from %s, password from %s\n", p.UsernameSource, p.PasswordSource)
+}
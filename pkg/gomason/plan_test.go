@@ -0,0 +1,194 @@
+package gomason
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanFile(t *testing.T) {
+	g := Gomason{
+		Config: UserConfig{
+			User: UserInfo{
+				Email:        "tester@foo.com",
+				PasswordFunc: "echo 'letmein'",
+			},
+		},
+	}
+
+	inputs := []struct {
+		name      string
+		repo      string
+		file      string
+		sign      bool
+		publish   bool
+		checksums bool
+		expected  PublishPlan
+	}{
+		{
+			"http",
+			"http://localhost:8081/artifactory/generic-local",
+			"/tmp/foo/testproject_linux_amd64",
+			true,
+			true,
+			true,
+			PublishPlan{
+				Source:         "/tmp/foo/testproject_linux_amd64",
+				Sign:           true,
+				SignIdentities: []SigningIdentity{{Program: "gpg", Email: "tester@foo.com"}},
+				Publish:        true,
+				HasTarget:      true,
+				Uploads: []PlannedUpload{
+					{
+						Kind:        UploadKindArtifact,
+						Source:      "/tmp/foo/testproject_linux_amd64",
+						Destination: "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject",
+						Method:      "HTTP PUT",
+						Platform:    &BuildPlatform{OS: "linux", Arch: "amd64"},
+					},
+					{
+						Kind:        UploadKindSignature,
+						Source:      "/tmp/foo/testproject_linux_amd64.asc",
+						Destination: "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject.asc",
+						Method:      "HTTP PUT",
+					},
+					{
+						Kind:        UploadKindChecksum,
+						Source:      "/tmp/foo/testproject_linux_amd64",
+						SumType:     "md5",
+						Destination: "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject.md5",
+						Method:      "HTTP PUT",
+					},
+					{
+						Kind:        UploadKindChecksum,
+						Source:      "/tmp/foo/testproject_linux_amd64",
+						SumType:     "sha1",
+						Destination: "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject.sha1",
+						Method:      "HTTP PUT",
+					},
+					{
+						Kind:        UploadKindChecksum,
+						Source:      "/tmp/foo/testproject_linux_amd64",
+						SumType:     "sha256",
+						Destination: "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject.sha256",
+						Method:      "HTTP PUT",
+					},
+				},
+				UsernameSource: "none",
+				PasswordSource: "passwordfunc in ~/.gomason",
+			},
+		},
+		{
+			"s3 unsigned",
+			"https://foo-tools.s3.us-east-1.amazonaws.com",
+			"/tmp/foo/testproject_linux_amd64",
+			false,
+			true,
+			false,
+			PublishPlan{
+				Source:    "/tmp/foo/testproject_linux_amd64",
+				Publish:   true,
+				HasTarget: true,
+				Uploads: []PlannedUpload{
+					{
+						Kind:        UploadKindArtifact,
+						Source:      "/tmp/foo/testproject_linux_amd64",
+						Destination: "https://foo-tools.s3.us-east-1.amazonaws.com/testproject/0.1.0/linux/amd64/testproject",
+						Method:      "S3 (bucket: foo-tools, region: us-east-1, key: testproject/0.1.0/linux/amd64/testproject)",
+						Platform:    &BuildPlatform{OS: "linux", Arch: "amd64"},
+					},
+					{
+						Kind:        UploadKindSignature,
+						Source:      "/tmp/foo/testproject_linux_amd64.asc",
+						Destination: "https://foo-tools.s3.us-east-1.amazonaws.com/testproject/0.1.0/linux/amd64/testproject.asc",
+						Method:      "S3 (bucket: foo-tools, region: us-east-1, key: testproject/0.1.0/linux/amd64/testproject.asc)",
+					},
+				},
+				UsernameSource: "none",
+				PasswordSource: "passwordfunc in ~/.gomason",
+			},
+		},
+		{
+			"no target",
+			"http://localhost:8081/artifactory/generic-local",
+			"/tmp/foo/testproject_darwin_amd64",
+			false,
+			true,
+			true,
+			PublishPlan{
+				Source:         "/tmp/foo/testproject_darwin_amd64",
+				Publish:        true,
+				Uploads:        []PlannedUpload{},
+				UsernameSource: "none",
+				PasswordSource: "passwordfunc in ~/.gomason",
+			},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := testMetadataObj()
+			meta.Repository = tc.repo
+
+			target := meta.PublishInfo.TargetsMap["testproject_linux_amd64"]
+			target.Checksums = tc.checksums
+			meta.PublishInfo.TargetsMap["testproject_linux_amd64"] = target
+
+			plan, err := g.PlanFile(meta, tc.file, tc.sign, tc.publish)
+			if err != nil {
+				t.Errorf("Error planning: %s", err)
+			}
+
+			assert.Equal(t, tc.expected, plan, "Publish plan meets expectations")
+
+			buf := new(bytes.Buffer)
+			plan.Print(buf)
+
+			for _, u := range plan.Uploads {
+				assert.Contains(t, buf.String(), u.Destination, "Printed plan includes destination")
+			}
+		})
+	}
+}
+
+func TestBuildPlatformFor(t *testing.T) {
+	targets := []BuildTarget{
+		{Name: "linux/amd64"},
+		{Name: "windows/amd64"},
+		{Name: "dragonfly/amd64"},
+		{Name: "linux/arm", Flags: map[string]string{"GOARM": "6"}},
+		{Name: "freebsd/arm"},
+		{Name: "linux/loong64"},
+	}
+
+	inputs := []struct {
+		name     string
+		binaries []Binary
+		file     string
+		platform BuildPlatform
+		ok       bool
+	}{
+		{"linux", nil, "/build/testproject_linux_amd64", BuildPlatform{OS: "linux", Arch: "amd64"}, true},
+		{"windows", nil, "/build/testproject_windows_amd64.exe", BuildPlatform{OS: "windows", Arch: "amd64"}, true},
+		{"dragonfly", nil, "/build/testproject_dragonfly_amd64", BuildPlatform{OS: "dragonfly", Arch: "amd64"}, true},
+		{"arm version from flags", nil, "/build/testproject_linux_arm", BuildPlatform{OS: "linux", Arch: "arm", Variant: "v6"}, true},
+		{"default arm version", nil, "/build/testproject_freebsd_arm", BuildPlatform{OS: "freebsd", Arch: "arm", Variant: "v7"}, true},
+		{"loong64", nil, "/build/testproject_linux_loong64", BuildPlatform{OS: "linux", Arch: "loong64"}, true},
+		{"not a target", nil, "/build/testproject_darwin_arm64", BuildPlatform{}, false},
+		{"not a binary", nil, "/build/testproject.sha256sums", BuildPlatform{}, false},
+		{"named binary", []Binary{{Package: "./cmd/tool", Output: "tool-{{.OS}}-{{.Arch}}{{.Ext}}"}}, "/build/tool-windows-amd64.exe", BuildPlatform{OS: "windows", Arch: "amd64"}, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := testMetadataObj()
+			meta.BuildInfo.Targets = targets
+			meta.BuildInfo.Binaries = tc.binaries
+
+			platform, ok := meta.BuildPlatformFor(tc.file)
+			assert.Equal(t, tc.ok, ok, "Found a platform or not")
+			assert.Equal(t, tc.platform, platform, "Platform meets expectations")
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package gomason
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SignatureSigner is who made a signature, as far as the signing program can tell.
+type SignatureSigner struct {
+	Fingerprint string
+	Identities  []string
+	Expired     bool
+	Revoked     bool
+}
+
+// String describes the signer for humans.
+func (s SignatureSigner) String() string {
+	if len(s.Identities) == 0 {
+		return s.Fingerprint
+	}
+
+	return fmt.Sprintf("%s (%s)", s.Fingerprint, strings.Join(s.Identities, ", "))
+}
+
+// AllowedBy says whether the signer's fingerprint, or one of its identities, is in the allowed list.  Fingerprints can be given in full, or as long key ids, and identities as a full user id, or just its email.
+func (s SignatureSigner) AllowedBy(allowed []string) bool {
+	for _, entry := range allowed {
+		if matchFingerprint(entry, s.Fingerprint) {
+			return true
+		}
+
+		for _, identity := range s.Identities {
+			if matchIdentity(entry, identity) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SignerInspector is implemented by Signers that can verify a signature, and say who made it.  It's needed to enforce 'allowed' in the signing section of the metadata file.
+type SignerInspector interface {
+	Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (SignatureSigner, error)
+}
+
+// SignerNotAllowedError means a file was signed with a key that isn't allowed to sign releases, or that is expired or revoked.
+type SignerNotAllowedError struct {
+	File   string
+	Signer string
+	Reason string
+}
+
+func (e SignerNotAllowedError) Error() string {
+	return fmt.Sprintf("signer not allowed: %s was signed by %s, %s", e.File, e.Signer, e.Reason)
+}
+
+// CheckSigningPolicy verifies each signature on binary, and makes sure it was made by a key listed as 'allowed' in the signing section of the metadata file, and that the key is neither expired nor revoked.  If nothing is listed as allowed, anyone may sign.
+func (g *Gomason) CheckSigningPolicy(meta Metadata, binary string) (err error) {
+	allowed := meta.SignInfo.Allowed
+	if len(allowed) == 0 {
+		return err
+	}
+
+	identities, err := g.SigningIdentities(meta)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		signer, err := GetSigner(identity.Program)
+		if err != nil {
+			return err
+		}
+
+		inspector, ok := signer.(SignerInspector)
+		if !ok {
+			err = errors.New(fmt.Sprintf("can't enforce 'allowed' in %s: signing program %q can't say who made a signature", METADATA_FILENAME, identity.Program))
+			return err
+		}
+
+		sigFile := identity.SignatureFile(binary)
+
+		who, err := inspector.Inspect(identity, meta, binary, sigFile)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to verify %s", sigFile)
+			return err
+		}
+
+		if who.Revoked {
+			err = SignerNotAllowedError{File: binary, Signer: who.String(), Reason: "which is revoked"}
+			return err
+		}
+
+		if who.Expired {
+			err = SignerNotAllowedError{File: binary, Signer: who.String(), Reason: "which is expired"}
+			return err
+		}
+
+		if !who.AllowedBy(allowed) {
+			err = SignerNotAllowedError{File: binary, Signer: who.String(), Reason: fmt.Sprintf("which is not allowed in the signing section of %s", METADATA_FILENAME)}
+			return err
+		}
+
+		logrus.Debugf("%s was signed by %s, which is allowed", binary, who)
+	}
+
+	return err
+}
+
+// matchFingerprint says whether an allowed entry is the given fingerprint.  Hex fingerprints are matched regardless of case and spacing, and long key ids match the end of the fingerprint.  Others, such as ssh's 'SHA256:...' fingerprints, must match exactly.
+func matchFingerprint(entry string, fingerprint string) bool {
+	if fingerprint == "" {
+		return false
+	}
+
+	if entry == fingerprint {
+		return true
+	}
+
+	e := normalizeHexFingerprint(entry)
+	f := normalizeHexFingerprint(fingerprint)
+
+	if e == "" || f == "" {
+		return false
+	}
+
+	if e == f {
+		return true
+	}
+
+	// a long key id is the last 16 hex digits of the fingerprint
+	return len(e) >= 16 && strings.HasSuffix(f, e)
+}
+
+// normalizeHexFingerprint upper cases a hex fingerprint, and removes spaces and any '0x' prefix.  Returns "" if it isn't hex.
+func normalizeHexFingerprint(fingerprint string) string {
+	f := strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	f = strings.TrimPrefix(f, "0X")
+
+	for _, c := range f {
+		if !strings.ContainsRune("0123456789ABCDEF", c) {
+			return ""
+		}
+	}
+
+	return f
+}
+
+// matchIdentity says whether an allowed entry is the given identity, either exactly, or as the email in a user id like 'Nik Ogura <nik@example.com>'.
+func matchIdentity(entry string, identity string) bool {
+	if entry == identity {
+		return true
+	}
+
+	email := identity
+
+	open := strings.LastIndex(identity, "<")
+	closing := strings.LastIndex(identity, ">")
+
+	if open >= 0 && closing > open {
+		email = identity[open+1 : closing]
+	}
+
+	return strings.Contains(email, "@") && strings.EqualFold(entry, email)
+}
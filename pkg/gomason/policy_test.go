@@ -0,0 +1,311 @@
+package gomason
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestRevokedKeyring writes the public keyring for the key in keyFile, with the key revoked, to keyring.
+func writeTestRevokedKeyring(t *testing.T, keyFile string, passphrase string, keyring string) {
+	entities, err := ReadArmoredKeyRing(keyFile)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", keyFile, err)
+	}
+
+	entity := entities[0]
+
+	err = entity.DecryptPrivateKeys([]byte(passphrase))
+	if err != nil {
+		t.Fatalf("Error decrypting key: %s", err)
+	}
+
+	err = entity.RevokeKey(packet.KeyCompromised, "left on a laptop", nil)
+	if err != nil {
+		t.Fatalf("Error revoking key: %s", err)
+	}
+
+	f, err := os.Create(keyring)
+	if err != nil {
+		t.Fatalf("Error creating %s: %s", keyring, err)
+	}
+	defer f.Close()
+
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Error encoding public key: %s", err)
+	}
+
+	err = entity.Serialize(w)
+	if err != nil {
+		t.Fatalf("Error serializing public key: %s", err)
+	}
+
+	_ = w.Close()
+}
+
+func TestSignatureSignerAllowedBy(t *testing.T) {
+	gpgSigner := SignatureSigner{
+		Fingerprint: "D380BB9AD06B96D0D545E6C9188ABBB17803DE7C",
+		Identities:  []string{"Gomason Tester <tester@foo.com>"},
+	}
+
+	sshSigner := SignatureSigner{
+		Fingerprint: "SHA256:Qy5vGh0mFZ6kGm1qJ3X0b1LqWm7Lq4Zl1m3pT8oQm3c",
+	}
+
+	inputs := []struct {
+		name    string
+		signer  SignatureSigner
+		allowed []string
+		result  bool
+	}{
+		{"fingerprint", gpgSigner, []string{"D380BB9AD06B96D0D545E6C9188ABBB17803DE7C"}, true},
+		{"spaced lower case fingerprint", gpgSigner, []string{"d380 bb9a d06b 96d0 d545  e6c9 188a bbb1 7803 de7c"}, true},
+		{"long key id", gpgSigner, []string{"0x188ABBB17803DE7C"}, true},
+		{"short key id", gpgSigner, []string{"7803DE7C"}, false},
+		{"email", gpgSigner, []string{"Tester@Foo.com"}, true},
+		{"user id", gpgSigner, []string{"Gomason Tester <tester@foo.com>"}, true},
+		{"someone else", gpgSigner, []string{"other@foo.com", "0000000000000000000000000000000000000000"}, false},
+		{"ssh fingerprint", sshSigner, []string{"SHA256:Qy5vGh0mFZ6kGm1qJ3X0b1LqWm7Lq4Zl1m3pT8oQm3c"}, true},
+		{"ssh fingerprint wrong case", sshSigner, []string{"SHA256:qy5vgh0mfz6kgm1qj3x0b1lqwm7lq4zl1m3pt8oqm3c"}, false},
+		{"nothing allowed", gpgSigner, []string{}, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, tc.signer.AllowedBy(tc.allowed), "Allowed meets expectations")
+		})
+	}
+}
+
+func TestParseGPGSignerStatus(t *testing.T) {
+	inputs := []struct {
+		name     string
+		status   string
+		expected SignatureSigner
+	}{
+		{
+			"good",
+			"[GNUPG:] NEWSIG t@foo.com\n[GNUPG:] GOODSIG 188ABBB17803DE7C Tester <t@foo.com>\n[GNUPG:] VALIDSIG 9A0D3E1B8C6F4A2D5E7B9C1F3A5D7E9B1C3F5A7D 2026-10-17 1792223118 0 4 0 22 8 00 D380BB9AD06B96D0D545E6C9188ABBB17803DE7C\n[GNUPG:] TRUST_ULTIMATE 0 pgp\n",
+			SignatureSigner{Fingerprint: "D380BB9AD06B96D0D545E6C9188ABBB17803DE7C", Identities: []string{"Tester <t@foo.com>"}},
+		},
+		{
+			"expired",
+			"[GNUPG:] NEWSIG o@foo.com\n[GNUPG:] KEYEXPIRED 1792223124\n[GNUPG:] EXPKEYSIG 3F2A6E2DEEC2A1B6 Old <o@foo.com>\n[GNUPG:] VALIDSIG 49A147BD203C12F385CDB7AB3F2A6E2DEEC2A1B6 2026-10-17 1792223122 0 4 0 22 8 00 49A147BD203C12F385CDB7AB3F2A6E2DEEC2A1B6\n[GNUPG:] TRUST_FULLY 0 pgp\n",
+			SignatureSigner{Fingerprint: "49A147BD203C12F385CDB7AB3F2A6E2DEEC2A1B6", Identities: []string{"Old <o@foo.com>"}, Expired: true},
+		},
+		{
+			"revoked",
+			"[GNUPG:] NEWSIG t@foo.com\n[GNUPG:] REVKEYSIG 188ABBB17803DE7C Tester <t@foo.com>\n[GNUPG:] VALIDSIG D380BB9AD06B96D0D545E6C9188ABBB17803DE7C 2026-10-17 1792223118 0 4 0 22 8 00 D380BB9AD06B96D0D545E6C9188ABBB17803DE7C\n[GNUPG:] KEYREVOKED\n",
+			SignatureSigner{Fingerprint: "D380BB9AD06B96D0D545E6C9188ABBB17803DE7C", Identities: []string{}, Revoked: true},
+		},
+		{
+			"untrusted",
+			"[GNUPG:] NEWSIG t@foo.com\n[GNUPG:] GOODSIG 188ABBB17803DE7C Tester <t@foo.com>\n[GNUPG:] VALIDSIG 9A0D3E1B8C6F4A2D5E7B9C1F3A5D7E9B1C3F5A7D 2026-10-17 1792223118 0 4 0 22 8 00 D380BB9AD06B96D0D545E6C9188ABBB17803DE7C\n[GNUPG:] TRUST_UNDEFINED 0 pgp\n",
+			SignatureSigner{Fingerprint: "D380BB9AD06B96D0D545E6C9188ABBB17803DE7C", Identities: []string{}},
+		},
+		{
+			"trusted, by older gpg",
+			"[GNUPG:] GOODSIG 188ABBB17803DE7C Tester <t@foo.com>\n[GNUPG:] VALIDSIG D380BB9AD06B96D0D545E6C9188ABBB17803DE7C 2026-10-17 1792223118 0 4 0 22 8 00 D380BB9AD06B96D0D545E6C9188ABBB17803DE7C\n[GNUPG:] TRUST_ULTIMATE\n",
+			SignatureSigner{Fingerprint: "D380BB9AD06B96D0D545E6C9188ABBB17803DE7C", Identities: []string{"Tester <t@foo.com>"}},
+		},
+		{
+			"bad signature",
+			"[GNUPG:] NEWSIG t@x.com\n[GNUPG:] BADSIG 3AFD0D5E8DC71BED t@x.com\n",
+			SignatureSigner{Identities: []string{}},
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ParseGPGSignerStatus(tc.status), "Signer meets expectations")
+		})
+	}
+
+	listing := "tru::1:1792223118:1792309518:3:1:5\npub:u:255:22:188ABBB17803DE7C:1792223118:1792309518::u:::scSC:::::ed25519:::0:\nfpr:::::::::D380BB9AD06B96D0D545E6C9188ABBB17803DE7C:\nuid:u::::1792223118::BE783927F347278E28375D4AF951EFE559C88526::Tester <t@foo.com>::::::::::0:\nuid:r::::1792223118::CE783927F347278E28375D4AF951EFE559C88526::Old Job <t@oldjob.com>::::::::::0:\nuid:u::::1792223118::DE783927F347278E28375D4AF951EFE559C88526::Tester (work\\x3a releases) <t@work.com>::::::::::0:\n"
+
+	assert.Equal(t, []string{"Tester <t@foo.com>", "Tester (work: releases) <t@work.com>"}, ParseGPGUserIDs(listing), "Revoked user ids are left out")
+}
+
+func TestCheckSigningPolicy(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pluginName := "policytest"
+
+	cleanup := installTestSignerPlugin(t, dir, pluginName)
+	defer cleanup()
+
+	email := "gomason-tester@foo.com"
+	keyFile, keyring := writeTestOpenPGPKeys(t, dir, email, "sekrit")
+
+	revokedKeyring := filepath.Join(dir, "revoked.asc")
+	writeTestRevokedKeyring(t, keyFile, "sekrit", revokedKeyring)
+
+	entities, err := ReadArmoredKeyRing(keyring)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", keyring, err)
+	}
+
+	fingerprint := normalizeHexFingerprint(entities[0].PrimaryKey.KeyIdString())
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	inputs := []struct {
+		name     string
+		signInfo SignInfo
+		failure  error
+		errs     bool
+	}{
+		{"nothing allowed, anyone may sign", SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring}, nil, false},
+		{"allowed by key id", SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring, Allowed: []string{fingerprint}}, nil, false},
+		{"allowed by email", SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring, Allowed: []string{email}}, nil, false},
+		{"no keyring, only the signer's own key file", SignInfo{Program: SigningProgramOpenPGP, Email: email, Allowed: []string{email}}, nil, true},
+		{"not allowed", SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring, Allowed: []string{"someone-else@foo.com"}}, SignerNotAllowedError{}, true},
+		{"revoked", SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: revokedKeyring, Allowed: []string{email}}, SignerNotAllowedError{}, true},
+		{"plugin allowed", SignInfo{Program: pluginName, Email: "tester@foo.com", Allowed: []string{"tester@foo.com"}}, nil, false},
+		{"plugin expired", SignInfo{Program: pluginName, Email: "stale@foo.com", Allowed: []string{"stale@foo.com"}}, SignerNotAllowedError{}, true},
+		{"plugin unknown signer", SignInfo{Program: pluginName, Email: "untrusted@foo.com", Allowed: []string{"untrusted@foo.com"}}, UnknownSignerError{}, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := os.WriteFile(binary, []byte(testFileContent()), 0755)
+			if err != nil {
+				t.Fatalf("Error writing %s: %s", binary, err)
+			}
+
+			meta := testMetadataObj()
+			meta.SignInfo = tc.signInfo
+
+			g := Gomason{Config: UserConfig{Signing: UserSignInfo{KeyFile: keyFile, PassphraseFunc: "echo sekrit"}}}
+
+			err = g.SignBinary(meta, binary)
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			err = g.CheckSigningPolicy(meta, binary)
+			if tc.errs {
+				assert.NotNil(t, err, "Signer is refused")
+				assert.IsType(t, tc.failure, VerificationFailure(err), "Failure is reported as what it is")
+				return
+			}
+
+			assert.Nil(t, err, "Signer is allowed")
+		})
+	}
+
+	// a file signed by someone who isn't allowed doesn't get published
+	meta := testMetadataObj()
+	meta.SignInfo = SignInfo{Program: SigningProgramOpenPGP, Email: email, Keyring: keyring, Allowed: []string{"someone-else@foo.com"}}
+
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{KeyFile: keyFile, PassphraseFunc: "echo sekrit"}}}
+
+	result := g.HandleFile(meta, binary, dir, true, true, false)
+	assert.IsType(t, SignerNotAllowedError{}, VerificationFailure(result.Err), "Publishing is refused")
+	assert.Empty(t, result.Uploads, "Nothing was uploaded")
+
+	// nor does one that was signed already, when publishing without signing
+	result = g.HandleFile(meta, binary, dir, false, true, false)
+	assert.IsType(t, SignerNotAllowedError{}, VerificationFailure(result.Err), "Publishing signatures that were already there is refused")
+	assert.Empty(t, result.Uploads, "Nothing was uploaded")
+}
+
+func TestCheckSigningPolicyGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	home, cleanup := testGPGHome(t)
+	defer cleanup()
+
+	// gpg ultimately trusts the key, as it does any key its user makes, like a stale one on someone's laptop
+	email := "releases@foo.com"
+	fingerprint := generateTestGPGKey(t, home, email, "sekrit")
+
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := filepath.Join(dir, "pubring.gpg")
+
+	out, err := exec.Command("gpg", "--batch", "--export", "--output", keyring, email).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error exporting gpg key: %s: %s", err, out)
+	}
+
+	// the team's trustdb, which vouches for the key
+	trustdb := filepath.Join(dir, "trustdb.gpg")
+
+	cmd := exec.Command("gpg", "--batch", "--trustdb", trustdb, "--import-ownertrust")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s:6:\n", fingerprint))
+
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error importing ownertrust: %s: %s", err, out)
+	}
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	inputs := []struct {
+		name     string
+		signInfo SignInfo
+		errs     bool
+	}{
+		{"self trusted key with an allowed email", SignInfo{Program: SigningProgramGPG, Email: email, Allowed: []string{email}}, true},
+		{"keyring, but the user's trustdb", SignInfo{Program: SigningProgramGPG, Email: email, Keyring: keyring, Trustdb: filepath.Join(home, "trustdb.gpg"), Allowed: []string{email}}, true},
+		{"keyring, but no trustdb", SignInfo{Program: SigningProgramGPG, Email: email, Keyring: keyring, Allowed: []string{email}}, true},
+		{"keyring and trustdb", SignInfo{Program: SigningProgramGPG, Email: email, Keyring: keyring, Trustdb: trustdb, Allowed: []string{email}}, false},
+		{"allowed by fingerprint", SignInfo{Program: SigningProgramGPG, Email: email, Allowed: []string{fingerprint}}, false},
+	}
+
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{PassphraseFunc: "echo sekrit"}}}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := os.WriteFile(binary, []byte(testFileContent()), 0755)
+			if err != nil {
+				t.Fatalf("Error writing %s: %s", binary, err)
+			}
+
+			meta := testMetadataObj()
+			meta.SignInfo = tc.signInfo
+
+			err = g.SignBinary(meta, binary)
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			err = g.CheckSigningPolicy(meta, binary)
+			if tc.errs {
+				assert.IsType(t, SignerNotAllowedError{}, VerificationFailure(err), "Signer is refused")
+				return
+			}
+
+			assert.Nil(t, err, "Signer is allowed")
+		})
+	}
+
+	// the self trusted key doesn't get to publish as the allowed email
+	meta := testMetadataObj()
+	meta.SignInfo = SignInfo{Program: SigningProgramGPG, Email: email, Allowed: []string{email}}
+
+	result := g.HandleFile(meta, binary, dir, true, true, false)
+	assert.IsType(t, SignerNotAllowedError{}, VerificationFailure(result.Err), "Publishing is refused")
+	assert.Empty(t, result.Uploads, "Nothing was uploaded")
+}
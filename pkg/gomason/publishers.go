@@ -0,0 +1,117 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Publisher stores files at, and fetches them from, destination urls.  Which Publisher handles a url is decided by its host or scheme, so new kinds of repository can be published to without changing Upload.
+//
+// Username and password are the publishing credentials from the metadata file or ~/.gomason.  Publishers that get their credentials some other way, such as S3, ignore them.
+type Publisher interface {
+	Put(url string, data io.Reader, checksums Checksums, username string, password string) error
+	Get(url string, out io.Writer, username string, password string) error
+	Head(url string, username string, password string) (bool, error)
+	Delete(url string, username string, password string) error
+	List(url string, username string, password string) ([]string, error)
+	Method(url string) string
+}
+
+// BundlePublisher is a Publisher that publishes a file, its signatures and its checksums all together, rather than one at a time.  The first upload is always the file itself.  PublishPlanned hands whole plans to BundlePublishers.
+type BundlePublisher interface {
+	Publisher
+	PutBundle(meta Metadata, uploads []PlannedUpload, username string, password string) error
+}
+
+// BundleGetter is a Publisher that fetches what a BundlePublisher published all together: a file, along with its signatures and checksums.  outs are keyed by the suffix each is published with, e.g. '.asc' or '.sha256', and "" for the file itself.  platform is the build target the file was built for, if it's a binary.
+type BundleGetter interface {
+	Publisher
+	GetBundle(url string, platform *BuildPlatform, outs map[string]io.Writer, username string, password string) error
+}
+
+// Checksums are the checksums of a file being published.  Some repositories, such as Artifactory, want them sent along with it.
+type Checksums struct {
+	MD5    string
+	SHA1   string
+	SHA256 string
+}
+
+// ChecksumsForFile returns the Checksums of a file.
+func ChecksumsForFile(filename string) (checksums Checksums, err error) {
+	checksums.MD5, checksums.SHA1, checksums.SHA256, err = AllChecksumsForFile(filename)
+
+	return checksums, err
+}
+
+// ChecksumsForBytes returns the Checksums of some bytes.
+func ChecksumsForBytes(input []byte) (checksums Checksums, err error) {
+	checksums.MD5, checksums.SHA1, checksums.SHA256, err = AllChecksumsForBytes(input)
+
+	return checksums, err
+}
+
+// hostPublisher is a Publisher for urls whose host matches a pattern.
+type hostPublisher struct {
+	pattern   *regexp.Regexp
+	publisher Publisher
+}
+
+var publishersByScheme map[string]Publisher = map[string]Publisher{}
+var publishersByHost []hostPublisher = []hostPublisher{}
+var publishersMutex sync.Mutex
+
+// RegisterPublisher makes a Publisher handle destinations with the given url scheme, e.g. 'https'.
+func RegisterPublisher(scheme string, publisher Publisher) {
+	publishersMutex.Lock()
+	defer publishersMutex.Unlock()
+
+	publishersByScheme[strings.ToLower(scheme)] = publisher
+}
+
+// RegisterHostPublisher makes a Publisher handle destinations whose host matches the given regular expression, whatever their scheme.  Hosts are checked before schemes, and the most recently registered pattern that matches wins.
+func RegisterHostPublisher(pattern string, publisher Publisher) (err error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid host pattern %q", pattern)
+		return err
+	}
+
+	publishersMutex.Lock()
+	defer publishersMutex.Unlock()
+
+	publishersByHost = append([]hostPublisher{{pattern: re, publisher: publisher}}, publishersByHost...)
+
+	return err
+}
+
+// GetPublisher returns the Publisher for the given destination url.
+func GetPublisher(destination string) (publisher Publisher, err error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", destination)
+		return publisher, err
+	}
+
+	publishersMutex.Lock()
+	defer publishersMutex.Unlock()
+
+	for _, hp := range publishersByHost {
+		if hp.pattern.MatchString(u.Host) {
+			return hp.publisher, err
+		}
+	}
+
+	publisher, ok := publishersByScheme[strings.ToLower(u.Scheme)]
+	if !ok {
+		err = errors.New(fmt.Sprintf("don't know how to publish to %s.  No publisher handles %q urls", destination, u.Scheme))
+		return publisher, err
+	}
+
+	return publisher, err
+}
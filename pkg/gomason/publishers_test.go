@@ -0,0 +1,514 @@
+package gomason
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// testObjectStore is an in memory stand in for a repository, holding whatever's PUT to it by path.
+type testObjectStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+}
+
+func newTestObjectStore() *testObjectStore {
+	return &testObjectStore{
+		objects: make(map[string][]byte),
+		headers: make(map[string]http.Header),
+	}
+}
+
+// keysUnder returns the paths of the objects starting with prefix, in order.
+func (s *testObjectStore) keysUnder(prefix string) (keys []string) {
+	keys = make([]string, 0)
+
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// testHTTPRepository serves a testObjectStore like a generic HTTP repository, with index pages for directories.
+func testHTTPRepository(store *testObjectStore) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+
+		if user, pass, ok := r.BasicAuth(); ok && (user != "tester" || pass != "sekrit") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			store.objects[r.URL.Path] = body
+			store.headers[r.URL.Path] = r.Header
+			w.WriteHeader(http.StatusCreated)
+		case "GET", "HEAD":
+			if strings.HasSuffix(r.URL.Path, "/") {
+				index := `<html><body><a href="../">../</a> <a href="?C=N;O=D">Name</a>`
+				for _, k := range store.keysUnder(r.URL.Path) {
+					index += fmt.Sprintf(`<a href="%s">%s</a>`, strings.TrimPrefix(k, r.URL.Path), k)
+				}
+				_, _ = w.Write([]byte(index + `<a href="https://elsewhere.com/">elsewhere</a></body></html>`))
+				return
+			}
+
+			body, ok := store.objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			_, _ = w.Write(body)
+		case "DELETE":
+			delete(store.objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+// testS3Repository serves a testObjectStore like S3 does with path style requests, enough for the S3 api calls gomason makes.  Objects are stored as '/<bucket>/<key>'.
+func testS3Repository(store *testObjectStore) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		bucket := parts[0]
+
+		if len(parts) == 1 || parts[1] == "" {
+			// ListObjectsV2
+			prefix := r.URL.Query().Get("prefix")
+			keys := store.keysUnder("/" + bucket + "/" + prefix)
+
+			contents := ""
+			for _, k := range keys {
+				contents += fmt.Sprintf("<Contents><Key>%s</Key><Size>%d</Size></Contents>", strings.TrimPrefix(k, "/"+bucket+"/"), len(store.objects[k]))
+			}
+
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>%s</ListBucketResult>`, bucket, prefix, len(keys), contents)))
+			return
+		}
+
+		switch r.Method {
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			store.objects[r.URL.Path] = body
+			store.headers[r.URL.Path] = r.Header
+		case "GET", "HEAD":
+			body, ok := store.objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+				return
+			}
+
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+
+			if r.Method == "GET" {
+				_, _ = w.Write(body)
+			}
+		case "DELETE":
+			delete(store.objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+// testS3Session makes AWS sessions with test credentials, rather than whatever's in the environment.  Point S3Publishers using it at a stand in S3 with their endpoint setting.
+func testS3Session(config AWSConfig) (*session.Session, error) {
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewStaticCredentials("AKIDTEST", "sekrit", ""),
+	})
+}
+
+// testPublisher is a Publisher that only differs from HTTPPublisher by name, for checking the registry.
+type testPublisher struct {
+	HTTPPublisher
+	name string
+}
+
+func (p testPublisher) Method(url string) string {
+	return p.name
+}
+
+func TestGetPublisher(t *testing.T) {
+	RegisterPublisher("memory", testPublisher{name: "memory"})
+
+	err := RegisterHostPublisher(`^artifacts\.example\.com$`, testPublisher{name: "artifacts"})
+	if err != nil {
+		t.Fatalf("Error registering publisher: %s", err)
+	}
+
+	err = RegisterHostPublisher(`(`, testPublisher{name: "broken"})
+	assert.NotNil(t, err, "Bad host pattern is refused")
+
+	inputs := []struct {
+		name   string
+		url    string
+		method string
+		errs   bool
+	}{
+		{"http", "http://localhost:8081/artifactory/generic-local/foo", "HTTP PUT", false},
+		{"https", "HTTPS://repo.example.com/foo", "HTTP PUT", false},
+		{"s3", "https://foo.s3.us-east-1.amazonaws.com/bar/baz", "S3 (bucket: foo, region: us-east-1, key: bar/baz)", false},
+		{"s3 path style", "https://s3.us-west-2.amazonaws.com/foo/bar/baz", "S3 (bucket: foo, region: us-west-2, key: bar/baz)", false},
+		{"s3 scheme", "s3://foo/bar/baz", "S3 (bucket: foo, region: , key: bar/baz)", false},
+		{"file", "file:///srv/repo/foo", "local file /srv/repo/foo", false},
+		{"registered scheme", "memory://foo/bar", "memory", false},
+		{"registered host", "https://artifacts.example.com/foo", "artifacts", false},
+		{"unknown scheme", "gopher://foo/bar", "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			publisher, err := GetPublisher(tc.url)
+			if tc.errs {
+				assert.NotNil(t, err, "No publisher")
+				assert.Contains(t, UploadMethod(tc.url), "unsupported", "Plan says it can't be published")
+				return
+			}
+
+			assert.Nil(t, err, "No error getting publisher")
+			assert.Equal(t, tc.method, publisher.Method(tc.url), "Publisher meets expectations")
+			assert.Equal(t, tc.method, UploadMethod(tc.url), "Plan describes publisher")
+		})
+	}
+}
+
+func TestPublishers(t *testing.T) {
+	httpStore := newTestObjectStore()
+	httpServer := testHTTPRepository(httpStore)
+	defer httpServer.Close()
+
+	s3Store := newTestObjectStore()
+	s3Server := testS3Repository(s3Store)
+	defer s3Server.Close()
+
+	s3Base := "https://testbucket.s3.us-east-1.amazonaws.com"
+	s3Publisher := S3Publisher{NewSession: testS3Session}.WithAWSConfig(AWSConfig{Endpoint: s3Server.URL, PathStyle: true})
+
+	inputs := []struct {
+		name      string
+		publisher Publisher
+		base      string
+		store     *testObjectStore
+		stored    func(url string) string
+	}{
+		{
+			"http",
+			HTTPPublisher{},
+			httpServer.URL + "/repo",
+			httpStore,
+			func(url string) string { return strings.TrimPrefix(url, httpServer.URL) },
+		},
+		{
+			"s3",
+			s3Publisher,
+			s3Base + "/repo",
+			s3Store,
+			func(url string) string { return "/testbucket" + strings.TrimPrefix(url, s3Base) },
+		},
+		{
+			"s3 scheme",
+			s3Publisher,
+			"s3://otherbucket/repo",
+			s3Store,
+			func(url string) string { return "/otherbucket" + strings.TrimPrefix(url, "s3://otherbucket") },
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			file := tc.base + "/testproject/0.1.0/linux/amd64/testproject"
+			sig := file + ".asc"
+
+			checksums, err := ChecksumsForBytes([]byte(testFileContent()))
+			if err != nil {
+				t.Fatalf("Error getting checksums: %s", err)
+			}
+
+			exists, err := tc.publisher.Head(file, "tester", "sekrit")
+			assert.Nil(t, err, "No error checking for missing file")
+			assert.False(t, exists, "File isn't there yet")
+
+			for _, u := range []string{file, sig} {
+				err = tc.publisher.Put(u, strings.NewReader(testFileContent()), checksums, "tester", "sekrit")
+				if err != nil {
+					t.Fatalf("Error putting %s: %s", u, err)
+				}
+			}
+
+			assert.Equal(t, testFileContent(), string(tc.store.objects[tc.stored(file)]), "File was stored")
+
+			exists, err = tc.publisher.Head(file, "tester", "sekrit")
+			assert.Nil(t, err, "No error checking for file")
+			assert.True(t, exists, "File is there")
+
+			var out bytes.Buffer
+
+			err = tc.publisher.Get(file, &out, "tester", "sekrit")
+			assert.Nil(t, err, "No error getting file")
+			assert.Equal(t, testFileContent(), out.String(), "Got what was put")
+
+			urls, err := tc.publisher.List(tc.base+"/testproject/0.1.0/linux/amd64", "tester", "sekrit")
+			assert.Nil(t, err, "No error listing")
+			assert.Equal(t, []string{file, sig}, urls, "Listing has what was put")
+
+			err = tc.publisher.Delete(sig, "tester", "sekrit")
+			assert.Nil(t, err, "No error deleting")
+
+			exists, err = tc.publisher.Head(sig, "tester", "sekrit")
+			assert.Nil(t, err, "No error checking for deleted file")
+			assert.False(t, exists, "Deleted file is gone")
+
+			err = tc.publisher.Delete(sig, "tester", "sekrit")
+			assert.Nil(t, err, "Deleting something that's not there is fine")
+		})
+	}
+
+	// the http publisher sends credentials and checksums along
+	assert.Equal(t, testFileContent(), string(httpStore.objects["/repo/testproject/0.1.0/linux/amd64/testproject"]), "File was stored")
+
+	sha256sum, _ := BytesSha256([]byte(testFileContent()))
+	assert.Equal(t, sha256sum, httpStore.headers["/repo/testproject/0.1.0/linux/amd64/testproject"].Get("X-Checksum-Sha256"), "Checksum header was sent")
+
+	err := HTTPPublisher{}.Put(httpServer.URL+"/repo/denied", strings.NewReader("nope"), Checksums{}, "tester", "wrong")
+	assert.Equal(t, HTTPStatusError{URL: httpServer.URL + "/repo/denied", StatusCode: http.StatusUnauthorized}, err, "Bad credentials are refused")
+
+	// the s3 publisher makes the 'folders' above what it puts
+	assert.Contains(t, s3Store.objects, "/testbucket/repo/testproject/0.1.0/linux/amd64/", "Folder objects were made")
+}
+
+func TestNewAWSSession(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "config")
+
+	err = os.WriteFile(configFile, []byte("[profile onprem]\nregion = ca-central-1\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", configFile, err)
+	}
+
+	credentialsFile := filepath.Join(tmpDir, "credentials")
+
+	err = os.WriteFile(credentialsFile, []byte("[onprem]\naws_access_key_id = AKIDTEST\naws_secret_access_key = sekrit\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", credentialsFile, err)
+	}
+
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv(AWS_ID_ENV_VAR, "")
+	t.Setenv(AWS_SECRET_ENV_VAR, "")
+	t.Setenv(AWS_REGION_ENV_VAR, "")
+	t.Setenv("AWS_REGION", "")
+
+	inputs := []struct {
+		name      string
+		config    AWSConfig
+		envRegion string
+		region    string
+		errs      bool
+	}{
+		{"region from profile", AWSConfig{Profile: "onprem"}, "", "ca-central-1", false},
+		{"environment overrides profile", AWSConfig{Profile: "onprem"}, "us-west-2", "us-west-2", false},
+		{"region overrides profile", AWSConfig{Profile: "onprem", Region: "eu-west-1"}, "", "eu-west-1", false},
+		{"region from environment", AWSConfig{}, "us-west-2", "us-west-2", false},
+		{"endpoint without region", AWSConfig{Endpoint: "https://minio.example.com"}, "", "us-east-1", false},
+		{"assume role", AWSConfig{Profile: "onprem", RoleARN: "arn:aws:iam::123456789012:role/publisher"}, "", "ca-central-1", false},
+		{"missing profile", AWSConfig{Profile: "nonesuch"}, "", "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(AWS_REGION_ENV_VAR, tc.envRegion)
+
+			sess, err := NewAWSSession(tc.config)
+			if tc.errs {
+				assert.NotNil(t, err, "Session error is returned")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error creating session: %s", err)
+			}
+
+			assert.Equal(t, tc.region, aws.StringValue(sess.Config.Region), "Region meets expectations")
+		})
+	}
+}
+
+func TestAWSConfigFor(t *testing.T) {
+	meta := testMetadataObj()
+	meta.Repository = "s3://releases"
+	meta.PublishInfo.AWS = AWSConfig{
+		Endpoint:  "https://minio.example.com:9000",
+		PathStyle: true,
+		Profile:   "onprem",
+	}
+
+	meta.PublishInfo.AWS.Tags = map[string]string{"project": "testproject", "version": "unknown"}
+	meta.Commit = "abc123"
+
+	target := meta.PublishInfo.TargetsMap["testproject_linux_amd64"]
+	target.AWS = AWSConfig{
+		Profile:     "releaser",
+		Region:      "us-west-2",
+		ContentType: "application/octet-stream",
+		Tags:        map[string]string{"version": "{{.Version}}", "commit": "{{.Commit}}"},
+	}
+
+	expected := AWSConfig{
+		Endpoint:    "https://minio.example.com:9000",
+		PathStyle:   true,
+		Profile:     "releaser",
+		Region:      "us-west-2",
+		ContentType: "application/octet-stream",
+		Tags:        map[string]string{"project": "testproject", "version": "{{.Version}}", "commit": "{{.Commit}}"},
+	}
+
+	assert.Equal(t, expected, meta.AWSConfigFor(target), "Target settings override the repository's")
+	assert.Equal(t, map[string]string{"project": "testproject", "version": "unknown"}, meta.PublishInfo.AWS.Tags, "Repository tags are left alone")
+
+	expected.Tags = map[string]string{"project": "testproject", "version": "0.1.0", "commit": "abc123"}
+
+	g := Gomason{}
+
+	plan, err := g.PlanFileForTarget(meta, "testproject_linux_amd64", target, false)
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+
+	assert.Equal(t, expected, plan.Uploads[0].AWS, "Upload of the artifact has the target's AWS settings")
+
+	// signatures and checksums aren't the artifact's content type
+	expected.ContentType = ""
+
+	for _, upload := range plan.Uploads[1:] {
+		assert.Equal(t, expected, upload.AWS, "Upload of %s has the target's AWS settings", upload.Destination)
+	}
+
+	assert.Equal(t, "S3 (bucket: releases, region: us-west-2, key: testproject/0.1.0/linux/amd64/testproject, endpoint: https://minio.example.com:9000, profile: releaser)", plan.Uploads[0].Method, "Plan describes the AWS settings")
+}
+
+func TestS3ObjectOptions(t *testing.T) {
+	store := newTestObjectStore()
+	server := testS3Repository(store)
+	defer server.Close()
+
+	inputs := []struct {
+		name    string
+		config  AWSConfig
+		headers map[string]string
+		folders bool
+	}{
+		{
+			"defaults",
+			AWSConfig{},
+			map[string]string{
+				"X-Amz-Server-Side-Encryption": "",
+				"X-Amz-Storage-Class":          "",
+				"X-Amz-Tagging":                "",
+			},
+			true,
+		},
+		{
+			"kms key",
+			AWSConfig{SSEKMSKeyID: "alias/releases"},
+			map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/releases",
+			},
+			true,
+		},
+		{
+			"everything",
+			AWSConfig{
+				ServerSideEncryption: "AES256",
+				StorageClass:         "STANDARD_IA",
+				ACL:                  "bucket-owner-full-control",
+				ContentType:          "application/octet-stream",
+				CacheControl:         "max-age=31536000",
+				Tags:                 map[string]string{"version": "0.1.0", "commit": "abc123"},
+				SkipFolders:          true,
+			},
+			map[string]string{
+				"X-Amz-Server-Side-Encryption": "AES256",
+				"X-Amz-Storage-Class":          "STANDARD_IA",
+				"X-Amz-Acl":                    "bucket-owner-full-control",
+				"Content-Type":                 "application/octet-stream",
+				"Cache-Control":                "max-age=31536000",
+				"X-Amz-Tagging":                "commit=abc123&version=0.1.0",
+			},
+			false,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			config := tc.config
+			config.Endpoint = server.URL
+			config.PathStyle = true
+
+			bucket := strings.Replace(tc.name, " ", "-", -1)
+
+			publisher := S3Publisher{NewSession: testS3Session}.WithAWSConfig(config)
+
+			err := publisher.Put(fmt.Sprintf("s3://%s/repo/testproject/0.1.0/testproject", bucket), strings.NewReader(testFileContent()), Checksums{}, "", "")
+			if err != nil {
+				t.Fatalf("Error putting: %s", err)
+			}
+
+			headers := store.headers[fmt.Sprintf("/%s/repo/testproject/0.1.0/testproject", bucket)]
+
+			for k, v := range tc.headers {
+				assert.Equal(t, v, headers.Get(k), "%s header meets expectations", k)
+			}
+
+			folder := fmt.Sprintf("/%s/repo/testproject/0.1.0/", bucket)
+
+			if !tc.folders {
+				assert.NotContains(t, store.objects, folder, "No folder objects were made")
+				return
+			}
+
+			assert.Contains(t, store.objects, folder, "Folder objects were made")
+			assert.Equal(t, tc.headers["X-Amz-Server-Side-Encryption"], store.headers[folder].Get("X-Amz-Server-Side-Encryption"), "Folder objects are encrypted too")
+		})
+	}
+}
@@ -2,253 +2,231 @@ package gomason
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/sirupsen/logrus"
 	"io"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
 // PublishFile publishes the binary to wherever you have it configured to go
 func (g *Gomason) PublishFile(meta Metadata, filePath string) (err error) {
-	// get creds
-	username, password, err := g.GetCredentials(meta)
+	_, err = g.PublishFileWithResults(meta, filePath)
+
+	return err
+}
+
+// UploadResult is the outcome of a single upload.
+type UploadResult struct {
+	Upload   PlannedUpload
+	Attempts int
+	Err      error
+}
+
+// PublishFileWithResults publishes the file, its signature, and its checksums concurrently, retrying each per the retry policy in the metadata file.  Every upload is attempted, even if others fail.  Returns the result of each upload, and an error if any of them failed.
+func (g *Gomason) PublishFileWithResults(meta Metadata, filePath string) (results []UploadResult, err error) {
+	results = make([]UploadResult, 0)
+
+	plan, err := g.PlanFile(meta, filePath, false, true)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to get credentials")
-		return err
+		err = errors.Wrapf(err, "failed to plan publishing of %s", filePath)
+		return results, err
 	}
 
-	logrus.Debugf("Publishing %s", filePath)
+	if !plan.HasTarget {
+		logrus.Debugf("No publishing target for %s", filePath)
+		return results, err
+	}
 
-	client := &http.Client{}
+	return g.PublishPlanned(meta, plan)
+}
 
-	fileName := filepath.Base(filePath)
+// PublishPlanned carries out the uploads in a plan concurrently, retrying each per the retry policy in the metadata file.  Every upload is attempted, even if others fail.  If signatures are being uploaded, nothing is unless they were made by someone allowed to sign, whether they were just made, or were already there.
+func (g *Gomason) PublishPlanned(meta Metadata, plan PublishPlan) (results []UploadResult, err error) {
+	results = make([]UploadResult, 0)
+	filePath := plan.Source
 
-	target, ok := meta.PublishInfo.TargetsMap[fileName]
+	for _, upload := range plan.Uploads {
+		if upload.Kind != UploadKindSignature {
+			continue
+		}
 
-	if ok {
-		// upload the file
-		err = UploadFile(client, target.Destination, filePath, meta, username, password)
+		// don't let anything out signed by someone who isn't allowed to sign it
+		err = g.CheckSigningPolicy(meta, filePath)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to upload file %s", filePath)
-			return err
+			err = errors.Wrapf(err, "refusing to publish %s", filePath)
+			return results, err
 		}
 
-		// upload the detached signature
-		if target.Signature {
-			err := UploadSignature(client, target.Destination, filePath, meta, username, password)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to upload signature for %s", filePath)
-				return err
-			}
+		break
+	}
 
-		}
+	// get creds
+	username, password, err := g.GetCredentials(meta)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get credentials")
+		return results, err
+	}
 
-		// upload the checksums if configured to do so
-		if target.Checksums {
-			err := UploadChecksums(client, target.Destination, filePath, meta, username, password)
-			if err != nil {
-				err = errors.Wrapf(err, "failed to upload checksums for %s", filePath)
-				return err
-			}
+	logrus.Debugf("Publishing %s", filePath)
+
+	if len(plan.Uploads) > 0 {
+		publisher, _ := GetPublisherWithAWS(plan.Uploads[0].Destination, plan.Uploads[0].AWS)
+
+		if bundler, ok := publisher.(BundlePublisher); ok {
+			return publishBundle(meta, plan, bundler, username, password)
 		}
 	}
 
-	return err
-}
+	results = make([]UploadResult, len(plan.Uploads))
+	wg := sync.WaitGroup{}
 
-// UploadChecksums uploads the checksums for a file.  This is useful if the repository is not configured to do so automatically.
-func UploadChecksums(client *http.Client, destination, filename string, meta Metadata, username string, password string) (err error) {
+	for i, upload := range plan.Uploads {
+		wg.Add(1)
 
-	md5sum, sha1sum, sha256sum, err := AllChecksumsForFile(filename)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to calculate checksum for %s", filename)
-		return err
-	}
+		go func(i int, upload PlannedUpload) {
+			defer wg.Done()
 
-	parsedDestination, err := ParseTemplateForMetadata(destination, meta)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to parse destination url %s", destination)
-		return err
-	}
+			description := fmt.Sprintf("upload of %s to %s", upload.Description(), upload.Destination)
 
-	// upload Md5Sum
-	err = UploadChecksum(parsedDestination, md5sum, "md5", client, username, password)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to upload md5sum file for %s", filename)
-	}
+			attempts, err := meta.PublishInfo.Retry.Retry(description, func() error {
+				return ExecuteUpload(upload, username, password)
+			})
 
-	// upload Sha1Sum
-	err = UploadChecksum(parsedDestination, sha1sum, "sha1", client, username, password)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to upload sha1sum file for %s", filename)
+			results[i] = UploadResult{
+				Upload:   upload,
+				Attempts: attempts,
+				Err:      err,
+			}
+		}(i, upload)
 	}
 
-	// upload Sha256Sum
-	err = UploadChecksum(parsedDestination, sha256sum, "sha256", client, username, password)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to upload sha256sum file for %s", filename)
-	}
+	wg.Wait()
 
-	return err
-}
-
-// UploadChecksum uploads the checksum of the given type for the given file
-func UploadChecksum(parsedDestination, checksum, sumtype string, client *http.Client, username, password string) (err error) {
-	target := fmt.Sprintf("%s.%s", parsedDestination, sumtype)
-	contents := checksum
+	failed := make([]string, 0)
 
-	sumMd5, sumSha1, sumSha256, err := AllChecksumsForBytes([]byte(contents))
-	if err != nil {
-		err = errors.Wrapf(err, "failed to generate checksums for %s file with contents %q", sumtype, contents)
-		return err
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Upload.Destination)
+		}
 	}
 
-	logrus.Debugf("Uploading checksum to %s", target)
-
-	err = Upload(client, target, strings.NewReader(contents), sumMd5, sumSha1, sumSha256, username, password)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to upload md5sum file to %s", target)
-		return err
+	if len(failed) > 0 {
+		err = errors.New(fmt.Sprintf("failed to upload %d of %d files for %s: %s", len(failed), len(results), filePath, strings.Join(failed, ", ")))
 	}
 
-	return err
+	return results, err
 }
 
-// UploadFile uploads a file off the filesystem
-func UploadFile(client *http.Client, destination string, filename string, meta Metadata, username string, password string) (err error) {
-	// get data
-	data, err := os.Open(filename)
+// ExecuteUpload performs a single planned upload.
+func ExecuteUpload(upload PlannedUpload, username string, password string) (err error) {
+	publisher, err := GetPublisherWithAWS(upload.Destination, upload.AWS)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to open %s", filename)
 		return err
 	}
 
-	// get checksums
-	md5sum, sha1sum, sha256sum, err := AllChecksumsForFile(filename)
+	data, checksums, err := UploadContent(upload)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to calculate checksum for %s", filename)
 		return err
 	}
 
-	parsedDestination, err := ParseTemplateForMetadata(destination, meta)
-	if err != nil {
-		err = errors.Wrapf(err, "failed to parse destination url %s", destination)
-		return err
-	}
+	defer data.Close()
 
-	logrus.Debugf("Attempting to upload %s to %s", filename, parsedDestination)
+	logrus.Debugf("Attempting to upload %s to %s", upload.Description(), upload.Destination)
 
-	return Upload(client, parsedDestination, data, md5sum, sha1sum, sha256sum, username, password)
+	return publisher.Put(upload.Destination, data, checksums, username, password)
 }
 
-// UploadSignature uploads the detached signature for a file
-func UploadSignature(client *http.Client, destination string, filename string, meta Metadata, username string, password string) (err error) {
-	filename += ".asc"
-	destination += ".asc"
-
-	return UploadFile(client, destination, filename, meta, username, password)
-}
+// UploadContent returns what a planned upload uploads, and its checksums.  That's the file, or for checksum uploads, the checksum.  Close it when done.
+func UploadContent(upload PlannedUpload) (data io.ReadCloser, checksums Checksums, err error) {
+	if upload.Kind == UploadKindChecksum {
+		sums := make(map[string]string)
 
-// Upload actually does the upload.  It uploads pure data.
-func Upload(client *http.Client, url string, data io.Reader, md5sum string, sha1sum string, sha256sum string, username string, password string) (err error) {
-	// Check to see if this is an S3 URL
-	isS3, s3Meta := S3Url(url)
-
-	if isS3 {
-		sess, err := DefaultSession()
+		sums["md5"], sums["sha1"], sums["sha256"], err = AllChecksumsForFile(upload.Source)
 		if err != nil {
-			err = errors.Wrap(err, "Failed to create AWS session")
-			return err
-		}
-
-		uploader := s3manager.NewUploader(sess)
-
-		uploadOptions := &s3manager.UploadInput{
-			Body:   data,
-			Bucket: aws.String(s3Meta.Bucket),
-			Key:    aws.String(s3Meta.Key),
+			err = errors.Wrapf(err, "failed to calculate checksum for %s", upload.Source)
+			return data, checksums, err
 		}
 
-		_, err = uploader.Upload(uploadOptions)
-		if err != nil {
-			err = errors.Wrapf(err, "failed uploading to %s", url)
-			return err
+		checksum, ok := sums[upload.SumType]
+		if !ok {
+			err = errors.New(fmt.Sprintf("unsupported checksum type %q", upload.SumType))
+			return data, checksums, err
 		}
 
-		// make the directory paths in s3
-		dirs, err := DirsForURL(s3Meta.Key)
+		checksums, err = ChecksumsForBytes([]byte(checksum))
 		if err != nil {
-			err = errors.Wrapf(err, "failed to parse dirs for %s", s3Meta.Key)
-			return err
+			err = errors.Wrapf(err, "failed to generate checksums for %s file with contents %q", upload.SumType, checksum)
+			return data, checksums, err
 		}
 
-		headSvc := s3.New(sess)
-		s3Client := s3.New(sess)
-
-		// create the 'folders' (0 byte objects) in s3
-		for _, d := range dirs {
-			if d != "." {
-				path := fmt.Sprintf("%s/", d)
-				// check to see if it doesn't already exist
-				headOptions := &s3.HeadObjectInput{
-					Bucket: aws.String(s3Meta.Bucket),
-					Key:    aws.String(path),
-				}
-
-				_, err = headSvc.HeadObject(headOptions)
-				// if there's an error, it doesn't exist
-				if err != nil {
-					// so create it
-					_, err = s3Client.PutObject(&s3.PutObjectInput{
-						Bucket: aws.String(s3Meta.Bucket),
-						Key:    aws.String(path),
-					})
-					if err != nil {
-						err = errors.Wrapf(err, "Failed to create %s in s3", path)
-						return err
-					}
-				}
-			}
-		}
+		data = io.NopCloser(strings.NewReader(checksum))
 
-		return err
+		return data, checksums, err
+	}
 
+	checksums, err = ChecksumsForFile(upload.Source)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to calculate checksum for %s", upload.Source)
+		return data, checksums, err
 	}
 
-	// TODO Check if destination exists
-	// TODO Create path if not
-	req, err := http.NewRequest("PUT", url, data)
+	// an *os.File, so that publishers can see its permissions
+	f, err := os.Open(upload.Source)
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("failed to create http request for target %s", url))
-		return err
+		err = errors.Wrapf(err, "failed to open %s", upload.Source)
+		return data, checksums, err
 	}
 
-	// add headers  (Technically these are what Artifactory expects, but should be fine for any REST interface)
-	req.Header.Add("X-Checksum-Md5", md5sum)
-	req.Header.Add("X-Checksum-Sha1", sha1sum)
-	req.Header.Add("X-Checksum-Sha256", sha256sum)
-	req.SetBasicAuth(username, password)
+	data = f
+
+	return data, checksums, err
+}
+
+// publishBundle publishes everything in a plan at once, with a BundlePublisher, retrying per the retry policy in the metadata file.  Every upload gets the same result.
+func publishBundle(meta Metadata, plan PublishPlan, bundler BundlePublisher, username string, password string) (results []UploadResult, err error) {
+	results = make([]UploadResult, len(plan.Uploads))
+
+	description := fmt.Sprintf("upload of %s to %s", plan.Source, plan.Uploads[0].Destination)
+
+	attempts, err := meta.PublishInfo.Retry.Retry(description, func() error {
+		return bundler.PutBundle(meta, plan.Uploads, username, password)
+	})
+
+	for i, upload := range plan.Uploads {
+		results[i] = UploadResult{
+			Upload:   upload,
+			Attempts: attempts,
+			Err:      err,
+		}
+	}
 
-	resp, err := client.Do(req)
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("Failed to PUT to url %s", url))
-		return err
+		err = errors.Wrapf(err, "failed to upload %s", plan.Source)
+		return results, err
 	}
 
-	logrus.Debugf("Response: %s", resp.Status)
-	logrus.Debugf("Response Code: %d", resp.StatusCode)
+	return results, err
+}
 
-	if resp.StatusCode > 299 {
-		err = errors.New(fmt.Sprintf("response code %d is not indicative of a successful publish", resp.StatusCode))
+// Upload actually does the upload.  It uploads pure data, with whichever Publisher handles the url.
+func Upload(url string, data io.Reader, checksums Checksums, username string, password string) (err error) {
+	publisher, err := GetPublisher(url)
+	if err != nil {
 		return err
 	}
 
-	return err
+	return publisher.Put(url, data, checksums, username, password)
+}
+
+// HTTPStatusError is returned when a repository responds to an upload with an unsuccessful status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("response code %d is not indicative of a successful publish", e.StatusCode)
 }
@@ -0,0 +1,78 @@
+package gomason
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishFileWithResults(t *testing.T) {
+	lock := sync.Mutex{}
+	requests := make(map[string]int)
+
+	// fails the first PUT of every file with a 503, and never accepts the sha1 file
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests[r.URL.Path]++
+
+		if filepath.Ext(r.URL.Path) == ".sha1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if requests[r.URL.Path] == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	binary := filepath.Join(tmpDir, "testproject_linux_amd64")
+
+	for _, f := range []string{binary, binary + ".asc"} {
+		err = os.WriteFile(f, []byte(testFileContent()), 0644)
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", f, err)
+		}
+	}
+
+	meta := testMetadataObj()
+	meta.Repository = fmt.Sprintf("%s/repo", server.URL)
+	meta.PublishInfo.Retry = RetryPolicy{Attempts: 3, InitialDelay: "1ms", MaxDelay: "5ms"}
+
+	g := Gomason{}
+
+	results, err := g.PublishFileWithResults(meta, binary)
+	assert.NotNil(t, err, "Failed sha1 upload is reported")
+	assert.Equal(t, 5, len(results), "Every upload has a result")
+
+	for _, r := range results {
+		if r.Upload.SumType == "sha1" {
+			assert.NotNil(t, r.Err, "sha1 upload failed")
+			assert.Equal(t, 1, r.Attempts, "403 is not retried")
+			continue
+		}
+
+		assert.Nil(t, r.Err, "%s uploaded after retrying", r.Upload.Destination)
+		assert.Equal(t, 2, r.Attempts, "%s took 2 attempts", r.Upload.Destination)
+	}
+
+	assert.Equal(t, 2, requests["/repo/testproject/0.1.0/linux/amd64/testproject"], "Binary was retried")
+	assert.Equal(t, 2, requests["/repo/testproject/0.1.0/linux/amd64/testproject.asc"], "Signature was retried")
+}
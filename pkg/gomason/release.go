@@ -0,0 +1,80 @@
+package gomason
+
+import (
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// ReleaseFileTarget returns the publishing target for a file describing the whole release, such as the manifest, with the destination template filled in from the metadata, and what the file should be called locally.  That's the last element of the destination's path, or defaultFilename if it hasn't got one.
+func ReleaseFileTarget(meta Metadata, destination string, defaultFilename string, signed bool) (target PublishTarget, filename string, err error) {
+	dst, err := ParseTemplateForMetadata(destination, meta)
+	if err != nil {
+		return target, filename, err
+	}
+
+	filename = defaultFilename
+
+	u, parseErr := url.Parse(dst)
+	if parseErr == nil && path.Base(u.Path) != "." && path.Base(u.Path) != "/" {
+		filename = path.Base(u.Path)
+	}
+
+	target = PublishTarget{
+		Source:      filename,
+		Destination: dst,
+		Signature:   signed,
+	}
+
+	return target, filename, err
+}
+
+// publishReleaseFile writes what makeContent makes to filePath, signs it if we're signing, and uploads it, and its signature, to the target.  In a dry run, it only prints what it would do.  What is what the file is, for messages.
+func (g *Gomason) publishReleaseFile(meta Metadata, what string, filePath string, target PublishTarget, sign bool, makeContent func() ([]byte, error)) (err error) {
+	if g.DryRun {
+		plan, err := g.PlanFileForTarget(meta, filePath, target, sign)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to plan publishing of %s", what)
+			return err
+		}
+
+		plan.Print(os.Stdout)
+
+		return err
+	}
+
+	content, err := makeContent()
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filePath, content, 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s to %s", what, filePath)
+		return err
+	}
+
+	if sign {
+		err = g.SignBinary(meta, filePath)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to sign %s", what)
+			return err
+		}
+	}
+
+	plan, err := g.PlanFileForTarget(meta, filePath, target, sign)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to plan publishing of %s", what)
+		return err
+	}
+
+	_, err = g.PublishPlanned(meta, plan)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to publish %s", what)
+		return err
+	}
+
+	return err
+}
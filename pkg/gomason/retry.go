@@ -0,0 +1,170 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRetryAttempts is how many times an upload is tried if the metadata file doesn't say otherwise.
+	DefaultRetryAttempts = 3
+	// DefaultRetryInitialDelay is how long to wait before the first retry if the metadata file doesn't say otherwise.
+	DefaultRetryInitialDelay = time.Second
+	// DefaultRetryMaxDelay is the longest we'll ever wait between retries if the metadata file doesn't say otherwise.
+	DefaultRetryMaxDelay = 30 * time.Second
+)
+
+// RetryPolicy controls how failed uploads are retried.  Delays are go duration strings, e.g. '500ms' or '2s'.
+type RetryPolicy struct {
+	Attempts     int    `json:"attempts,omitempty"`
+	InitialDelay string `json:"initial-delay,omitempty"`
+	MaxDelay     string `json:"max-delay,omitempty"`
+}
+
+// Delays returns the initial and maximum delays of the policy, with defaults filled in.  Negative delays, and an initial-delay longer than the max-delay, are errors.  If only the initial-delay is set, and it's longer than the default max-delay, it's the max-delay too.
+func (p RetryPolicy) Delays() (initial time.Duration, max time.Duration, err error) {
+	initial = DefaultRetryInitialDelay
+	max = DefaultRetryMaxDelay
+
+	if p.InitialDelay != "" {
+		initial, err = time.ParseDuration(p.InitialDelay)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid initial-delay %q in retry policy", p.InitialDelay)
+			return initial, max, err
+		}
+
+		if initial < 0 {
+			err = errors.New(fmt.Sprintf("invalid initial-delay %q in retry policy: delays can't be negative", p.InitialDelay))
+			return initial, max, err
+		}
+	}
+
+	if p.MaxDelay != "" {
+		max, err = time.ParseDuration(p.MaxDelay)
+		if err != nil {
+			err = errors.Wrapf(err, "invalid max-delay %q in retry policy", p.MaxDelay)
+			return initial, max, err
+		}
+
+		if max < 0 {
+			err = errors.New(fmt.Sprintf("invalid max-delay %q in retry policy: delays can't be negative", p.MaxDelay))
+			return initial, max, err
+		}
+
+		if max < initial {
+			err = errors.New(fmt.Sprintf("invalid retry policy: initial-delay %s is longer than max-delay %s", initial, max))
+			return initial, max, err
+		}
+	}
+
+	if max < initial {
+		max = initial
+	}
+
+	return initial, max, err
+}
+
+// Retry runs fn until it succeeds, fails with an error that's not worth retrying, or runs out of attempts.  Waits between attempts back off exponentially, with jitter so that concurrent uploads don't all hammer the repository at the same moment.  Returns the number of attempts made.
+func (p RetryPolicy) Retry(description string, fn func() error) (attempts int, err error) {
+	maxAttempts := p.Attempts
+	if maxAttempts < 1 {
+		maxAttempts = DefaultRetryAttempts
+	}
+
+	delay, maxDelay, err := p.Delays()
+	if err != nil {
+		return attempts, err
+	}
+
+	for attempts < maxAttempts {
+		attempts++
+
+		err = fn()
+		if err == nil {
+			return attempts, err
+		}
+
+		if !Retryable(err) {
+			logrus.Debugf("%s failed with an error that's not worth retrying: %s", description, err)
+			return attempts, err
+		}
+
+		if attempts == maxAttempts {
+			break
+		}
+
+		// 'equal jitter': wait at least half the delay, plus a random amount up to the other half
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+		logrus.Warnf("%s failed (attempt %d of %d): %s.  Retrying in %s.", description, attempts, maxAttempts, err, wait)
+
+		time.Sleep(wait)
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+
+	err = errors.Wrapf(err, "%s failed after %d attempts", description, attempts)
+
+	return attempts, err
+}
+
+// Retryable returns true for errors that might go away by trying again: network errors, and 5xx, 408 and 429 responses.  Anything else, such as a repository saying we're not authorized, a destination nobody knows how to publish to, or a file that can't be read, will fail the same way every time.
+func Retryable(err error) bool {
+	var statusCode int
+
+	switch e := errors.Cause(err).(type) {
+	case HTTPStatusError:
+		statusCode = e.StatusCode
+	case awserr.RequestFailure:
+		statusCode = e.StatusCode()
+	case awserr.Error:
+		// the AWS sdk wraps what went wrong sending a request, such as a network error
+		return e.OrigErr() != nil && Retryable(e.OrigErr())
+	default:
+		return networkError(err)
+	}
+
+	switch {
+	case statusCode >= 500:
+		return true
+	case statusCode == http.StatusRequestTimeout:
+		return true
+	case statusCode == http.StatusTooManyRequests:
+		return true
+	}
+
+	return false
+}
+
+// networkError says whether err is from talking to something over the network, such as a connection being refused or reset, or timing out.
+func networkError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	// the connection was closed on us mid request
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,195 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	inputs := []struct {
+		name     string
+		policy   RetryPolicy
+		errs     []error
+		attempts int
+		failed   bool
+	}{
+		{
+			"first time",
+			RetryPolicy{Attempts: 3, InitialDelay: "0s"},
+			[]error{nil},
+			1,
+			false,
+		},
+		{
+			"transient",
+			RetryPolicy{Attempts: 3, InitialDelay: "1ms", MaxDelay: "2ms"},
+			[]error{HTTPStatusError{StatusCode: 503}, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, nil},
+			3,
+			false,
+		},
+		{
+			"exhausted",
+			RetryPolicy{Attempts: 2, InitialDelay: "1ms"},
+			[]error{HTTPStatusError{StatusCode: 502}, HTTPStatusError{StatusCode: 502}, nil},
+			2,
+			true,
+		},
+		{
+			"permanent",
+			RetryPolicy{Attempts: 3, InitialDelay: "1ms"},
+			[]error{errors.Wrap(HTTPStatusError{StatusCode: 403}, "failed"), nil},
+			1,
+			true,
+		},
+		{
+			"never reached the network",
+			RetryPolicy{Attempts: 3, InitialDelay: "1ms"},
+			[]error{errors.New("don't know how to publish to ftp://example.com/foo"), nil},
+			1,
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+
+			attempts, err := tc.policy.Retry(tc.name, func() error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+
+			assert.Equal(t, tc.attempts, attempts, "Number of attempts meets expectations")
+			assert.Equal(t, tc.attempts, calls, "Number of calls meets expectations")
+			assert.Equal(t, tc.failed, err != nil, "Error meets expectations")
+		})
+	}
+}
+
+func TestRetryPolicyDelays(t *testing.T) {
+	initial, max, err := RetryPolicy{}.Delays()
+	if err != nil {
+		t.Errorf("Error getting default delays: %s", err)
+	}
+
+	assert.Equal(t, DefaultRetryInitialDelay, initial, "Default initial delay")
+	assert.Equal(t, DefaultRetryMaxDelay, max, "Default max delay")
+
+	inputs := []struct {
+		name    string
+		policy  RetryPolicy
+		initial time.Duration
+		max     time.Duration
+		errs    bool
+	}{
+		{"set", RetryPolicy{InitialDelay: "500ms", MaxDelay: "5s"}, 500 * time.Millisecond, 5 * time.Second, false},
+		{"no delay", RetryPolicy{InitialDelay: "0s", MaxDelay: "0s"}, 0, 0, false},
+		{"initial over default max", RetryPolicy{InitialDelay: "1m"}, time.Minute, time.Minute, false},
+		{"invalid", RetryPolicy{InitialDelay: "soon"}, 0, 0, true},
+		{"negative initial", RetryPolicy{InitialDelay: "-1s"}, 0, 0, true},
+		{"negative max", RetryPolicy{MaxDelay: "-1s"}, 0, 0, true},
+		{"initial over max", RetryPolicy{InitialDelay: "10s", MaxDelay: "1s"}, 0, 0, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			initial, max, err := tc.policy.Delays()
+			if tc.errs {
+				assert.NotNil(t, err, "Invalid delays are an error")
+
+				_, err = tc.policy.Retry("test", func() error { return errors.New("failed") })
+				assert.NotNil(t, err, "Retrying with invalid delays fails, rather than panicking")
+				return
+			}
+
+			assert.Nil(t, err, "No error getting delays")
+			assert.Equal(t, tc.initial, initial, "Initial delay meets expectations")
+			assert.Equal(t, tc.max, max, "Max delay meets expectations")
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	inputs := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"server error", HTTPStatusError{StatusCode: 500}, true},
+		{"request timeout", HTTPStatusError{StatusCode: 408}, true},
+		{"too many requests", errors.Wrap(HTTPStatusError{StatusCode: 429}, "failed"), true},
+		{"forbidden", HTTPStatusError{StatusCode: 403}, false},
+		{"connection refused", &url.Error{Op: "Put", URL: "http://localhost:1/foo", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{"dns", &net.DNSError{Err: "server misbehaving", Name: "repo.example.com"}, true},
+		{"connection closed", &url.Error{Op: "Put", URL: "http://localhost:1/foo", Err: io.EOF}, true},
+		{"aws network error", awserr.New("RequestError", "send request failed", &url.Error{Op: "Put", URL: "https://foo.s3.amazonaws.com/bar", Err: io.ErrUnexpectedEOF}), true},
+		{"aws service unavailable", awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "slow down", nil), 503, "1"), true},
+		{"aws access denied", awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "1"), false},
+		{"aws bad config", awserr.New("InvalidParameter", "bucket can't be empty", nil), false},
+		{"unsupported scheme", errors.New("don't know how to publish to ftp://example.com/foo"), false},
+		{"unreadable source", errors.Wrapf(&os.PathError{Op: "open", Path: "/nonexistent", Err: syscall.ENOENT}, "failed to open %s", "/nonexistent"), false},
+		{"index", OCIIndexError{Reference: "localhost/foo:1.0", Reason: "it's an index"}, false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.retryable, Retryable(tc.err), "Retryable meets expectations")
+		})
+	}
+}
+
+func TestPublishUnsupportedScheme(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	meta := testMetadataObj()
+	meta.PublishInfo.Retry = RetryPolicy{Attempts: 3, InitialDelay: "1s"}
+
+	plan := PublishPlan{
+		Source:    binary,
+		Publish:   true,
+		HasTarget: true,
+		Uploads: []PlannedUpload{
+			{Kind: UploadKindArtifact, Source: binary, Destination: "ftp://example.com/testproject"},
+			{Kind: UploadKindChecksum, Source: filepath.Join(dir, "nonexistent"), SumType: "sha256", Destination: fmt.Sprintf("file://%s/testproject.sha256", dir)},
+		},
+	}
+
+	g := Gomason{}
+
+	start := time.Now()
+
+	results, err := g.PublishPlanned(meta, plan)
+	assert.NotNil(t, err, "Publishing fails")
+	assert.Len(t, results, 2, "Every upload was attempted")
+
+	for _, r := range results {
+		assert.NotNil(t, r.Err, "Upload of %s fails", r.Upload.Description())
+		assert.Equal(t, 1, r.Attempts, "Upload of %s is only tried once", r.Upload.Description())
+	}
+
+	assert.Less(t, time.Since(start), time.Second, "Nothing waited to retry")
+}
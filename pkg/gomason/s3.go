@@ -0,0 +1,504 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+)
+
+// S3HostPattern matches the hosts of S3 urls, be they 'virtual host' style, i.e. https://<bucket>.s3.<region>.amazonaws.com/<key>, or path style, i.e. https://s3.<region>.amazonaws.com/<bucket>/<key>
+const S3HostPattern = `^(.+\.)?s3([.-][a-z0-9-]+)?\.amazonaws\.com$`
+
+func init() {
+	RegisterPublisher("s3", S3Publisher{})
+	_ = RegisterHostPublisher(S3HostPattern, S3Publisher{})
+}
+
+// AWSPublisher is a Publisher that takes the AWS settings from the metadata file.
+type AWSPublisher interface {
+	Publisher
+	WithAWSConfig(config AWSConfig) Publisher
+}
+
+// GetPublisherWithAWS returns the Publisher for the given destination url, given the AWS settings for it.  Publishers that aren't AWSPublishers ignore them.
+func GetPublisherWithAWS(destination string, config AWSConfig) (publisher Publisher, err error) {
+	publisher, err = GetPublisher(destination)
+	if err != nil {
+		return publisher, err
+	}
+
+	if awsPublisher, ok := publisher.(AWSPublisher); ok {
+		publisher = awsPublisher.WithAWSConfig(config)
+	}
+
+	return publisher, err
+}
+
+// WithOverrides returns the settings with anything set in overrides overriding them.
+func (c AWSConfig) WithOverrides(overrides AWSConfig) AWSConfig {
+	if overrides.Profile != "" {
+		c.Profile = overrides.Profile
+	}
+
+	if overrides.Region != "" {
+		c.Region = overrides.Region
+	}
+
+	if overrides.Endpoint != "" {
+		c.Endpoint = overrides.Endpoint
+	}
+
+	if overrides.PathStyle {
+		c.PathStyle = overrides.PathStyle
+	}
+
+	if overrides.RoleARN != "" {
+		c.RoleARN = overrides.RoleARN
+	}
+
+	if overrides.ExternalID != "" {
+		c.ExternalID = overrides.ExternalID
+	}
+
+	if overrides.RoleSessionName != "" {
+		c.RoleSessionName = overrides.RoleSessionName
+	}
+
+	if overrides.ServerSideEncryption != "" {
+		c.ServerSideEncryption = overrides.ServerSideEncryption
+	}
+
+	if overrides.SSEKMSKeyID != "" {
+		c.SSEKMSKeyID = overrides.SSEKMSKeyID
+	}
+
+	if overrides.StorageClass != "" {
+		c.StorageClass = overrides.StorageClass
+	}
+
+	if overrides.ACL != "" {
+		c.ACL = overrides.ACL
+	}
+
+	if overrides.ContentType != "" {
+		c.ContentType = overrides.ContentType
+	}
+
+	if overrides.CacheControl != "" {
+		c.CacheControl = overrides.CacheControl
+	}
+
+	// tags are added to, rather than replaced
+	if len(overrides.Tags) > 0 {
+		tags := make(map[string]string)
+
+		for k, v := range c.Tags {
+			tags[k] = v
+		}
+
+		for k, v := range overrides.Tags {
+			tags[k] = v
+		}
+
+		c.Tags = tags
+	}
+
+	if overrides.SkipFolders {
+		c.SkipFolders = overrides.SkipFolders
+	}
+
+	return c
+}
+
+// ParseTagsForMetadata fills in the template fields, e.g. '{{.Version}}', in the values of the object tags.
+func ParseTagsForMetadata(tags map[string]string, meta Metadata) (parsed map[string]string, err error) {
+	if len(tags) == 0 {
+		return tags, err
+	}
+
+	parsed = make(map[string]string)
+
+	for k, v := range tags {
+		parsed[k], err = ParseTemplateForMetadata(v, meta)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse tag %s", k)
+			return parsed, err
+		}
+	}
+
+	return parsed, err
+}
+
+// sse returns the server side encryption to ask for.  A KMS key on its own means KMS.
+func (c AWSConfig) sse() string {
+	if c.ServerSideEncryption == "" && c.SSEKMSKeyID != "" {
+		return s3.ServerSideEncryptionAwsKms
+	}
+
+	return c.ServerSideEncryption
+}
+
+// tagging returns the object tags as S3 wants them in a request, i.e. url encoded.
+func (c AWSConfig) tagging() string {
+	tags := url.Values{}
+
+	for k, v := range c.Tags {
+		tags.Set(k, v)
+	}
+
+	return tags.Encode()
+}
+
+// optionalString is aws.String for things that shouldn't be sent at all if they're not set.
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return aws.String(value)
+}
+
+// AWSConfigFor returns the AWS settings for publishing the given target: those of the repository, overridden by any set on the target.
+func (m Metadata) AWSConfigFor(target PublishTarget) AWSConfig {
+	return m.PublishInfo.AWS.WithOverrides(target.AWS)
+}
+
+// NewAWSSession creates an AWS session with the given settings.  Credentials come from the environment, or from the shared AWS config and credentials files, with the profile if one is set.  If a role is set, it's assumed with those credentials.
+func NewAWSSession(config AWSConfig) (awssession *session.Session, err error) {
+	opts := session.Options{
+		Profile: config.Profile,
+	}
+
+	if config.Profile != "" || (os.Getenv(AWS_ID_ENV_VAR) == "" && os.Getenv(AWS_SECRET_ENV_VAR) == "") {
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+
+	region := config.Region
+
+	// For some reason this doesn't get picked up automatically, but we'll set it if it's present in the environment.
+	if region == "" {
+		region = os.Getenv(AWS_REGION_ENV_VAR)
+	}
+
+	// S3 compatible stores mostly don't care about the region, but the sdk insists on one.
+	if region == "" && config.Endpoint != "" {
+		region = "us-east-1"
+	}
+
+	if region != "" {
+		opts.Config.Region = aws.String(region)
+	}
+
+	awssession, err = session.NewSessionWithOptions(opts)
+	if err != nil {
+		err = errors.Wrap(err, "failed to load AWS configuration")
+		return awssession, err
+	}
+
+	// the sdk doesn't complain about a profile that isn't there until it's used
+	if config.Profile != "" {
+		_, err = awssession.Config.Credentials.Get()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get credentials for AWS profile %s", config.Profile)
+			return awssession, err
+		}
+	}
+
+	if config.RoleARN != "" {
+		creds := stscreds.NewCredentials(awssession, config.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if config.ExternalID != "" {
+				p.ExternalID = aws.String(config.ExternalID)
+			}
+
+			if config.RoleSessionName != "" {
+				p.RoleSessionName = config.RoleSessionName
+			}
+		})
+
+		awssession = awssession.Copy(&aws.Config{Credentials: creds})
+	}
+
+	return awssession, err
+}
+
+// S3Publisher publishes to S3, or anything that talks like it, with the AWS api.  Credentials come from the AWS settings in the metadata file, or whatever's configured in the environment.
+type S3Publisher struct {
+	// Config is the AWS settings to use.  The region defaults to the one in the url, if there is one.
+	Config AWSConfig
+	// NewSession makes the AWS session to use.  If it's not set, NewAWSSession is used.
+	NewSession func(config AWSConfig) (*session.Session, error)
+}
+
+// WithAWSConfig returns the publisher with the given AWS settings.
+func (p S3Publisher) WithAWSConfig(config AWSConfig) Publisher {
+	p.Config = config
+
+	return p
+}
+
+func (p S3Publisher) client(url string) (client *s3.S3, s3Meta S3Meta, err error) {
+	isS3, s3Meta := S3Url(url)
+	if !isS3 {
+		err = errors.New(fmt.Sprintf("%s is not an S3 url", url))
+		return client, s3Meta, err
+	}
+
+	config := p.Config
+	if config.Region == "" {
+		config.Region = s3Meta.Region
+	}
+
+	newSession := p.NewSession
+	if newSession == nil {
+		newSession = NewAWSSession
+	}
+
+	sess, err := newSession(config)
+	if err != nil {
+		err = errors.Wrap(err, "Failed to create AWS session")
+		return client, s3Meta, err
+	}
+
+	// the endpoint is only for S3.  Anything else, such as STS for assuming roles, is still AWS.
+	s3Config := aws.NewConfig()
+
+	if config.Endpoint != "" {
+		s3Config.Endpoint = aws.String(config.Endpoint)
+	}
+
+	if config.PathStyle || s3Meta.PathStyle {
+		s3Config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	client = s3.New(sess, s3Config)
+
+	return client, s3Meta, err
+}
+
+// Put uploads data to url, with the encryption, storage class and so on in the AWS settings.  It makes the 'folders' above it, as 0 byte objects, if they're not already there, unless told not to.
+func (p S3Publisher) Put(url string, data io.Reader, checksums Checksums, username string, password string) (err error) {
+	s3Client, s3Meta, err := p.client(url)
+	if err != nil {
+		return err
+	}
+
+	uploader := s3manager.NewUploaderWithClient(s3Client)
+
+	uploadOptions := &s3manager.UploadInput{
+		Body:                 data,
+		Bucket:               aws.String(s3Meta.Bucket),
+		Key:                  aws.String(s3Meta.Key),
+		ServerSideEncryption: optionalString(p.Config.sse()),
+		SSEKMSKeyId:          optionalString(p.Config.SSEKMSKeyID),
+		StorageClass:         optionalString(p.Config.StorageClass),
+		ACL:                  optionalString(p.Config.ACL),
+		ContentType:          optionalString(p.Config.ContentType),
+		CacheControl:         optionalString(p.Config.CacheControl),
+		Tagging:              optionalString(p.Config.tagging()),
+	}
+
+	_, err = uploader.Upload(uploadOptions)
+	if err != nil {
+		err = errors.Wrapf(err, "failed uploading to %s", url)
+		return err
+	}
+
+	if p.Config.SkipFolders {
+		return err
+	}
+
+	// make the directory paths in s3
+	dirs, err := DirsForURL(s3Meta.Key)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse dirs for %s", s3Meta.Key)
+		return err
+	}
+
+	// create the 'folders' (0 byte objects) in s3
+	for _, d := range dirs {
+		if d != "." {
+			path := fmt.Sprintf("%s/", d)
+			// check to see if it doesn't already exist
+			headOptions := &s3.HeadObjectInput{
+				Bucket: aws.String(s3Meta.Bucket),
+				Key:    aws.String(path),
+			}
+
+			_, err = s3Client.HeadObject(headOptions)
+			// if there's an error, it doesn't exist
+			if err != nil {
+				// so create it.  Bucket policies that want encryption want it for these too.
+				_, err = s3Client.PutObject(&s3.PutObjectInput{
+					Bucket:               aws.String(s3Meta.Bucket),
+					Key:                  aws.String(path),
+					ServerSideEncryption: optionalString(p.Config.sse()),
+					SSEKMSKeyId:          optionalString(p.Config.SSEKMSKeyID),
+					StorageClass:         optionalString(p.Config.StorageClass),
+					ACL:                  optionalString(p.Config.ACL),
+				})
+				if err != nil {
+					err = errors.Wrapf(err, "Failed to create %s in s3", path)
+					return err
+				}
+			}
+		}
+	}
+
+	return err
+}
+
+// Get downloads the object at url into out.
+func (p S3Publisher) Get(url string, out io.Writer, username string, password string) (err error) {
+	s3Client, s3Meta, err := p.client(url)
+	if err != nil {
+		return err
+	}
+
+	obj, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s3Meta.Bucket),
+		Key:    aws.String(s3Meta.Key),
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed downloading %s", url)
+		return err
+	}
+
+	defer obj.Body.Close()
+
+	_, err = io.Copy(out, obj.Body)
+	if err != nil {
+		err = errors.Wrapf(err, "failed downloading %s", url)
+		return err
+	}
+
+	return err
+}
+
+// Head says whether there's an object at url.
+func (p S3Publisher) Head(url string, username string, password string) (exists bool, err error) {
+	s3Client, s3Meta, err := p.client(url)
+	if err != nil {
+		return exists, err
+	}
+
+	_, err = s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s3Meta.Bucket),
+		Key:    aws.String(s3Meta.Key),
+	})
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+			return exists, nil
+		}
+
+		err = errors.Wrapf(err, "failed checking for %s", url)
+		return exists, err
+	}
+
+	exists = true
+
+	return exists, err
+}
+
+// Delete removes the object at url.  S3 doesn't mind deleting something that isn't there.
+func (p S3Publisher) Delete(url string, username string, password string) (err error) {
+	s3Client, s3Meta, err := p.client(url)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s3Meta.Bucket),
+		Key:    aws.String(s3Meta.Key),
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed deleting %s", url)
+		return err
+	}
+
+	return err
+}
+
+// List returns the urls of the objects under the 'folder' at url.  Folder objects themselves are left out.
+func (p S3Publisher) List(url string, username string, password string) (urls []string, err error) {
+	urls = make([]string, 0)
+
+	s3Client, s3Meta, err := p.client(url)
+	if err != nil {
+		return urls, err
+	}
+
+	prefix := s3Meta.Key
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	base := strings.TrimSuffix(url, s3Meta.Key)
+
+	err = s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s3Meta.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if !strings.HasSuffix(key, "/") {
+				urls = append(urls, base+key)
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed listing %s", url)
+		return urls, err
+	}
+
+	sort.Strings(urls)
+
+	return urls, err
+}
+
+// Method describes where in S3 url is, and any AWS settings it'd be published with.
+func (p S3Publisher) Method(url string) string {
+	_, s3Meta := S3Url(url)
+
+	region := s3Meta.Region
+	if p.Config.Region != "" {
+		region = p.Config.Region
+	}
+
+	method := fmt.Sprintf("S3 (bucket: %s, region: %s, key: %s", s3Meta.Bucket, region, s3Meta.Key)
+
+	if p.Config.Endpoint != "" {
+		method += fmt.Sprintf(", endpoint: %s", p.Config.Endpoint)
+	}
+
+	if p.Config.Profile != "" {
+		method += fmt.Sprintf(", profile: %s", p.Config.Profile)
+	}
+
+	if p.Config.RoleARN != "" {
+		method += fmt.Sprintf(", role: %s", p.Config.RoleARN)
+	}
+
+	if p.Config.sse() != "" {
+		method += fmt.Sprintf(", sse: %s", p.Config.sse())
+	}
+
+	if p.Config.StorageClass != "" {
+		method += fmt.Sprintf(", storage class: %s", p.Config.StorageClass)
+	}
+
+	return method + ")"
+}
@@ -0,0 +1,312 @@
+package gomason
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SignerPluginPrefix is the prefix of signing plugins.  A signing program gomason doesn't know is looked for on the PATH as 'gomason-signer-<program>'.
+const SignerPluginPrefix = "gomason-signer-"
+
+// Exit codes a signing plugin uses to say why a signature didn't verify.
+const (
+	SignerPluginExitBadSignature  = 1
+	SignerPluginExitUnknownSigner = 2
+)
+
+// Signer is a generic interface for signing files, and verifying their signatures.
+type Signer interface {
+	Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) error
+	Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (bool, error)
+	SignatureSuffix() string
+}
+
+// NoSigner is what GetSigner returns for signing programs it can't find.  Unlike NoLanguage, it refuses to do anything, so nothing goes out unsigned by accident.
+type NoSigner struct {
+	Name string
+}
+
+// Sign Stub for the Sign action
+func (s NoSigner) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) error {
+	return errors.New(fmt.Sprintf("Unsupported signing program: %s", s.Name))
+}
+
+// Verify Stub for the Verify action
+func (s NoSigner) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (bool, error) {
+	return false, errors.New(fmt.Sprintf("Unsupported signing program: %s", s.Name))
+}
+
+// SignatureSuffix Stub for the SignatureSuffix action
+func (NoSigner) SignatureSuffix() string {
+	return ""
+}
+
+var signersMap map[string]Signer = map[string]Signer{}
+var signersMutex sync.Mutex
+
+// RegisterSigner makes a Signer available by name, for tools that drive gomason from Go.
+func RegisterSigner(name string, signer Signer) {
+	signersMutex.Lock()
+	defer signersMutex.Unlock()
+
+	signersMap[name] = signer
+}
+
+// GetSigner Gets a specific Signer interface by name.  If it's not one gomason knows, a 'gomason-signer-<name>' plugin on the PATH is used if there is one.
+func GetSigner(name string) (Signer, error) {
+	if name == "" {
+		name = defaultSigningProgram
+	}
+
+	signersMutex.Lock()
+	defer signersMutex.Unlock()
+
+	s, ok := signersMap[name]
+	if ok {
+		return s, nil
+	}
+
+	plugin, err := NewExecSigner(name)
+	if err != nil {
+		return NoSigner{Name: name}, errors.Wrapf(err, "Unsupported signing program: %s", name)
+	}
+
+	// remember it, so it's only asked for its suffix once, and its signatures get collected along with everyone else's
+	signersMap[name] = plugin
+
+	return plugin, nil
+}
+
+// ExecSigner signs and verifies by running a 'gomason-signer-<name>' plugin.
+//
+// The plugin is run as:
+//
+//	gomason-signer-<name> suffix                  prints the suffix of its signature files, e.g. '.sig'
+//	gomason-signer-<name> sign <file>             prints the signature of <file>, which gomason writes to the signature file
+//	gomason-signer-<name> verify <file> <sigfile> exits 0 if the signature is good, 1 if it's bad, and 2 if the signer isn't trusted.  Anything it prints is used to explain why.
+//	gomason-signer-<name> signer <file> <sigfile> verifies like 'verify', and prints who made the signature.  Only needed if 'allowed' is set in the signing section of the metadata file.
+//
+// GOMASON_SIGNING_ENTITY, GOMASON_SIGNING_IDENTITY, GOMASON_KEYRING, GOMASON_KEYFILE, GOMASON_CERTFILE, GOMASON_PACKAGE and GOMASON_VERSION are set in its environment.
+type ExecSigner struct {
+	Name   string
+	Path   string
+	Suffix string
+}
+
+// NewExecSigner finds the plugin for the named signing program on the PATH, and asks it for its signature suffix.
+func NewExecSigner(name string) (signer ExecSigner, err error) {
+	path, err := exec.LookPath(SignerPluginPrefix + name)
+	if err != nil {
+		err = errors.Wrapf(err, "no %s%s plugin in PATH", SignerPluginPrefix, name)
+		return signer, err
+	}
+
+	signer = ExecSigner{
+		Name: name,
+		Path: path,
+	}
+
+	output, err := signer.run(nil, "suffix")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get signature suffix from %s", path)
+		return signer, err
+	}
+
+	suffix := strings.TrimSpace(output)
+
+	if !strings.HasPrefix(suffix, ".") || strings.ContainsAny(suffix, `/\`) || len(suffix) < 2 {
+		err = errors.New(fmt.Sprintf("%s gave an invalid signature suffix %q.  It must be something like '.sig'", path, suffix))
+		return signer, err
+	}
+
+	signer.Suffix = suffix
+
+	logrus.Debugf("Using signing plugin %s, which makes %s files", path, suffix)
+
+	return signer, err
+}
+
+// Sign runs the plugin to sign binary, and writes what it prints to sigFile.
+func (s ExecSigner) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	signature, err := s.run(s.env(identity, meta), "sign", binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sign %s", binary)
+		return err
+	}
+
+	if len(signature) == 0 {
+		err = errors.New(fmt.Sprintf("%s made an empty signature for %s", s.Path, binary))
+		return err
+	}
+
+	err = os.WriteFile(sigFile, []byte(signature), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", sigFile)
+		return err
+	}
+
+	return err
+}
+
+// Verify runs the plugin to verify the signature of binary in sigFile.
+func (s ExecSigner) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (ok bool, err error) {
+	output, err := s.run(s.env(identity, meta), "verify", binary, sigFile)
+	if err != nil {
+		err = s.failure(binary, sigFile, output, err)
+		return ok, err
+	}
+
+	ok = true
+
+	return ok, err
+}
+
+// Inspect runs the plugin to verify the signature of binary in sigFile, and say who made it.  It prints lines of 'fingerprint <fingerprint>', 'identity <identity>', 'expired' and 'revoked', as they apply, and exits just as it does for 'verify'.
+func (s ExecSigner) Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (signer SignatureSigner, err error) {
+	output, err := s.run(s.env(identity, meta), "signer", binary, sigFile)
+	if err != nil {
+		err = s.failure(binary, sigFile, output, err)
+		return signer, err
+	}
+
+	signer.Identities = make([]string, 0)
+
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+
+		value := ""
+		if len(parts) > 1 {
+			value = strings.TrimSpace(parts[1])
+		}
+
+		switch parts[0] {
+		case "fingerprint":
+			signer.Fingerprint = value
+		case "identity":
+			signer.Identities = append(signer.Identities, value)
+		case "expired":
+			signer.Expired = true
+		case "revoked":
+			signer.Revoked = true
+		}
+	}
+
+	if signer.Fingerprint == "" {
+		err = errors.New(fmt.Sprintf("%s didn't say what key signed %s", s.Path, binary))
+		return signer, err
+	}
+
+	return signer, err
+}
+
+// failure turns the plugin's exit code from verifying into a BadSignatureError or UnknownSignerError, using what it printed as the reason.
+func (s ExecSigner) failure(binary string, sigFile string, output string, err error) error {
+	if exitErr, isExit := errors.Cause(err).(*exec.ExitError); isExit {
+		reason := strings.TrimSpace(output)
+
+		switch exitErr.ExitCode() {
+		case SignerPluginExitBadSignature:
+			if reason == "" {
+				reason = fmt.Sprintf("%s says so", s.Path)
+			}
+
+			return BadSignatureError{File: binary, Reason: reason}
+
+		case SignerPluginExitUnknownSigner:
+			if reason == "" {
+				reason = fmt.Sprintf("a key %s doesn't trust", s.Path)
+			}
+
+			return UnknownSignerError{File: binary, Signer: reason}
+		}
+	}
+
+	return errors.Wrapf(err, "error verifying %s", sigFile)
+}
+
+// SignatureSuffix returns the suffix the plugin said its signatures have.
+func (s ExecSigner) SignatureSuffix() string {
+	return s.Suffix
+}
+
+// env is the environment the plugin runs in.
+func (s ExecSigner) env(identity SigningIdentity, meta Metadata) (env []string) {
+	env = append(os.Environ(),
+		fmt.Sprintf("GOMASON_SIGNING_ENTITY=%s", identity.Email),
+		fmt.Sprintf("GOMASON_SIGNING_IDENTITY=%s", identity.Name),
+		fmt.Sprintf("GOMASON_KEYRING=%s", identity.Keyring),
+		fmt.Sprintf("GOMASON_KEYFILE=%s", identity.KeyFile),
+		fmt.Sprintf("GOMASON_CERTFILE=%s", identity.CertFile),
+		fmt.Sprintf("GOMASON_PACKAGE=%s", meta.Package),
+		fmt.Sprintf("GOMASON_VERSION=%s", meta.Version),
+	)
+
+	return env
+}
+
+// run runs the plugin with the given args, and returns what it printed.  The plugin's stderr and stdin are the user's, so it can ask for passphrases and the like.
+func (s ExecSigner) run(env []string, args ...string) (output string, err error) {
+	cmd := exec.Command(s.Path, args...)
+
+	var stdout bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	if env != nil {
+		cmd.Env = env
+	}
+
+	logrus.Debugf("Running %s %s", s.Path, strings.Join(args, " "))
+
+	err = cmd.Run()
+	output = stdout.String()
+
+	if err != nil {
+		err = errors.Wrapf(err, "%s %s failed", s.Path, strings.Join(args, " "))
+		return output, err
+	}
+
+	return output, err
+}
+
+// SignatureSuffix returns the suffix of the signature files the given signing program makes.  Programs we can't find are assumed to make gpg's '.asc'.
+func SignatureSuffix(signProg string) (suffix string) {
+	signer, err := GetSigner(signProg)
+	if err != nil {
+		logrus.Debugf("%s", err)
+		return GPGSignatureSuffix
+	}
+
+	return signer.SignatureSuffix()
+}
+
+// SignatureSuffixes returns the suffixes of signature files made by all the signing programs we know about.
+func SignatureSuffixes() (suffixes []string) {
+	signersMutex.Lock()
+	defer signersMutex.Unlock()
+
+	seen := make(map[string]bool)
+	suffixes = make([]string, 0)
+
+	for _, s := range signersMap {
+		suffix := s.SignatureSuffix()
+		if !seen[suffix] {
+			seen[suffix] = true
+			suffixes = append(suffixes, suffix)
+		}
+	}
+
+	sort.Strings(suffixes)
+
+	return suffixes
+}
@@ -0,0 +1,166 @@
+package gomason
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSignerPlugin is a signing plugin that 'signs' by recording who signed, and the sha256 of the file.  Signatures by 'untrusted@foo.com' are from an unknown signer, and 'stale@foo.com' has an expired key.
+const testSignerPlugin = `#!/bin/sh
+case "$1" in
+  suffix)
+    echo ".testsig"
+    ;;
+  sign)
+    echo "$GOMASON_SIGNING_ENTITY $(sha256sum "$2" | cut -d ' ' -f 1)"
+    ;;
+  verify|signer)
+    read -r signer sum < "$3"
+    if [ "$signer" = "untrusted@foo.com" ]; then
+      echo "$signer"
+      exit 2
+    fi
+    if [ "$sum" != "$(sha256sum "$2" | cut -d ' ' -f 1)" ]; then
+      echo "checksum in signature does not match"
+      exit 1
+    fi
+    if [ "$1" = "signer" ]; then
+      echo "fingerprint $(printf '%s' "$signer" | sha256sum | cut -c 1-40)"
+      echo "identity Gomason Tester <$signer>"
+      if [ "$signer" = "stale@foo.com" ]; then
+        echo "expired"
+      fi
+    fi
+    ;;
+  *)
+    exit 3
+    ;;
+esac
+`
+
+// installTestSignerPlugin writes testSignerPlugin into dir as the plugin for the named signing program, and puts it on the PATH.  The returned func puts things back as they were.
+func installTestSignerPlugin(t *testing.T, dir string, name string) (cleanup func()) {
+	plugin := filepath.Join(dir, SignerPluginPrefix+name)
+
+	err := os.WriteFile(plugin, []byte(testSignerPlugin), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", plugin, err)
+	}
+
+	oldPath := os.Getenv("PATH")
+	_ = os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+
+	cleanup = func() {
+		_ = os.Setenv("PATH", oldPath)
+
+		signersMutex.Lock()
+		delete(signersMap, name)
+		signersMutex.Unlock()
+	}
+
+	return cleanup
+}
+
+func TestGetSigner(t *testing.T) {
+	inputs := []struct {
+		name   string
+		suffix string
+		errs   bool
+	}{
+		{"", GPGSignatureSuffix, false},
+		{SigningProgramGPG, GPGSignatureSuffix, false},
+		{SigningProgramOpenPGP, GPGSignatureSuffix, false},
+		{SigningProgramMinisign, MinisignSignatureSuffix, false},
+		{SigningProgramSSH, SSHSignatureSuffix, false},
+		{"no-such-signer", "", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := GetSigner(tc.name)
+			if tc.errs {
+				assert.NotNil(t, err, "Unknown signing program is an error")
+				assert.IsType(t, NoSigner{}, signer, "NoSigner for unknown programs")
+
+				err = signer.Sign(SigningIdentity{Program: tc.name, Email: "tester@foo.com"}, testMetadataObj(), "foo", "foo.sig")
+				assert.NotNil(t, err, "NoSigner refuses to sign")
+
+				return
+			}
+
+			assert.Nil(t, err, "No error getting signer")
+			assert.Equal(t, tc.suffix, signer.SignatureSuffix(), "Signature suffix")
+		})
+	}
+}
+
+func TestExecSigner(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pluginName := "exectest"
+
+	cleanup := installTestSignerPlugin(t, dir, pluginName)
+	defer cleanup()
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	inputs := []struct {
+		name    string
+		entity  string
+		tamper  bool
+		failure error
+	}{
+		{"good", "tester@foo.com", false, nil},
+		{"bad signature", "tester@foo.com", true, BadSignatureError{}},
+		{"unknown signer", "untrusted@foo.com", false, UnknownSignerError{}},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := os.WriteFile(binary, []byte(testFileContent()), 0755)
+			if err != nil {
+				t.Fatalf("Error writing %s: %s", binary, err)
+			}
+
+			meta := testMetadataObj()
+			meta.SignInfo = SignInfo{Program: pluginName, Email: tc.entity}
+
+			g := Gomason{}
+
+			err = g.SignBinary(meta, binary)
+			if err != nil {
+				t.Fatalf("Error signing with plugin: %s", err)
+			}
+
+			_, err = os.Stat(binary + ".testsig")
+			assert.Nil(t, err, "Plugin signature written")
+
+			if tc.tamper {
+				err = os.WriteFile(binary, []byte(testFileContent()+"tampered"), 0755)
+				if err != nil {
+					t.Fatalf("Error writing %s: %s", binary, err)
+				}
+			}
+
+			ok, err := VerifyBinary(binary, meta)
+			if tc.failure != nil {
+				assert.False(t, ok, "Signature doesn't verify")
+				assert.IsType(t, tc.failure, VerificationFailure(err), "Failure is reported as what it is")
+				return
+			}
+
+			assert.Nil(t, err, "No error verifying")
+			assert.True(t, ok, "Signature verifies")
+		})
+	}
+
+	assert.Equal(t, ".testsig", SignatureSuffix(pluginName), "Plugin signature suffix")
+	assert.Contains(t, SignatureSuffixes(), ".testsig", "Plugin signatures are collected")
+}
@@ -1,82 +1,293 @@
 package gomason
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
+func init() {
+	signersMap[SigningProgramGPG] = GPGSigner{}
+}
+
+// SigningProgramGPG signs with the gpg binary, and whatever keys it has.
+const SigningProgramGPG = "gpg"
+
+// GPGSignatureSuffix is the suffix of the detached, armored signatures gpg makes.
+const GPGSignatureSuffix = ".asc"
+
 // It's a good default.  You can install it anywhere.
-const defaultSigningProgram = "gpg"
+const defaultSigningProgram = SigningProgramGPG
 
-// SignBinary  signs the given binary based on the entity and program given in metadata file, possibly overridden by information in ~/.gomason
+// ErrNoSigningEntity is returned by signing programs that need to know who's signing when nobody said.
+var ErrNoSigningEntity = errors.New("Cannot sign without a signing entity (email).\n\nSet 'signing' section in metadata file, or create ~/.gomason with the appropriate content.\n\nSee https://github.com/nikogura/gomason#config-reference for details.\n\n")
+
+var identityNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// interactiveSigningMutex lets one signing program at a time have the terminal.
+var interactiveSigningMutex sync.Mutex
+
+// SignBinary  signs the given binary as each of the signing identities in the metadata file, possibly overridden by, or added to by, information in ~/.gomason
 func (g *Gomason) SignBinary(meta Metadata, binary string) (err error) {
 	logrus.Debugf("Preparing to sign file %s", binary)
 
-	// pull signing info out of metadata file
-	signInfo := meta.SignInfo
-	signProg := signInfo.Program
-	if signProg == "" {
-		signProg = defaultSigningProgram
+	identities, err := g.SigningIdentities(meta)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		logrus.Debugf("Signing program is %s", identity.Program)
+
+		signer, err := GetSigner(identity.Program)
+		if err != nil {
+			return err
+		}
+
+		logrus.Debugf("Signing %s with identity %s.", binary, identity.Email)
+
+		err = signWithTurns(signer, identity, meta, binary)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("failed to sign with %q", identity.Program))
+			return err
+		}
+	}
+
+	return err
+}
+
+// signWithTurns signs binary as the identity.  Files are signed concurrently, so signing that might ask whoever's at the terminal for a passphrase waits its turn, rather than having several prompts fight over it.
+func signWithTurns(signer Signer, identity SigningIdentity, meta Metadata, binary string) (err error) {
+	if SignsInteractively(signer, identity) {
+		interactiveSigningMutex.Lock()
+		defer interactiveSigningMutex.Unlock()
+	}
+
+	return signer.Sign(identity, meta, binary, identity.SignatureFile(binary))
+}
+
+// SignsInteractively says whether signing as the identity might ask whoever's at the terminal for something.  A passphrasefunc gets the user's stdin, so it might, whichever program it's for.  gpg does if there's no passphrasefunc to feed it the passphrase.  Plugins get the user's stdin too, so they might.
+func SignsInteractively(signer Signer, identity SigningIdentity) bool {
+	if identity.PassphraseFunc != "" {
+		return true
+	}
+
+	switch signer.(type) {
+	case GPGSigner, ExecSigner:
+		return true
+	}
+
+	return false
+}
+
+// SigningIdentities returns the identities artifacts are signed as, from the signing section of the metadata file.  If it doesn't list any identities, there's a single, unnamed one made from its program, email and keyring.
+func (m Metadata) SigningIdentities() (identities []SigningIdentity, err error) {
+	signInfo := m.SignInfo
+
+	program := signInfo.Program
+	if program == "" {
+		program = defaultSigningProgram
+	}
+
+	if len(signInfo.Identities) == 0 {
+		identities = []SigningIdentity{
+			{
+				Program: program,
+				Email:   signInfo.Email,
+				Keyring: signInfo.Keyring,
+				Trustdb: signInfo.Trustdb,
+			},
+		}
+
+		return identities, err
+	}
+
+	identities = make([]SigningIdentity, 0)
+	seen := make(map[string]bool)
+
+	for _, identity := range signInfo.Identities {
+		if !identityNameRegex.MatchString(identity.Name) {
+			err = errors.New(fmt.Sprintf("invalid signing identity name %q in %s.  Names are used in signature file names, so they can only have letters, numbers, '-' and '_'", identity.Name, METADATA_FILENAME))
+			return identities, err
+		}
+
+		if seen[identity.Name] {
+			err = errors.New(fmt.Sprintf("signing identity %q is in %s more than once", identity.Name, METADATA_FILENAME))
+			return identities, err
+		}
+
+		seen[identity.Name] = true
+
+		if identity.Program == "" {
+			identity.Program = program
+		}
+
+		identities = append(identities, identity)
 	}
 
-	logrus.Debugf("Signing program is %s", signProg)
+	return identities, err
+}
 
-	signEntity := signInfo.Email
+// SigningIdentities returns the identities SignBinary will sign as.  Information in ~/.gomason overrides the metadata file.  Identities in ~/.gomason that aren't in the metadata file, such as the releaser's personal key, are signed as too.
+func (g *Gomason) SigningIdentities(meta Metadata) (identities []SigningIdentity, err error) {
+	identities, err = meta.SigningIdentities()
+	if err != nil {
+		return identities, err
+	}
 
 	config := g.Config
 
-	// email from .gomason overrides metadata
-	if config.User.Email != "" {
-		signEntity = config.User.Email
+	// without named identities, the 'user' and 'signing' sections of ~/.gomason apply to the one there is
+	if len(meta.SignInfo.Identities) == 0 {
+		identity := identities[0]
+
+		// email from .gomason overrides metadata
+		if config.User.Email != "" {
+			identity.Email = config.User.Email
+		}
+
+		identity = identity.WithOverrides(config.Signing)
+		identities[0] = identity
 	}
 
-	// program from .gomason overrides metadata
-	if config.Signing.Program != "" {
-		signProg = config.Signing.Program
+	for i, identity := range identities {
+		if overrides, ok := config.Identities[identity.Name]; ok && identity.Name != "" {
+			identities[i] = identity.WithOverrides(overrides)
+		}
 	}
 
-	if signEntity == "" {
-		err = fmt.Errorf("Cannot sign without a signing entity (email).\n\nSet 'signing' section in metadata file, or create ~/.gomason with the appropriate content.\n\nSee https://github.com/nikogura/gomason#config-reference for details.\n\n")
+	names := make([]string, 0)
 
-		return err
+	for name := range config.Identities {
+		names = append(names, name)
 	}
 
-	logrus.Debugf("Signing %s with identity %s.", binary, signEntity)
+	sort.Strings(names)
 
-	switch signProg {
-	// insert other signing types here
-	default:
-		logrus.Debug("Signing with default program.")
-		err = SignGPG(binary, signEntity, meta)
-		if err != nil {
-			err = errors.Wrap(err, fmt.Sprintf("failed to run %q", signProg))
-			return err
+	for _, name := range names {
+		known := false
+
+		for _, identity := range identities {
+			if identity.Name == name {
+				known = true
+			}
+		}
+
+		if known {
+			continue
 		}
+
+		if !identityNameRegex.MatchString(name) {
+			err = errors.New(fmt.Sprintf("invalid signing identity name %q in ~/.gomason.  Names are used in signature file names, so they can only have letters, numbers, '-' and '_'", name))
+			return identities, err
+		}
+
+		identity := SigningIdentity{
+			Name:    name,
+			Program: defaultSigningProgram,
+			Email:   config.User.Email,
+		}
+
+		identities = append(identities, identity.WithOverrides(config.Identities[name]))
 	}
 
-	return err
+	return identities, err
+}
+
+// WithOverrides returns the identity with anything set in the given section of ~/.gomason overriding it.
+func (i SigningIdentity) WithOverrides(overrides UserSignInfo) SigningIdentity {
+	if overrides.Program != "" {
+		i.Program = overrides.Program
+	}
+
+	if overrides.Email != "" {
+		i.Email = overrides.Email
+	}
+
+	if overrides.KeyFile != "" {
+		i.KeyFile = overrides.KeyFile
+	}
+
+	if overrides.CertFile != "" {
+		i.CertFile = overrides.CertFile
+	}
+
+	if overrides.PassphraseFunc != "" {
+		i.PassphraseFunc = overrides.PassphraseFunc
+	}
+
+	return i
+}
+
+// SignatureSuffix returns the suffix of this identity's signature files.  For named identities, it's '.<name>' followed by the suffix of the signing program's signatures, so that each identity's signature can be told apart.
+func (i SigningIdentity) SignatureSuffix() (suffix string) {
+	suffix = SignatureSuffix(i.Program)
+
+	if i.Name == "" {
+		return suffix
+	}
+
+	return fmt.Sprintf(".%s%s", i.Name, suffix)
+}
+
+// SignatureFile returns the name of this identity's signature of the given file.
+func (i SigningIdentity) SignatureFile(file string) (sigFile string) {
+	return file + i.SignatureSuffix()
 }
 
-// VerifyBinary will verify the signature of a signed binary.
+// ConfigSection returns the name of the section of ~/.gomason that configures this identity.
+func (i SigningIdentity) ConfigSection() string {
+	if i.Name == "" {
+		return "signing"
+	}
+
+	return fmt.Sprintf("signing.%s", i.Name)
+}
+
+// String describes the identity for humans.
+func (i SigningIdentity) String() string {
+	desc := i.Program
+
+	if i.Email != "" {
+		desc = fmt.Sprintf("%s as %s", desc, i.Email)
+	}
+
+	if i.Name != "" {
+		desc = fmt.Sprintf("%s (%s)", desc, i.Name)
+	}
+
+	return desc
+}
+
+// VerifyBinary will verify the signatures of a signed binary, made by each of the signing identities in the metadata file.
 func VerifyBinary(binary string, meta Metadata) (ok bool, err error) {
-	// pull signing info out of metadata file
-	signInfo := meta.SignInfo
-
-	signProg := signInfo.Program
-	if signProg == "" {
-		signProg = defaultSigningProgram
-	}
-	switch signProg {
-	// insert other signing types here
-	default:
-		logrus.Debugf("Verifying with default program.")
-		ok, err = VerifyGPG(binary, meta)
+	identities, err := meta.SigningIdentities()
+	if err != nil {
+		return ok, err
+	}
+
+	for _, identity := range identities {
+		signer, err := GetSigner(identity.Program)
+		if err != nil {
+			return false, err
+		}
+
+		ok, err = signer.Verify(identity, meta, binary, identity.SignatureFile(binary))
 		if err != nil {
-			err = errors.Wrapf(err, "failed to run %q", signProg)
+			err = errors.Wrapf(err, "failed to verify with %q", identity.Program)
+			return false, err
+		}
+
+		if !ok {
 			return ok, err
 		}
 	}
@@ -84,74 +295,127 @@ func VerifyBinary(binary string, meta Metadata) (ok bool, err error) {
 	return ok, err
 }
 
-// SignGPG signs a given binary with GPG using the given signing entity.
-func SignGPG(binary string, signingEntity string, meta Metadata) (err error) {
+// GPGSigner signs and verifies with the gpg binary.
+type GPGSigner struct{}
+
+// Sign signs binary with gpg.
+func (GPGSigner) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	if identity.Email == "" {
+		return ErrNoSigningEntity
+	}
+
+	return SignGPG(binary, sigFile, identity, meta)
+}
+
+// Verify verifies the signature of binary with gpg.
+func (GPGSigner) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (ok bool, err error) {
+	return VerifyGPG(binary, sigFile, identity, meta)
+}
+
+// SignatureSuffix returns '.asc'.
+func (GPGSigner) SignatureSuffix() string {
+	return GPGSignatureSuffix
+}
+
+// SignGPG signs a given binary with GPG as the given identity, writing the signature to sigFile.  If the identity has a passphrasefunc, the passphrase it returns is fed to gpg on a pipe, with loopback pinentry, so nobody needs to be at a keyboard.  Otherwise gpg, or its agent, asks for the passphrase, if it needs one.
+func SignGPG(binary string, sigFile string, identity SigningIdentity, meta Metadata) (err error) {
 	shellCmd, err := exec.LookPath("gpg")
 	if err != nil {
 		err = errors.Wrap(err, fmt.Sprintf("can't find signing program 'gpg' in path.  Is it installed?"))
 		return err
 	}
 
-	var cmd *exec.Cmd
+	// gpg -bau <email address> --output <sigfile> <file>
+	// -b detatch  -a ascii armor -u specify user
+	args := []string{"-bau", identity.Email, "--yes", "--output", sigFile, binary}
 
-	if keyring, ok := meta.Options["keyring"]; ok {
-		// use a custom keyring for testing
-		cmd = exec.Command(shellCmd, "--trustdb", meta.Options["trustdb"].(string), "--no-default-keyring", "--keyring", keyring.(string), "-bau", signingEntity, "--yes", binary)
+	var passphrase string
 
-	} else {
-		// gpg -bau <email address>  <file>
-		// -b detatch  -a ascii armor -u specify user
-		cmd = exec.Command(shellCmd, "-bau", signingEntity, "--yes", binary)
+	if identity.PassphraseFunc != "" {
+		passphrase, err = GetFunc(identity.PassphraseFunc)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to get passphrase from passphrasefunc")
+			return err
+		}
+
+		// the passphrase comes from fd 3, which is the first of cmd.ExtraFiles
+		args = append([]string{"--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3"}, args...)
 	}
 
+	cmd := gpgCommand(shellCmd, identity, meta, args...)
+
 	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 
-	cmd.Env = os.Environ()
+	if identity.PassphraseFunc == "" {
+		cmd.Stdin = os.Stdin
+
+		err = cmd.Run()
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("failed to run %q", shellCmd))
+		}
+
+		return err
+	}
+
+	// never on the command line, or in the environment, where other users could see it
+	passReader, passWriter, err := os.Pipe()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to make pipe for passphrase")
+		return err
+	}
+
+	defer passReader.Close()
+
+	cmd.ExtraFiles = []*os.File{passReader}
 
 	err = cmd.Start()
 	if err != nil {
+		_ = passWriter.Close()
 		err = errors.Wrap(err, fmt.Sprintf("failed to run %q", shellCmd))
+		return err
 	}
 
+	logrus.Debugf("Feeding passphrase from passphrasefunc to %s", shellCmd)
+
+	_, writeErr := passWriter.Write([]byte(passphrase + "\n"))
+	_ = passWriter.Close()
+
 	err = cmd.Wait()
 	if err != nil {
-		err = errors.Wrap(err, fmt.Sprintf("error waiting for  %q to finish", shellCmd))
+		err = errors.Wrap(err, fmt.Sprintf("failed to sign %s as %s with %q.  Is the passphrase from passphrasefunc right?", binary, identity.Email, shellCmd))
+		return err
+	}
+
+	if writeErr != nil {
+		err = errors.Wrapf(writeErr, "failed to feed passphrase to %q", shellCmd)
+		return err
 	}
 
 	return err
 }
 
-// VerifyGPG  Verifies signatures with gpg.
-func VerifyGPG(binary string, meta Metadata) (ok bool, err error) {
-	sigFile := fmt.Sprintf("%s.asc", binary)
-
+// VerifyGPG  Verifies the signature in sigFile with gpg, against the identity's keyring if it has one.
+func VerifyGPG(binary string, sigFile string, identity SigningIdentity, meta Metadata) (ok bool, err error) {
 	shellCmd, err := exec.LookPath("gpg")
 	if err != nil {
 		err = errors.Wrap(err, fmt.Sprintf("can't find signing program 'gpg' in path.  Is it installed?"))
 		return ok, err
 	}
 
-	var cmd *exec.Cmd
-
-	if keyring, ok := meta.Options["keyring"]; ok {
-		// use a custom keyring for testing
-		cmd = exec.Command(shellCmd, "--trustdb", meta.Options["trustdb"].(string), "--no-default-keyring", "--keyring", keyring.(string), "--verify", sigFile)
+	// gpg's machine readable status goes to stdout, so we can tell a bad signature from one by a key we don't have
+	// gpg --status-fd 1 --verify  <sigfile> <file>
+	cmd := gpgCommand(shellCmd, identity, meta, "--status-fd", "1", "--verify", sigFile, binary)
 
-	} else {
-		// gpg --verify  <file>
-		cmd = exec.Command(shellCmd, "--verify", sigFile)
-	}
+	var status bytes.Buffer
 
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = &status
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 
-	cmd.Env = os.Environ()
-
 	err = cmd.Run()
 	if err != nil {
+		err = GPGStatusError(binary, status.String(), err)
 		err = errors.Wrapf(err, "error verifying %s", sigFile)
 		return ok, err
 	}
@@ -160,3 +424,244 @@ func VerifyGPG(binary string, meta Metadata) (ok bool, err error) {
 
 	return ok, err
 }
+
+// Inspect verifies the signature of binary with gpg, and says which key made it.
+func (GPGSigner) Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (signer SignatureSigner, err error) {
+	return InspectGPG(binary, sigFile, identity, meta)
+}
+
+// InspectGPG verifies the signature in sigFile with gpg, and returns the key that made it.  Anyone can put any user id on a key, so its user ids that aren't revoked are only returned if gpg trusts the key fully or ultimately, going by a keyring and trustdb set in the metadata file.  Otherwise only its fingerprint says who it is.  Unlike VerifyGPG, a good signature by an expired or revoked key is reported as such, rather than as a good signature.
+func InspectGPG(binary string, sigFile string, identity SigningIdentity, meta Metadata) (signer SignatureSigner, err error) {
+	shellCmd, err := exec.LookPath("gpg")
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("can't find signing program 'gpg' in path.  Is it installed?"))
+		return signer, err
+	}
+
+	cmd := gpgCommand(shellCmd, identity, meta, "--status-fd", "1", "--verify", sigFile, binary)
+
+	var status bytes.Buffer
+
+	cmd.Stdout = &status
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	runErr := cmd.Run()
+
+	signer = ParseGPGSignerStatus(status.String())
+
+	// gpg only says VALIDSIG if the signature itself is good, whatever it thinks of the key
+	if signer.Fingerprint == "" {
+		if runErr == nil {
+			runErr = errors.New("gpg didn't say the signature is valid")
+		}
+
+		err = GPGStatusError(binary, status.String(), runErr)
+		err = errors.Wrapf(err, "error verifying %s", sigFile)
+		return signer, err
+	}
+
+	// gpg ultimately trusts every key its user makes, so its trust only means something if the trustdb isn't the signer's
+	if len(signer.Identities) > 0 && !gpgTrustPinned(identity, meta) {
+		logrus.Debugf("gpg's trust in key %s comes from the signer's own trustdb, so only its fingerprint counts", signer.Fingerprint)
+		signer.Identities = make([]string, 0)
+	}
+
+	if len(signer.Identities) == 0 {
+		logrus.Debugf("gpg doesn't trust key %s, so only its fingerprint counts", signer.Fingerprint)
+		return signer, err
+	}
+
+	cmd = gpgCommand(shellCmd, identity, meta, "--with-colons", "--list-keys", signer.Fingerprint)
+
+	var listing bytes.Buffer
+
+	cmd.Stdout = &listing
+	cmd.Stderr = os.Stderr
+
+	// the user id from the status is enough to go on, so failing to get the others isn't fatal
+	listErr := cmd.Run()
+	if listErr != nil {
+		logrus.Debugf("failed listing user ids of gpg key %s: %s", signer.Fingerprint, listErr)
+		return signer, err
+	}
+
+	for _, uid := range ParseGPGUserIDs(listing.String()) {
+		known := false
+
+		for _, identity := range signer.Identities {
+			if identity == uid {
+				known = true
+			}
+		}
+
+		if !known {
+			signer.Identities = append(signer.Identities, uid)
+		}
+	}
+
+	return signer, err
+}
+
+// ParseGPGSignerStatus reads who made a signature from the output of gpg's --status-fd when verifying it.  The fingerprint is that of the primary key, and is only set if gpg says the signature is valid.  The user id is only taken as an identity if gpg says it trusts the key fully or ultimately.
+func ParseGPGSignerStatus(status string) (signer SignatureSigner) {
+	signer.Identities = make([]string, 0)
+
+	uids := make([]string, 0)
+	trusted := false
+
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(fields) == 0 {
+			continue
+		}
+
+		// older versions of gpg don't say anything after the trust level
+		if fields[0] == "TRUST_FULLY" || fields[0] == "TRUST_ULTIMATE" {
+			trusted = true
+			continue
+		}
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "GOODSIG", "EXPKEYSIG", "REVKEYSIG":
+			if len(fields) > 2 {
+				uids = append(uids, strings.Join(fields[2:], " "))
+			}
+
+			signer.Expired = signer.Expired || fields[0] == "EXPKEYSIG"
+			signer.Revoked = signer.Revoked || fields[0] == "REVKEYSIG"
+
+		case "VALIDSIG":
+			// VALIDSIG <fpr> <date> <timestamp> <expires> <version> <reserved> <pubkey-algo> <hash-algo> <class> <primary-fpr>
+			signer.Fingerprint = fields[1]
+			if len(fields) > 10 {
+				signer.Fingerprint = fields[10]
+			}
+
+		}
+	}
+
+	if trusted {
+		signer.Identities = append(signer.Identities, uids...)
+	}
+
+	return signer
+}
+
+// ParseGPGUserIDs reads the user ids that aren't revoked from the output of 'gpg --with-colons --list-keys'.
+func ParseGPGUserIDs(listing string) (uids []string) {
+	uids = make([]string, 0)
+
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 || fields[0] != "uid" || fields[1] == "r" {
+			continue
+		}
+
+		uids = append(uids, strings.ReplaceAll(fields[9], `\x3a`, ":"))
+	}
+
+	return uids
+}
+
+// gpgCommand makes a gpg command with the given args.  If the identity has a keyring, only that keyring is used, and if it has a trustdb, that's used instead of the user's.  If the metadata names a keyring in its options, as the tests do, that's used instead, along with any trustdb named in the options.
+func gpgCommand(shellCmd string, identity SigningIdentity, meta Metadata, args ...string) (cmd *exec.Cmd) {
+	keyring, trustdb := gpgKeyringAndTrustdb(identity, meta)
+
+	if trustdb != "" {
+		args = append([]string{"--trustdb", trustdb}, args...)
+	}
+
+	if keyring != "" {
+		args = append([]string{"--no-default-keyring", "--keyring", keyring}, args...)
+	}
+
+	cmd = exec.Command(shellCmd, args...)
+	cmd.Env = os.Environ()
+
+	return cmd
+}
+
+// gpgKeyringAndTrustdb returns the keyring and trustdb gpg is run with for the identity.  Either is "" if gpg's defaults are used.
+func gpgKeyringAndTrustdb(identity SigningIdentity, meta Metadata) (keyring string, trustdb string) {
+	keyring = identity.Keyring
+	trustdb = identity.Trustdb
+
+	// use a custom keyring for testing
+	if k, ok := meta.Options["keyring"].(string); ok && k != "" {
+		keyring = k
+		trustdb = ""
+
+		if t, ok := meta.Options["trustdb"].(string); ok && t != "" {
+			trustdb = t
+		}
+	}
+
+	return keyring, trustdb
+}
+
+// gpgTrustPinned says whether gpg checks signatures as the identity against a keyring and trustdb from the metadata file, rather than the signer's own.  Keyring and trustdb can't be set in ~/.gomason, but the metadata file could still name the user's trustdb.
+func gpgTrustPinned(identity SigningIdentity, meta Metadata) bool {
+	keyring, trustdb := gpgKeyringAndTrustdb(identity, meta)
+	if keyring == "" || trustdb == "" {
+		return false
+	}
+
+	home := os.Getenv("GNUPGHOME")
+	if home == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+
+		home = filepath.Join(userHome, ".gnupg")
+	}
+
+	// like keyrings, gpg looks for a trustdb without a slash in its name in its home directory
+	if !strings.Contains(trustdb, "/") {
+		trustdb = filepath.Join(home, trustdb)
+	}
+
+	pinned, err := filepath.Abs(trustdb)
+	if err != nil {
+		return false
+	}
+
+	users, err := filepath.Abs(filepath.Join(home, "trustdb.gpg"))
+	if err != nil {
+		return false
+	}
+
+	if pinned == users {
+		return false
+	}
+
+	// the same file by another name is still the user's
+	pinnedInfo, pinnedErr := os.Stat(pinned)
+	usersInfo, usersErr := os.Stat(users)
+
+	return pinnedErr != nil || usersErr != nil || !os.SameFile(pinnedInfo, usersInfo)
+}
+
+// GPGStatusError turns the output of gpg's --status-fd into a BadSignatureError or UnknownSignerError if it says that's what went wrong.  Otherwise runErr is returned.
+func GPGStatusError(binary string, status string, runErr error) (err error) {
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "BADSIG":
+			return BadSignatureError{File: binary, Reason: fmt.Sprintf("gpg says the signature by key %s is bad", fields[1])}
+		case "NO_PUBKEY":
+			return UnknownSignerError{File: binary, Signer: fmt.Sprintf("gpg key %s, which is not in the keyring", fields[1])}
+		}
+	}
+
+	return runErr
+}
@@ -0,0 +1,391 @@
+package gomason
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningIdentities(t *testing.T) {
+	inputs := []struct {
+		name     string
+		signInfo SignInfo
+		config   UserConfig
+		expected []SigningIdentity
+		errs     bool
+	}{
+		{
+			"legacy",
+			SignInfo{Program: SigningProgramGPG, Email: "tester@foo.com"},
+			UserConfig{
+				User:    UserInfo{Email: "releaser@foo.com"},
+				Signing: UserSignInfo{KeyFile: "/keys/secring.asc"},
+			},
+			[]SigningIdentity{
+				{Program: SigningProgramGPG, Email: "releaser@foo.com", KeyFile: "/keys/secring.asc"},
+			},
+			false,
+		},
+		{
+			"named",
+			SignInfo{
+				Program: SigningProgramMinisign,
+				Identities: []SigningIdentity{
+					{Name: "team", Keyring: "team.pub"},
+					{Name: "nik", Program: SigningProgramSSH, Email: "nik@foo.com", Keyring: "allowed_signers"},
+				},
+			},
+			UserConfig{
+				Signing: UserSignInfo{KeyFile: "/keys/not-for-named-identities"},
+				Identities: map[string]UserSignInfo{
+					"team": {KeyFile: "/keys/team.key", PassphraseFunc: "echo sekrit"},
+				},
+			},
+			[]SigningIdentity{
+				{Name: "team", Program: SigningProgramMinisign, Keyring: "team.pub", KeyFile: "/keys/team.key", PassphraseFunc: "echo sekrit"},
+				{Name: "nik", Program: SigningProgramSSH, Email: "nik@foo.com", Keyring: "allowed_signers"},
+			},
+			false,
+		},
+		{
+			"added in ~/.gomason",
+			SignInfo{
+				Identities: []SigningIdentity{
+					{Name: "team", Email: "releases@foo.com"},
+				},
+			},
+			UserConfig{
+				User: UserInfo{Email: "releaser@foo.com"},
+				Identities: map[string]UserSignInfo{
+					"personal": {},
+				},
+			},
+			[]SigningIdentity{
+				{Name: "team", Program: SigningProgramGPG, Email: "releases@foo.com"},
+				{Name: "personal", Program: SigningProgramGPG, Email: "releaser@foo.com"},
+			},
+			false,
+		},
+		{
+			"invalid name",
+			SignInfo{Identities: []SigningIdentity{{Name: "../team"}}},
+			UserConfig{},
+			nil,
+			true,
+		},
+		{
+			"duplicate name",
+			SignInfo{Identities: []SigningIdentity{{Name: "team"}, {Name: "team"}}},
+			UserConfig{},
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := testMetadataObj()
+			meta.SignInfo = tc.signInfo
+
+			g := Gomason{Config: tc.config}
+
+			identities, err := g.SigningIdentities(meta)
+			if tc.errs {
+				assert.NotNil(t, err, "Bad identities are an error")
+				return
+			}
+
+			assert.Nil(t, err, "No error getting identities")
+			assert.Equal(t, tc.expected, identities, "Identities meet expectations")
+		})
+	}
+}
+
+func TestSignVerifyMultipleIdentities(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	teamDir := filepath.Join(dir, "team")
+	nikDir := filepath.Join(dir, "nik")
+
+	for _, d := range []string{teamDir, nikDir} {
+		err = os.MkdirAll(d, 0755)
+		if err != nil {
+			t.Fatalf("Error creating %s: %s", d, err)
+		}
+	}
+
+	teamKey, teamPub := writeTestMinisignKeys(t, teamDir, "")
+	nikKey, nikPub := writeTestMinisignKeys(t, nikDir, "")
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	meta := testMetadataObj()
+	meta.Repository = "http://localhost:8081/artifactory/generic-local"
+	meta.SignInfo = SignInfo{
+		Program: SigningProgramMinisign,
+		Identities: []SigningIdentity{
+			{Name: "team", Keyring: teamPub},
+			{Name: "nik", Keyring: nikPub},
+		},
+	}
+
+	g := Gomason{
+		Config: UserConfig{
+			Identities: map[string]UserSignInfo{
+				"team": {KeyFile: teamKey},
+				"nik":  {KeyFile: nikKey},
+			},
+		},
+	}
+
+	err = g.SignBinary(meta, binary)
+	if err != nil {
+		t.Fatalf("Error signing %s: %s", binary, err)
+	}
+
+	for _, sigFile := range []string{binary + ".team.minisig", binary + ".nik.minisig"} {
+		_, err = os.Stat(sigFile)
+		assert.Nil(t, err, "%s written", sigFile)
+	}
+
+	ok, err := VerifyBinary(binary, meta)
+	assert.Nil(t, err, "No error verifying signatures")
+	assert.True(t, ok, "Signatures verify")
+
+	plan, err := g.PlanFile(meta, binary, true, true)
+	if err != nil {
+		t.Fatalf("Error planning: %s", err)
+	}
+
+	signatures := make([]string, 0)
+
+	for _, u := range plan.Uploads {
+		if u.Kind == UploadKindSignature {
+			signatures = append(signatures, u.Destination)
+		}
+	}
+
+	dst := "http://localhost:8081/artifactory/generic-local/testproject/0.1.0/linux/amd64/testproject"
+	assert.Equal(t, []string{dst + ".team.minisig", dst + ".nik.minisig"}, signatures, "Each identity's signature is uploaded")
+
+	// a signature by the wrong key for an identity fails verification, even if the others are good
+	err = os.WriteFile(binary+".nik.minisig", mustReadFile(t, binary+".team.minisig"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing signature: %s", err)
+	}
+
+	ok, err = VerifyBinary(binary, meta)
+	assert.False(t, ok, "Wrong signature doesn't verify")
+	assert.IsType(t, UnknownSignerError{}, VerificationFailure(err), "Failure is reported as what it is")
+}
+
+// mustReadFile reads a file, failing the test if it can't.
+func TestSignGPGPassphraseFunc(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	home, cleanup := testGPGHome(t)
+	defer cleanup()
+
+	email := "gomason-tester@foo.com"
+
+	generateTestGPGKey(t, home, email, "sekrit")
+
+	binary := filepath.Join(home, "testproject_linux_amd64")
+
+	err := os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	var logs bytes.Buffer
+
+	oldLevel := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	logrus.SetOutput(&logs)
+
+	defer func() {
+		logrus.SetLevel(oldLevel)
+		logrus.SetOutput(os.Stderr)
+	}()
+
+	// wrong first, as the agent remembers the passphrase once it's been right
+	inputs := []struct {
+		name           string
+		passphraseFunc string
+		errs           bool
+	}{
+		{"wrong passphrase", "echo wrong", true},
+		{"failing passphrasefunc", "exit 1", true},
+		{"passphrase", "echo sekrit", false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Remove(binary + GPGSignatureSuffix)
+
+			g := Gomason{Config: UserConfig{Signing: UserSignInfo{PassphraseFunc: tc.passphraseFunc}}}
+
+			meta := testMetadataObj()
+			meta.SignInfo = SignInfo{Program: SigningProgramGPG, Email: email}
+
+			err := g.SignBinary(meta, binary)
+			if tc.errs {
+				assert.NotNil(t, err, "Signing fails")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			ok, err := VerifyBinary(binary, meta)
+			assert.Nil(t, err, "No error verifying signature")
+			assert.True(t, ok, "Signature verifies")
+		})
+	}
+
+	assert.NotContains(t, logs.String(), "sekrit", "Passphrase isn't logged")
+}
+
+// testGPGHome points GNUPGHOME at a new directory, so the tests have gpg to themselves.  The cleanup function stops its agent, and puts GNUPGHOME back.
+func testGPGHome(t *testing.T) (home string, cleanup func()) {
+	// gpg-agent's socket lives in here, and socket paths can't be very long
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+
+	oldHome, hadHome := os.LookupEnv("GNUPGHOME")
+	_ = os.Setenv("GNUPGHOME", home)
+
+	cleanup = func() {
+		_ = exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+
+		if hadHome {
+			_ = os.Setenv("GNUPGHOME", oldHome)
+		} else {
+			_ = os.Unsetenv("GNUPGHOME")
+		}
+
+		_ = os.RemoveAll(home)
+	}
+
+	return home, cleanup
+}
+
+// generateTestGPGKey makes a signing key for email in the gpg home directory, which gpg ultimately trusts, as it does any key its user makes.  Returns the key's fingerprint.
+func generateTestGPGKey(t *testing.T, home string, email string, passphrase string) (fingerprint string) {
+	keyParams := filepath.Join(home, "keyparams")
+
+	err := os.WriteFile(keyParams, []byte(fmt.Sprintf(`%%no-ask-passphrase
+Key-Type: eddsa
+Key-Curve: ed25519
+Key-Usage: sign
+Name-Real: Gomason Tester
+Name-Email: %s
+Expire-Date: 0
+Passphrase: %s
+%%commit
+`, email, passphrase)), 0600)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", keyParams, err)
+	}
+
+	out, err := exec.Command("gpg", "--batch", "--pinentry-mode", "loopback", "--gen-key", keyParams).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error generating gpg key: %s: %s", err, out)
+	}
+
+	out, err = exec.Command("gpg", "--with-colons", "--list-keys", email).Output()
+	if err != nil {
+		t.Fatalf("Error listing gpg key: %s", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 9 && fields[0] == "fpr" {
+			return fields[9]
+		}
+	}
+
+	t.Fatalf("No fingerprint for %s in %s", email, out)
+
+	return fingerprint
+}
+
+func mustReadFile(t *testing.T, file string) []byte {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", file, err)
+	}
+
+	return content
+}
+
+func TestGPGCommand(t *testing.T) {
+	inputs := []struct {
+		name     string
+		identity SigningIdentity
+		options  map[string]interface{}
+		args     []string
+	}{
+		{"default keyring", SigningIdentity{}, nil, []string{"gpg", "--verify"}},
+		{"identity keyring", SigningIdentity{Name: "team", Keyring: "keys/team.gpg"}, nil, []string{"gpg", "--no-default-keyring", "--keyring", "keys/team.gpg", "--verify"}},
+		{"identity trustdb", SigningIdentity{Name: "team", Keyring: "keys/team.gpg", Trustdb: "keys/trustdb.gpg"}, nil, []string{"gpg", "--no-default-keyring", "--keyring", "keys/team.gpg", "--trustdb", "keys/trustdb.gpg", "--verify"}},
+		{"test keyring", SigningIdentity{Keyring: "keys/team.gpg"}, map[string]interface{}{"keyring": "/tmp/pubring.gpg", "trustdb": "/tmp/trustdb.gpg"}, []string{"gpg", "--no-default-keyring", "--keyring", "/tmp/pubring.gpg", "--trustdb", "/tmp/trustdb.gpg", "--verify"}},
+		{"test keyring without trustdb", SigningIdentity{}, map[string]interface{}{"keyring": "/tmp/pubring.gpg"}, []string{"gpg", "--no-default-keyring", "--keyring", "/tmp/pubring.gpg", "--verify"}},
+		{"test keyring that isn't a string", SigningIdentity{}, map[string]interface{}{"keyring": 42, "trustdb": 42}, []string{"gpg", "--verify"}},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := testMetadataObj()
+			meta.Options = tc.options
+
+			cmd := gpgCommand("gpg", tc.identity, meta, "--verify")
+			assert.Equal(t, tc.args, cmd.Args, "Args meet expectations")
+		})
+	}
+}
+
+func TestSignsInteractively(t *testing.T) {
+	inputs := []struct {
+		name        string
+		signer      Signer
+		identity    SigningIdentity
+		interactive bool
+	}{
+		{"gpg asking for a passphrase", GPGSigner{}, SigningIdentity{Program: SigningProgramGPG}, true},
+		{"gpg with a passphrasefunc", GPGSigner{}, SigningIdentity{Program: SigningProgramGPG, PassphraseFunc: "pass show gpg"}, true},
+		{"plugin", ExecSigner{Name: "test"}, SigningIdentity{Program: "test"}, true},
+		{"openpgp", OpenPGPSigner{}, SigningIdentity{Program: SigningProgramOpenPGP}, false},
+		{"openpgp with a passphrasefunc", OpenPGPSigner{}, SigningIdentity{Program: SigningProgramOpenPGP, PassphraseFunc: "read -s p; echo $p"}, true},
+		{"minisign with a passphrasefunc", MinisignSigner{}, SigningIdentity{Program: SigningProgramMinisign, PassphraseFunc: "op read op://team/minisign"}, true},
+		{"ssh", SSHSigner{}, SigningIdentity{Program: SigningProgramSSH}, false},
+		{"ssh with a passphrasefunc", SSHSigner{}, SigningIdentity{Program: SigningProgramSSH, PassphraseFunc: "echo sekrit"}, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.interactive, SignsInteractively(tc.signer, tc.identity), "Interactive meets expectations")
+		})
+	}
+}
@@ -0,0 +1,707 @@
+package gomason
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SigningProgramSSH signs with ssh keys, from the ssh-agent or a key file, writing .sig files that 'ssh-keygen -Y verify' can check.
+const SigningProgramSSH = "ssh"
+
+// SSHSignatureSuffix is the suffix of ssh signature files, the same as ssh-keygen uses.
+const SSHSignatureSuffix = ".sig"
+
+// SSHSignatureNamespace is the namespace signatures are made in.  'file' is what ssh-keygen recommends for signing files.
+const SSHSignatureNamespace = "file"
+
+func init() {
+	signersMap[SigningProgramSSH] = SSHSigner{}
+}
+
+const (
+	sshsigMagic    = "SSHSIG"
+	sshsigVersion  = 1
+	sshsigHashAlg  = "sha512"
+	sshsigPemType  = "SSH SIGNATURE"
+	sshAuthSockEnv = "SSH_AUTH_SOCK"
+)
+
+// SSHSignature is an SSHSIG signature, as made by 'ssh-keygen -Y sign'.
+type SSHSignature struct {
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+	Signature     *ssh.Signature
+}
+
+// SSHSigner signs and verifies with ssh keys.  Like minisign keys, ssh keys aren't tied to an identity, so no signing entity is needed to sign.
+type SSHSigner struct{}
+
+// Sign signs binary with an ssh key from the agent, or the identity's key file from ~/.gomason.
+func (SSHSigner) Sign(identity SigningIdentity, meta Metadata, binary string, sigFile string) (err error) {
+	return SignSSH(identity, binary, sigFile)
+}
+
+// Verify verifies the signature of binary against the identity's allowed_signers file from the metadata file.
+func (SSHSigner) Verify(identity SigningIdentity, meta Metadata, binary string, sigFile string) (ok bool, err error) {
+	return VerifySSH(identity, binary, sigFile)
+}
+
+// SignatureSuffix returns '.sig'.
+func (SSHSigner) SignatureSuffix() string {
+	return SSHSignatureSuffix
+}
+
+// sshsigBlob is the wire format of an SSHSIG signature, after the magic preamble.
+type sshsigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshsigSignedData is what actually gets signed, after the magic preamble.
+type sshsigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// SignSSH signs a given binary with an ssh key, writing the signature to sigFile.  If the identity's 'keyfile' is a private key, it's used directly.  If it's a public key, the matching key in the ssh-agent is used.  If it's not set at all, the first key in the ssh-agent is used.
+func SignSSH(identity SigningIdentity, binary string, sigFile string) (err error) {
+	signer, err := SSHSigningKey(identity)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", binary)
+		return err
+	}
+
+	sig, err := SSHSign(signer, data, SSHSignatureNamespace)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sign %s", binary)
+		return err
+	}
+
+	err = os.WriteFile(sigFile, sig.Armor(), 0644)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write %s", sigFile)
+		return err
+	}
+
+	logrus.Debugf("Signed %s with ssh key %s", binary, ssh.FingerprintSHA256(signer.PublicKey()))
+
+	return err
+}
+
+// SSHSigningKey returns the ssh key to sign as the given identity with, per its section of ~/.gomason.
+func SSHSigningKey(identity SigningIdentity) (signer ssh.Signer, err error) {
+	keyFile := identity.KeyFile
+
+	var wanted ssh.PublicKey
+
+	if keyFile != "" {
+		keyBytes, readErr := os.ReadFile(keyFile)
+		if readErr != nil {
+			err = errors.Wrapf(readErr, "failed to read %s", keyFile)
+			return signer, err
+		}
+
+		// a public key means 'use the matching key from the agent', just like ssh-keygen
+		pub, _, _, _, pubErr := ssh.ParseAuthorizedKey(keyBytes)
+		if pubErr != nil {
+			return sshPrivateKeySigner(identity, keyFile, keyBytes)
+		}
+
+		wanted = pub
+	}
+
+	socket := os.Getenv(sshAuthSockEnv)
+	if socket == "" {
+		err = errors.New(fmt.Sprintf("signing with ssh needs either a running ssh-agent, or 'keyfile' set to a private key in the '%s' section of ~/.gomason.  %s is not set", identity.ConfigSection(), sshAuthSockEnv))
+		return signer, err
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to connect to ssh-agent at %s", socket)
+		return signer, err
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get keys from ssh-agent")
+		return signer, err
+	}
+
+	for _, s := range signers {
+		if wanted == nil || bytes.Equal(s.PublicKey().Marshal(), wanted.Marshal()) {
+			return s, err
+		}
+	}
+
+	if wanted != nil {
+		err = errors.New(fmt.Sprintf("ssh-agent doesn't have the key in %s (%s)", keyFile, ssh.FingerprintSHA256(wanted)))
+		return signer, err
+	}
+
+	err = errors.New("ssh-agent has no keys")
+
+	return signer, err
+}
+
+// sshPrivateKeySigner parses a private key read from keyFile.  If it's encrypted, the passphrase is gotten from the identity's passphrasefunc.
+func sshPrivateKeySigner(identity SigningIdentity, keyFile string, keyBytes []byte) (signer ssh.Signer, err error) {
+	signer, err = ssh.ParsePrivateKey(keyBytes)
+	if err == nil {
+		return signer, err
+	}
+
+	if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+		err = errors.Wrapf(err, "failed to parse ssh key in %s", keyFile)
+		return signer, err
+	}
+
+	if identity.PassphraseFunc == "" {
+		err = errors.New(fmt.Sprintf("ssh key in %s is encrypted, and there's no 'passphrasefunc' in the '%s' section of ~/.gomason to get the passphrase from", keyFile, identity.ConfigSection()))
+		return signer, err
+	}
+
+	passphrase, err := GetFunc(identity.PassphraseFunc)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get passphrase from passphrasefunc")
+		return signer, err
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to decrypt ssh key in %s", keyFile)
+		return signer, err
+	}
+
+	return signer, err
+}
+
+// SSHSign makes an SSHSIG signature of data in the given namespace.
+func SSHSign(signer ssh.Signer, data []byte, namespace string) (sig SSHSignature, err error) {
+	hash := sha512.Sum512(data)
+
+	signedData := append([]byte(sshsigMagic), ssh.Marshal(sshsigSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: sshsigHashAlg,
+		Hash:          hash[:],
+	})...)
+
+	var signature *ssh.Signature
+
+	// RSA signatures have to use sha512, not the default sha1.  Agent signers handle that themselves when asked for rsa-sha2-512.
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = signer.Sign(rand.Reader, signedData)
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to sign")
+		return sig, err
+	}
+
+	sig = SSHSignature{
+		PublicKey:     signer.PublicKey(),
+		Namespace:     namespace,
+		HashAlgorithm: sshsigHashAlg,
+		Signature:     signature,
+	}
+
+	return sig, err
+}
+
+// Armor returns the signature in the armored format ssh-keygen uses.
+func (s SSHSignature) Armor() []byte {
+	blob := append([]byte(sshsigMagic), ssh.Marshal(sshsigBlob{
+		Version:       sshsigVersion,
+		PublicKey:     s.PublicKey.Marshal(),
+		Namespace:     s.Namespace,
+		HashAlgorithm: s.HashAlgorithm,
+		Signature:     ssh.Marshal(s.Signature),
+	})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: sshsigPemType, Bytes: blob})
+}
+
+// ParseSSHSignature parses an armored SSHSIG signature.
+func ParseSSHSignature(content []byte) (sig SSHSignature, err error) {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != sshsigPemType {
+		err = errors.New("not an ssh signature")
+		return sig, err
+	}
+
+	if !bytes.HasPrefix(block.Bytes, []byte(sshsigMagic)) {
+		err = errors.New("ssh signature is missing its magic preamble")
+		return sig, err
+	}
+
+	var blob sshsigBlob
+
+	err = ssh.Unmarshal(block.Bytes[len(sshsigMagic):], &blob)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse ssh signature")
+		return sig, err
+	}
+
+	if blob.Version != sshsigVersion {
+		err = errors.New(fmt.Sprintf("unsupported ssh signature version %d", blob.Version))
+		return sig, err
+	}
+
+	sig.PublicKey, err = ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse public key in ssh signature")
+		return sig, err
+	}
+
+	sig.Signature = new(ssh.Signature)
+
+	err = ssh.Unmarshal(blob.Signature, sig.Signature)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse ssh signature")
+		return sig, err
+	}
+
+	sig.Namespace = blob.Namespace
+	sig.HashAlgorithm = blob.HashAlgorithm
+
+	return sig, err
+}
+
+// Verify checks that the signature is a good one of data, in the given namespace.
+func (s SSHSignature) Verify(data []byte, namespace string) (err error) {
+	if s.Namespace != namespace {
+		err = errors.New(fmt.Sprintf("signature is in namespace %q, not %q", s.Namespace, namespace))
+		return err
+	}
+
+	var hash []byte
+
+	switch s.HashAlgorithm {
+	case "sha512":
+		sum := sha512.Sum512(data)
+		hash = sum[:]
+	case "sha256":
+		sum := sha256.Sum256(data)
+		hash = sum[:]
+	default:
+		err = errors.New(fmt.Sprintf("unsupported hash algorithm %q", s.HashAlgorithm))
+		return err
+	}
+
+	signedData := append([]byte(sshsigMagic), ssh.Marshal(sshsigSignedData{
+		Namespace:     s.Namespace,
+		HashAlgorithm: s.HashAlgorithm,
+		Hash:          hash,
+	})...)
+
+	err = s.PublicKey.Verify(signedData, s.Signature)
+	if err != nil {
+		err = errors.Wrapf(err, "signature does not match")
+		return err
+	}
+
+	return err
+}
+
+// AllowedSigner is an entry in an ssh allowed_signers file.  If CertAuthority is set, PublicKey is a certificate authority, trusted to certify the keys of the principals.
+type AllowedSigner struct {
+	Principals    []string
+	PublicKey     ssh.PublicKey
+	CertAuthority bool
+	Namespaces    []string
+	ValidAfter    time.Time
+	ValidBefore   time.Time
+}
+
+// ParseAllowedSigners parses an ssh allowed_signers file, as described in ssh-keygen(1).
+func ParseAllowedSigners(content []byte) (signers []AllowedSigner, err error) {
+	signers = make([]AllowedSigner, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			err = errors.New(fmt.Sprintf("invalid allowed signer on line %d", lineNum))
+			return signers, err
+		}
+
+		pub, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(fields[1])))
+		if err != nil {
+			err = errors.Wrapf(err, "invalid key on line %d", lineNum)
+			return signers, err
+		}
+
+		signer := AllowedSigner{
+			Principals: strings.Split(strings.Trim(fields[0], `"`), ","),
+			PublicKey:  pub,
+			Namespaces: make([]string, 0),
+		}
+
+		for _, option := range options {
+			parts := strings.SplitN(option, "=", 2)
+			name := strings.ToLower(parts[0])
+			value := ""
+			if len(parts) == 2 {
+				value = strings.Trim(parts[1], `"`)
+			}
+
+			switch name {
+			case "cert-authority":
+				signer.CertAuthority = true
+			case "namespaces":
+				signer.Namespaces = strings.Split(value, ",")
+			case "valid-after":
+				signer.ValidAfter, err = parseAllowedSignerTime(value)
+				if err != nil {
+					err = errors.Wrapf(err, "invalid valid-after on line %d", lineNum)
+					return signers, err
+				}
+			case "valid-before":
+				signer.ValidBefore, err = parseAllowedSignerTime(value)
+				if err != nil {
+					err = errors.Wrapf(err, "invalid valid-before on line %d", lineNum)
+					return signers, err
+				}
+			}
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, err
+}
+
+// Allows returns true if this entry allows the given key to sign as the principal, in the namespace, at the given time.  An empty principal matches any principal.  Certificate authorities don't allow their own key to sign, only the keys they certify.
+func (a AllowedSigner) Allows(key ssh.PublicKey, principal string, namespace string, at time.Time) bool {
+	if a.CertAuthority || !bytes.Equal(a.PublicKey.Marshal(), key.Marshal()) {
+		return false
+	}
+
+	if principal != "" && !matchPatternList(principal, a.Principals) {
+		return false
+	}
+
+	return a.validFor(namespace, at)
+}
+
+// CertifiedPrincipals returns the principals of cert that this certificate authority vouches for it signing as, in the namespace, at the given time.  The certificate has to be a user certificate signed by this authority, and valid at that time.  Only principals named in the certificate, and matching this entry's principals, are returned.  An error says why none are.
+func (a AllowedSigner) CertifiedPrincipals(cert *ssh.Certificate, namespace string, at time.Time) (principals []string, err error) {
+	principals = make([]string, 0)
+
+	if !a.CertAuthority || !a.validFor(namespace, at) {
+		err = errors.New("not signed by a certificate authority in the allowed signers")
+		return principals, err
+	}
+
+	checker := ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(a.PublicKey.Marshal(), auth.Marshal())
+		},
+		Clock: func() time.Time {
+			return at
+		},
+	}
+
+	if cert.CertType != ssh.UserCert {
+		err = errors.New("not a user certificate")
+		return principals, err
+	}
+
+	if !checker.IsUserAuthority(cert.SignatureKey) {
+		err = errors.New(fmt.Sprintf("certificate is signed by %s, which is not a certificate authority in the allowed signers", ssh.FingerprintSHA256(cert.SignatureKey)))
+		return principals, err
+	}
+
+	// a certificate without principals would be good for anyone, so it's good for no-one
+	err = errors.New("certificate has no principals allowed by its certificate authority")
+
+	for _, principal := range cert.ValidPrincipals {
+		if !matchPatternList(principal, a.Principals) {
+			continue
+		}
+
+		checkErr := checker.CheckCert(principal, cert)
+		if checkErr != nil {
+			err = checkErr
+			continue
+		}
+
+		principals = append(principals, principal)
+	}
+
+	if len(principals) > 0 {
+		err = nil
+	}
+
+	return principals, err
+}
+
+// validFor says whether this entry applies to the namespace, at the given time.
+func (a AllowedSigner) validFor(namespace string, at time.Time) bool {
+	if len(a.Namespaces) > 0 && !matchPatternList(namespace, a.Namespaces) {
+		return false
+	}
+
+	if !a.ValidAfter.IsZero() && at.Before(a.ValidAfter) {
+		return false
+	}
+
+	if !a.ValidBefore.IsZero() && at.After(a.ValidBefore) {
+		return false
+	}
+
+	return true
+}
+
+// AllowedSSHPrincipals returns the principals the allowed signers let key sign as, in the namespace, at the given time.  Plain keys are looked for in the allowed signers, and can sign as principal, if they're allowed to.  Certificates have to be signed by a certificate authority in the allowed signers, and can sign as those of their principals it vouches for.  If principal isn't empty, it's the only one that counts.  An error means key isn't allowed to sign at all.
+func AllowedSSHPrincipals(allowed []AllowedSigner, key ssh.PublicKey, principal string, namespace string, at time.Time) (principals []string, err error) {
+	principals = make([]string, 0)
+
+	cert, isCert := key.(*ssh.Certificate)
+	if !isCert {
+		for _, a := range allowed {
+			if a.Allows(key, principal, namespace, at) {
+				if principal != "" {
+					principals = append(principals, principal)
+				}
+
+				return principals, err
+			}
+		}
+
+		err = errors.New("key is not an allowed signer")
+		return principals, err
+	}
+
+	err = errors.New("there are no certificate authorities in the allowed signers")
+
+	for _, a := range allowed {
+		if !a.CertAuthority {
+			continue
+		}
+
+		certified, certErr := a.CertifiedPrincipals(cert, namespace, at)
+		if certErr != nil {
+			err = certErr
+			continue
+		}
+
+		for _, p := range certified {
+			if principal == "" || p == principal {
+				principals = append(principals, p)
+			}
+		}
+	}
+
+	if len(principals) > 0 {
+		return principals, nil
+	}
+
+	if principal != "" && err == nil {
+		err = errors.New(fmt.Sprintf("certificate isn't good for signing as %s", principal))
+	}
+
+	err = errors.Wrapf(err, "certificate is not trusted")
+
+	return principals, err
+}
+
+// sshCertificateExpired says whether key is a certificate that has expired by the given time.
+func sshCertificateExpired(key ssh.PublicKey, at time.Time) bool {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.ValidBefore == ssh.CertTimeInfinity {
+		return false
+	}
+
+	return at.Unix() >= int64(cert.ValidBefore)
+}
+
+// VerifySSH verifies a binary's ssh signature in sigFile against the identity's allowed_signers file, named as 'keyring' in the metadata file.  If the identity has an email, the signer must be allowed to sign as that principal.
+func VerifySSH(identity SigningIdentity, binary string, sigFile string) (ok bool, err error) {
+	allowed, sig, err := checkSSHSignature(identity, binary, sigFile)
+	if err != nil {
+		return ok, err
+	}
+
+	_, err = AllowedSSHPrincipals(allowed, sig.PublicKey, identity.Email, SSHSignatureNamespace, time.Now())
+	if err != nil {
+		err = UnknownSignerError{File: binary, Signer: fmt.Sprintf("ssh key %s, which %s doesn't allow: %s", ssh.FingerprintSHA256(sig.PublicKey), identity.Keyring, err)}
+		return ok, err
+	}
+
+	logrus.Debugf("Good signature on %s from ssh key %s", binary, ssh.FingerprintSHA256(sig.PublicKey))
+
+	ok = true
+
+	return ok, err
+}
+
+// Inspect verifies the signature of binary, and says which ssh key made it.
+func (SSHSigner) Inspect(identity SigningIdentity, meta Metadata, binary string, sigFile string) (signer SignatureSigner, err error) {
+	return InspectSSH(identity, binary, sigFile)
+}
+
+// InspectSSH verifies a binary's ssh signature in sigFile against the identity's allowed_signers file, and returns the fingerprint of the key that made it, with the principals the allowed signers let it sign as.  A plain key's only principal is the identity's email, if it's allowed to sign as it.  A certificate's principals are those of its principals that a certificate authority in the allowed signers vouches for, and its fingerprint is that of its key.  A signature made with a certificate that has since expired is reported as such, rather than as an error.
+func InspectSSH(identity SigningIdentity, binary string, sigFile string) (signer SignatureSigner, err error) {
+	signer.Identities = make([]string, 0)
+
+	allowed, sig, err := checkSSHSignature(identity, binary, sigFile)
+	if err != nil {
+		return signer, err
+	}
+
+	key := sig.PublicKey
+	signer.Fingerprint = ssh.FingerprintSHA256(key)
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		signer.Fingerprint = ssh.FingerprintSHA256(cert.Key)
+	}
+
+	at := time.Now()
+
+	// an expired certificate is checked as of just before it expired, so it can be refused for being expired, rather than for being unknown
+	if sshCertificateExpired(key, at) {
+		signer.Expired = true
+		at = time.Unix(int64(key.(*ssh.Certificate).ValidBefore)-1, 0)
+	}
+
+	principals, err := AllowedSSHPrincipals(allowed, key, identity.Email, SSHSignatureNamespace, at)
+	if err != nil {
+		err = UnknownSignerError{File: binary, Signer: fmt.Sprintf("ssh key %s, which %s doesn't allow: %s", signer.Fingerprint, identity.Keyring, err)}
+		return signer, err
+	}
+
+	signer.Identities = append(signer.Identities, principals...)
+
+	return signer, err
+}
+
+// checkSSHSignature reads the identity's allowed_signers file, and checks that the signature in sigFile is a good one of binary.  Who made it is left to the caller.
+func checkSSHSignature(identity SigningIdentity, binary string, sigFile string) (allowed []AllowedSigner, sig SSHSignature, err error) {
+	allowedSignersFile := identity.Keyring
+	if allowedSignersFile == "" {
+		err = errors.New(fmt.Sprintf("verifying with ssh needs 'keyring' set to an allowed_signers file for %s in the 'signing' section of the metadata file", identity))
+		return allowed, sig, err
+	}
+
+	allowedBytes, err := os.ReadFile(allowedSignersFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", allowedSignersFile)
+		return allowed, sig, err
+	}
+
+	allowed, err = ParseAllowedSigners(allowedBytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", allowedSignersFile)
+		return allowed, sig, err
+	}
+
+	sigBytes, err := os.ReadFile(sigFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", sigFile)
+		return allowed, sig, err
+	}
+
+	sig, err = ParseSSHSignature(sigBytes)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse %s", sigFile)
+		return allowed, sig, err
+	}
+
+	data, err := os.ReadFile(binary)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", binary)
+		return allowed, sig, err
+	}
+
+	err = sig.Verify(data, SSHSignatureNamespace)
+	if err != nil {
+		err = BadSignatureError{File: binary, Reason: err.Error()}
+		return allowed, sig, err
+	}
+
+	return allowed, sig, err
+}
+
+// parseAllowedSignerTime parses times in allowed_signers files, which are YYYYMMDD or YYYYMMDDHHMM[SS], in local time unless followed by a Z.
+func parseAllowedSignerTime(value string) (t time.Time, err error) {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(value) == len(layout) {
+			return time.ParseInLocation(layout, value, loc)
+		}
+	}
+
+	err = errors.New(fmt.Sprintf("invalid time %q", value))
+
+	return t, err
+}
+
+// matchPatternList matches a value against a list of ssh style patterns, with '*' and '?' wildcards.  Patterns starting with '!' negate a match.
+func matchPatternList(value string, patterns []string) bool {
+	matched := false
+
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		// path.Match treats brackets as character classes.  ssh patterns don't have those, so escape them.
+		escaped := strings.NewReplacer("[", `\[`, "]", `\]`).Replace(pattern)
+
+		ok, err := path.Match(escaped, value)
+		if err != nil || !ok {
+			continue
+		}
+
+		if negate {
+			return false
+		}
+
+		matched = true
+	}
+
+	return matched
+}
@@ -0,0 +1,410 @@
+package gomason
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeTestSSHKey generates an ssh key with ssh-keygen in dir, and returns the private and public key files.
+func writeTestSSHKey(t *testing.T, dir string, name string, keyType string, passphrase string) (keyFile string, pubFile string) {
+	sshKeygen, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	keyFile = filepath.Join(dir, name)
+	pubFile = keyFile + ".pub"
+
+	out, err := exec.Command(sshKeygen, "-q", "-t", keyType, "-N", passphrase, "-C", name, "-f", keyFile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error generating %s key: %s: %s", keyType, err, out)
+	}
+
+	return keyFile, pubFile
+}
+
+// writeTestAllowedSigners writes an allowed_signers file allowing the public key in pubFile to sign as principal.
+func writeTestAllowedSigners(t *testing.T, dir string, principal string, pubFile string) (allowedFile string) {
+	pub, err := os.ReadFile(pubFile)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", pubFile, err)
+	}
+
+	allowedFile = filepath.Join(dir, "allowed_signers")
+
+	err = os.WriteFile(allowedFile, []byte(fmt.Sprintf("# test signers\n%s %s", principal, pub)), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", allowedFile, err)
+	}
+
+	return allowedFile
+}
+
+// startTestAgent serves an in memory ssh-agent holding key on a socket in dir, and points SSH_AUTH_SOCK at it.
+func startTestAgent(t *testing.T, dir string, key interface{}) {
+	keyring := agent.NewKeyring()
+
+	err := keyring.Add(agent.AddedKey{PrivateKey: key})
+	if err != nil {
+		t.Fatalf("Error adding key to agent: %s", err)
+	}
+
+	socket := filepath.Join(dir, "agent.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error listening on %s: %s", socket, err)
+	}
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	oldSock, hadSock := os.LookupEnv(sshAuthSockEnv)
+	_ = os.Setenv(sshAuthSockEnv, socket)
+
+	t.Cleanup(func() {
+		if hadSock {
+			_ = os.Setenv(sshAuthSockEnv, oldSock)
+			return
+		}
+
+		_ = os.Unsetenv(sshAuthSockEnv)
+	})
+}
+
+func TestSignVerifySSH(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	edKey, edPub := writeTestSSHKey(t, dir, "id_ed25519", "ed25519", "")
+	encKey, encPub := writeTestSSHKey(t, dir, "id_encrypted", "ed25519", "sekrit")
+	rsaKey, rsaPub := writeTestSSHKey(t, dir, "id_rsa", "rsa", "")
+	_, otherPub := writeTestSSHKey(t, dir, "id_other", "ed25519", "")
+
+	// an agent holding a key that's only on disk as a public key
+	_, agentPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	agentSSHPub, err := ssh.NewPublicKey(agentPriv.Public())
+	if err != nil {
+		t.Fatalf("Error converting public key: %s", err)
+	}
+
+	agentPub := filepath.Join(dir, "id_agent.pub")
+
+	err = os.WriteFile(agentPub, ssh.MarshalAuthorizedKey(agentSSHPub), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", agentPub, err)
+	}
+
+	startTestAgent(t, dir, agentPriv)
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	inputs := []struct {
+		name           string
+		keyFile        string
+		passphraseFunc string
+		allowedPub     string
+		principal      string
+		signErr        bool
+		verifyErr      bool
+	}{
+		{"ed25519", edKey, "", edPub, "tester@foo.com", false, false},
+		{"encrypted", encKey, "echo sekrit", encPub, "tester@foo.com", false, false},
+		{"wrong passphrase", encKey, "echo wrong", encPub, "tester@foo.com", true, false},
+		{"no passphrase", encKey, "", encPub, "tester@foo.com", true, false},
+		{"rsa", rsaKey, "", rsaPub, "tester@foo.com", false, false},
+		{"agent by public key", agentPub, "", agentPub, "tester@foo.com", false, false},
+		{"agent first key", "", "", agentPub, "tester@foo.com", false, false},
+		{"agent missing key", edPub, "", edPub, "tester@foo.com", true, false},
+		{"principal wildcard", edKey, "", edPub, "*@foo.com", false, false},
+		{"wrong principal", edKey, "", edPub, "someone@bar.com", false, true},
+		{"unknown signer", edKey, "", otherPub, "tester@foo.com", false, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			g := Gomason{
+				Config: UserConfig{
+					Signing: UserSignInfo{Program: SigningProgramSSH, KeyFile: tc.keyFile, PassphraseFunc: tc.passphraseFunc},
+				},
+			}
+
+			allowedFile := writeTestAllowedSigners(t, dir, tc.principal, tc.allowedPub)
+
+			meta := testMetadataObj()
+			meta.SignInfo = SignInfo{Program: SigningProgramSSH, Email: "tester@foo.com", Keyring: allowedFile}
+
+			err := g.SignBinary(meta, binary)
+			if tc.signErr {
+				assert.NotNil(t, err, "Signing fails")
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error signing %s: %s", binary, err)
+			}
+
+			ok, err := VerifyBinary(binary, meta)
+			if tc.verifyErr {
+				assert.NotNil(t, err, "Verification fails")
+				assert.False(t, ok, "Signature doesn't verify")
+				return
+			}
+
+			assert.Nil(t, err, "No error verifying signature")
+			assert.True(t, ok, "Signature verifies")
+
+			// ssh-keygen should agree
+			data, err := os.Open(binary)
+			if err != nil {
+				t.Fatalf("Error opening %s: %s", binary, err)
+			}
+			defer data.Close()
+
+			cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowedFile, "-I", "tester@foo.com", "-n", SSHSignatureNamespace, "-s", binary+SSHSignatureSuffix)
+			cmd.Stdin = data
+
+			out, err := cmd.CombinedOutput()
+			assert.Nil(t, err, "ssh-keygen verifies the signature: %s", out)
+		})
+	}
+
+	// a signature made by ssh-keygen verifies, and stops verifying if the file changes
+	allowedFile := writeTestAllowedSigners(t, dir, "tester@foo.com", edPub)
+
+	out, err := exec.Command("ssh-keygen", "-Y", "sign", "-f", edKey, "-n", SSHSignatureNamespace, binary).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error signing with ssh-keygen: %s: %s", err, out)
+	}
+
+	identity := SigningIdentity{Program: SigningProgramSSH, Email: "tester@foo.com", Keyring: allowedFile}
+
+	ok, err := VerifySSH(identity, binary, binary+SSHSignatureSuffix)
+	assert.Nil(t, err, "No error verifying ssh-keygen signature")
+	assert.True(t, ok, "ssh-keygen signature verifies")
+
+	err = os.WriteFile(binary, []byte(testFileContent()+"tampered"), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	ok, err = VerifySSH(identity, binary, binary+SSHSignatureSuffix)
+	assert.NotNil(t, err, "Tampered file fails verification")
+	assert.False(t, ok, "Tampered file doesn't verify")
+}
+
+func TestParseAllowedSigners(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatalf("Error converting public key: %s", err)
+	}
+
+	authorized := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(pub)))
+
+	signers, err := ParseAllowedSigners([]byte(fmt.Sprintf("tester@foo.com,*@bar.com namespaces=\"file,git\",valid-after=20200101 %s\n*@ca.com cert-authority %s\n", authorized, authorized)))
+	if err != nil {
+		t.Fatalf("Error parsing allowed signers: %s", err)
+	}
+
+	assert.Equal(t, 2, len(signers), "Certificate authorities are parsed")
+	assert.True(t, signers[1].CertAuthority, "Certificate authority is marked as such")
+	assert.False(t, signers[1].Allows(pub, "tester@ca.com", "file", time.Now()), "Certificate authority's own key can't sign")
+
+	signer := signers[0]
+
+	now, err := parseAllowedSignerTime("20300101")
+	if err != nil {
+		t.Fatalf("Error parsing time: %s", err)
+	}
+
+	before, err := parseAllowedSignerTime("20190101")
+	if err != nil {
+		t.Fatalf("Error parsing time: %s", err)
+	}
+
+	inputs := []struct {
+		name      string
+		principal string
+		namespace string
+		at        string
+		allowed   bool
+	}{
+		{"exact principal", "tester@foo.com", "file", "now", true},
+		{"wildcard principal", "anyone@bar.com", "git", "now", true},
+		{"any principal", "", "file", "now", true},
+		{"wrong principal", "tester@baz.com", "file", "now", false},
+		{"wrong namespace", "tester@foo.com", "email", "now", false},
+		{"not yet valid", "tester@foo.com", "file", "before", false},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			at := now
+			if tc.at == "before" {
+				at = before
+			}
+
+			assert.Equal(t, tc.allowed, signer.Allows(pub, tc.principal, tc.namespace, at), "Signer allowed")
+		})
+	}
+}
+
+// testSSHCertificate makes a user certificate for key, with the given principals, signed by ca, and valid until validBefore.
+func testSSHCertificate(t *testing.T, key ssh.PublicKey, ca ssh.Signer, principals []string, certType uint32, validBefore time.Time) (cert *ssh.Certificate) {
+	cert = &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           "tester",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+
+	err := cert.SignCert(rand.Reader, ca)
+	if err != nil {
+		t.Fatalf("Error signing certificate: %s", err)
+	}
+
+	return cert
+}
+
+// testSSHSigner makes an ed25519 ssh signer.
+func testSSHSigner(t *testing.T) (signer ssh.Signer) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	signer, err = ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Error making signer: %s", err)
+	}
+
+	return signer
+}
+
+func TestInspectSSHCertificate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testSSHSigner(t)
+	rogue := testSSHSigner(t)
+	key := testSSHSigner(t)
+
+	allowedFile := filepath.Join(dir, "allowed_signers")
+
+	err = os.WriteFile(allowedFile, []byte(fmt.Sprintf("*@foo.com cert-authority %s", ssh.MarshalAuthorizedKey(ca.PublicKey()))), 0644)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", allowedFile, err)
+	}
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	fingerprint := ssh.FingerprintSHA256(key.PublicKey())
+
+	inputs := []struct {
+		name       string
+		cert       *ssh.Certificate
+		email      string
+		keyring    string
+		identities []string
+		expired    bool
+		errs       bool
+	}{
+		{"certified", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@foo.com", "root"}, ssh.UserCert, tomorrow), "", allowedFile, []string{"tester@foo.com"}, false, false},
+		{"certified as email", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@foo.com", "other@foo.com"}, ssh.UserCert, tomorrow), "other@foo.com", allowedFile, []string{"other@foo.com"}, false, false},
+		{"not certified as email", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@foo.com"}, ssh.UserCert, tomorrow), "other@foo.com", allowedFile, nil, false, true},
+		{"expired", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@foo.com"}, ssh.UserCert, time.Now().Add(-time.Minute)), "", allowedFile, []string{"tester@foo.com"}, true, false},
+		{"self signed", testSSHCertificate(t, key.PublicKey(), rogue, []string{"tester@foo.com"}, ssh.UserCert, tomorrow), "", allowedFile, nil, false, true},
+		{"no allowed principals", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@bar.com"}, ssh.UserCert, tomorrow), "", allowedFile, nil, false, true},
+		{"no principals", testSSHCertificate(t, key.PublicKey(), ca, []string{}, ssh.UserCert, tomorrow), "", allowedFile, nil, false, true},
+		{"host certificate", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@foo.com"}, ssh.HostCert, tomorrow), "", allowedFile, nil, false, true},
+		{"no allowed signers", testSSHCertificate(t, key.PublicKey(), ca, []string{"tester@foo.com"}, ssh.UserCert, tomorrow), "", "", nil, false, true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			certSigner, err := ssh.NewCertSigner(tc.cert, key)
+			if err != nil {
+				t.Fatalf("Error making certificate signer: %s", err)
+			}
+
+			sig, err := SSHSign(certSigner, []byte(testFileContent()), SSHSignatureNamespace)
+			if err != nil {
+				t.Fatalf("Error signing: %s", err)
+			}
+
+			sigFile := binary + SSHSignatureSuffix
+
+			err = os.WriteFile(sigFile, sig.Armor(), 0644)
+			if err != nil {
+				t.Fatalf("Error writing %s: %s", sigFile, err)
+			}
+
+			identity := SigningIdentity{Program: SigningProgramSSH, Email: tc.email, Keyring: tc.keyring}
+
+			signer, err := InspectSSH(identity, binary, sigFile)
+			if tc.errs {
+				assert.NotNil(t, err, "Signer isn't trusted")
+				assert.Empty(t, signer.Identities, "No principals are claimed")
+				return
+			}
+
+			assert.Nil(t, err, "No error inspecting signature")
+			assert.Equal(t, fingerprint, signer.Fingerprint, "Fingerprint is the certified key's")
+			assert.Equal(t, tc.identities, signer.Identities, "Only certified principals are identities")
+			assert.Equal(t, tc.expired, signer.Expired, "Expiry meets expectations")
+		})
+	}
+}
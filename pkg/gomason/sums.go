@@ -0,0 +1,81 @@
+package gomason
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSha256SumsFilename is what the SHA256SUMS file is called locally if a name can't be gotten from its destination.
+const DefaultSha256SumsFilename = "SHA256SUMS"
+
+// NewSha256Sums makes a SHA256SUMS file, in the format of coreutils' sha256sum, covering every file handled so far.  Files are listed by the name they were built with, in order.
+func NewSha256Sums(results []ArtifactResult) (content []byte, err error) {
+	sums := make(map[string]string)
+	names := make([]string, 0)
+
+	for _, r := range results {
+		name := filepath.Base(r.File)
+
+		if _, ok := sums[name]; ok {
+			err = errors.New(fmt.Sprintf("more than one file is named %s, so they can't all be in a SHA256SUMS file", name))
+			return content, err
+		}
+
+		sum, err := FileSha256(r.File)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to calculate sha256 of %s", r.File)
+			return content, err
+		}
+
+		sums[name] = sum
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+
+	for _, name := range names {
+		// two spaces means the file was read in text mode, which is how sha256sum writes them on unix
+		buf.WriteString(fmt.Sprintf("%s  %s\n", sums[name], name))
+	}
+
+	content = buf.Bytes()
+
+	return content, err
+}
+
+// Sha256SumsTarget returns the publishing target for the SHA256SUMS file, with its destination filled in from the metadata, and what it should be called locally.
+func Sha256SumsTarget(meta Metadata, signed bool) (target PublishTarget, filename string, err error) {
+	target, filename, err = ReleaseFileTarget(meta, meta.PublishInfo.Sha256Sums, DefaultSha256SumsFilename, signed)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse sha256sums destination %s", meta.PublishInfo.Sha256Sums)
+		return target, filename, err
+	}
+
+	return target, filename, err
+}
+
+// PublishSha256Sums writes a SHA256SUMS file for the files handled so far into dir, signs it if we're signing, and uploads it, and its signature, to 'sha256sums' in the publishing section of the metadata.
+func (g *Gomason) PublishSha256Sums(meta Metadata, dir string, sign bool) (err error) {
+	target, filename, err := Sha256SumsTarget(meta, sign)
+	if err != nil {
+		return err
+	}
+
+	err = g.publishReleaseFile(meta, "SHA256SUMS", filepath.Join(dir, filename), target, sign, func() ([]byte, error) {
+		return NewSha256Sums(g.Results)
+	})
+
+	if err != nil || g.DryRun {
+		return err
+	}
+
+	fmt.Printf("Published SHA256SUMS to %s\n", target.Destination)
+
+	return err
+}
@@ -0,0 +1,181 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSha256Sums(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	otherDir := filepath.Join(dir, "other")
+
+	err = os.MkdirAll(otherDir, 0755)
+	if err != nil {
+		t.Fatalf("Error creating %s: %s", otherDir, err)
+	}
+
+	files := map[string]string{
+		filepath.Join(dir, "testproject_linux_amd64"):  testFileContent(),
+		filepath.Join(dir, "testproject_darwin_amd64"): testFileContent() + "darwin",
+		filepath.Join(dir, "testproject.sh"):           "#!/bin/sh\necho extra\n",
+		filepath.Join(otherDir, "testproject.sh"):      "#!/bin/sh\necho other extra\n",
+	}
+
+	for file, content := range files {
+		err = os.WriteFile(file, []byte(content), 0755)
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", file, err)
+		}
+	}
+
+	inputs := []struct {
+		name   string
+		files  []string
+		listed []string
+		errs   bool
+	}{
+		{
+			"binaries and extras",
+			[]string{"testproject_linux_amd64", "testproject.sh", "testproject_darwin_amd64"},
+			[]string{"testproject.sh", "testproject_darwin_amd64", "testproject_linux_amd64"},
+			false,
+		},
+		{
+			"same name twice",
+			[]string{"testproject.sh", "other/testproject.sh"},
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			results := make([]ArtifactResult, 0)
+
+			for _, f := range tc.files {
+				results = append(results, ArtifactResult{File: filepath.Join(dir, f)})
+			}
+
+			content, err := NewSha256Sums(results)
+			if tc.errs {
+				assert.NotNil(t, err, "Can't make SHA256SUMS")
+				return
+			}
+
+			assert.Nil(t, err, "No error making SHA256SUMS")
+
+			expected := ""
+
+			for _, name := range tc.listed {
+				sum, err := FileSha256(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("Error getting sha256 of %s: %s", name, err)
+				}
+
+				expected += fmt.Sprintf("%s  %s\n", sum, name)
+			}
+
+			assert.Equal(t, expected, string(content), "SHA256SUMS meets expectations")
+
+			sumsFile := filepath.Join(dir, DefaultSha256SumsFilename)
+
+			err = os.WriteFile(sumsFile, content, 0644)
+			if err != nil {
+				t.Fatalf("Error writing %s: %s", sumsFile, err)
+			}
+
+			// it's what sha256sum expects
+			cmd := exec.Command("sha256sum", "--check", "--strict", DefaultSha256SumsFilename)
+			cmd.Dir = dir
+
+			out, err := cmd.CombinedOutput()
+			assert.Nil(t, err, "sha256sum checks the file: %s", out)
+		})
+	}
+}
+
+func TestPublishSha256Sums(t *testing.T) {
+	lock := sync.Mutex{}
+	uploads := make(map[string][]byte)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		lock.Lock()
+		uploads[r.URL.Path] = body
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile, pubFile := writeTestMinisignKeys(t, dir, "")
+
+	binary := filepath.Join(dir, "testproject_linux_amd64")
+
+	err = os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	meta := testMetadataObj()
+	meta.Repository = fmt.Sprintf("%s/repo", server.URL)
+	meta.PublishInfo.Sha256Sums = "{{.Repository}}/testproject/{{.Version}}/SHA256SUMS"
+	meta.SignInfo = SignInfo{Program: SigningProgramMinisign, Keyring: pubFile}
+
+	g := Gomason{
+		Config:  UserConfig{Signing: UserSignInfo{KeyFile: keyFile}},
+		Results: []ArtifactResult{{File: binary}},
+	}
+
+	err = g.PublishSha256Sums(meta, dir, true)
+	if err != nil {
+		t.Fatalf("Error publishing SHA256SUMS: %s", err)
+	}
+
+	sums, ok := uploads["/repo/testproject/0.1.0/SHA256SUMS"]
+	if !ok {
+		t.Fatalf("SHA256SUMS was not uploaded")
+	}
+
+	_, ok = uploads["/repo/testproject/0.1.0/SHA256SUMS"+MinisignSignatureSuffix]
+	assert.True(t, ok, "Signature was uploaded")
+
+	sum, err := FileSha256(binary)
+	if err != nil {
+		t.Fatalf("Error getting sha256 of %s: %s", binary, err)
+	}
+
+	assert.Equal(t, fmt.Sprintf("%s  testproject_linux_amd64\n", sum), string(sums), "SHA256SUMS covers the release")
+
+	// the one signature verifies the whole release
+	sumsFile := filepath.Join(dir, DefaultSha256SumsFilename)
+
+	ok, err = VerifyBinary(sumsFile, meta)
+	assert.Nil(t, err, "No error verifying SHA256SUMS")
+	assert.True(t, ok, "SHA256SUMS signature verifies")
+}
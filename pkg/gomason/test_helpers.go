@@ -105,6 +105,11 @@ func testUserConfig() string {
 
 [signing]
   program = gpg
+  keyfile = /home/nikogura/.gnupg/secring.asc
+
+[signing.team]
+  program = minisign
+  email = releases@foo.com
 `
 }
 
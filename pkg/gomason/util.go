@@ -4,17 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/sirupsen/logrus"
 	"io"
-	"log"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/pkg/errors"
@@ -123,6 +122,40 @@ func (g *Gomason) GetCredentials(meta Metadata) (username, password string, err
 	return username, password, err
 }
 
+// CredentialSources describes where GetCredentials would get the username and password from, without running any of the configured functions.  Precedence is the same as GetCredentials: ~/.gomason over metadata, and functions over literal values.
+func (g *Gomason) CredentialSources(meta Metadata) (usernameSource, passwordSource string) {
+	usernameSource = "none"
+	passwordSource = "none"
+
+	if meta.PublishInfo.UsernameFunc != "" {
+		usernameSource = fmt.Sprintf("usernamefunc in %s", METADATA_FILENAME)
+	} else if meta.PublishInfo.Username != "" {
+		usernameSource = fmt.Sprintf("username in %s", METADATA_FILENAME)
+	}
+
+	if meta.PublishInfo.PasswordFunc != "" {
+		passwordSource = fmt.Sprintf("passwordfunc in %s", METADATA_FILENAME)
+	} else if meta.PublishInfo.Password != "" {
+		passwordSource = fmt.Sprintf("password in %s", METADATA_FILENAME)
+	}
+
+	config := g.Config
+
+	if config.User.UsernameFunc != "" {
+		usernameSource = "usernamefunc in ~/.gomason"
+	} else if config.User.Username != "" {
+		usernameSource = "username in ~/.gomason"
+	}
+
+	if config.User.PasswordFunc != "" {
+		passwordSource = "passwordfunc in ~/.gomason"
+	} else if config.User.Password != "" {
+		passwordSource = "password in ~/.gomason"
+	}
+
+	return usernameSource, passwordSource
+}
+
 // GetFunc runs a shell command that is a getter function.  This could certainly be dangerous, so be careful how you use it.
 func GetFunc(shellCommand string) (result string, err error) {
 	cmd := exec.Command("sh", "-c", shellCommand)
@@ -208,56 +241,115 @@ func DirsForURL(uri string) (dirs []string, err error) {
 
 // DefaultSession creates a default AWS session from local config path.  Hooks directly into credentials if present, or Credentials Provider if configured.
 func DefaultSession() (awssession *session.Session, err error) {
-	if os.Getenv(AWS_ID_ENV_VAR) == "" && os.Getenv(AWS_SECRET_ENV_VAR) == "" {
-		_ = os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
-	}
-
-	awssession, err = session.NewSession()
-	if err != nil {
-		log.Fatalf("Failed to create aws session")
-	}
-
-	// For some reason this doesn't get picked up automatically, but we'll set it if it's present in the environment.
-	if os.Getenv(AWS_REGION_ENV_VAR) != "" {
-		awssession.Config.Region = aws.String(os.Getenv(AWS_REGION_ENV_VAR))
-	}
-
-	return awssession, err
+	return NewAWSSession(AWSConfig{})
 }
 
 // S3Meta a struct for holding metadata for S3 Objects.  There's probably already a struct that holds this, but this is all I need.
 type S3Meta struct {
-	Bucket string
-	Region string
-	Key    string
-	Url    string
+	Bucket    string
+	Region    string
+	Key       string
+	Url       string
+	PathStyle bool
 }
 
-// S3Url returns true, and a metadata struct if the url given appears to be in s3
-func S3Url(url string) (ok bool, meta S3Meta) {
-	// Check to see if it's an s3 URL.
-	s3Url := regexp.MustCompile(`https?://(.*)\.s3\.(.*)\.amazonaws.com/(.*)`)
+// s3PathStyleHost matches the hosts of path style S3 urls, i.e. https://s3.<region>.amazonaws.com/<bucket>/<key>
+var s3PathStyleHost = regexp.MustCompile(`^s3(?:[.-]([a-z0-9-]+))?\.amazonaws\.com$`)
+
+// s3VirtualHost matches the hosts of virtual host style S3 urls, i.e. https://<bucket>.s3.<region>.amazonaws.com/<key>
+var s3VirtualHost = regexp.MustCompile(`^(.+)\.s3(?:[.-]([a-z0-9-]+))?\.amazonaws\.com$`)
 
-	logrus.Debugf("testing %s", url)
-	matches := s3Url.FindAllStringSubmatch(url, -1)
+// S3Url returns true, and a metadata struct if the url given appears to be in s3.  That's 's3://<bucket>/<key>', or an amazonaws.com url, be it virtual host style, 'https://<bucket>.s3.<region>.amazonaws.com/<key>', or path style, 'https://s3.<region>.amazonaws.com/<bucket>/<key>'.  The region is blank for 's3://' urls.
+func S3Url(s3url string) (ok bool, meta S3Meta) {
+	logrus.Debugf("testing %s", s3url)
 
-	if len(matches) == 0 {
+	u, err := url.Parse(s3url)
+	if err != nil {
 		return ok, meta
 	}
 
-	match := matches[0]
+	meta.Url = s3url
+
+	switch strings.ToLower(u.Scheme) {
+	case "s3":
+		meta.Bucket = u.Host
+		meta.Key = strings.TrimPrefix(u.Path, "/")
+
+	case "http", "https":
+		host := strings.ToLower(u.Hostname())
+
+		if match := s3PathStyleHost.FindStringSubmatch(host); match != nil {
+			parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
 
-	if len(match) == 4 {
-		meta = S3Meta{
-			Bucket: match[1],
-			Region: match[2],
-			Key:    match[3],
-			Url:    url,
+			meta.Bucket = parts[0]
+			meta.Region = match[1]
+			meta.PathStyle = true
+
+			if len(parts) == 2 {
+				meta.Key = parts[1]
+			}
+		} else if match := s3VirtualHost.FindStringSubmatch(host); match != nil {
+			meta.Bucket = match[1]
+			meta.Region = match[2]
+			meta.Key = strings.TrimPrefix(u.Path, "/")
 		}
+	}
 
-		ok = true
-		return ok, meta
+	if meta.Bucket == "" {
+		return false, S3Meta{}
 	}
 
+	ok = true
+
 	return ok, meta
 }
+
+// prefixWriter is an io.Writer that prefixes every line written to it.  Partial lines are held until they're complete so that concurrent writers sharing a lock don't interleave their output mid-line.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	lock   *sync.Mutex
+	buf    []byte
+}
+
+// Write writes all complete lines in p, each with the prefix, and holds on to any trailing partial line.
+func (w *prefixWriter) Write(p []byte) (n int, err error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		err = w.writeLine(w.buf[:i+1])
+		if err != nil {
+			return n, err
+		}
+
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), err
+}
+
+// Flush writes out any partial line that's being held.
+func (w *prefixWriter) Flush() (err error) {
+	if len(w.buf) == 0 {
+		return err
+	}
+
+	err = w.writeLine(append(w.buf, '\n'))
+	w.buf = nil
+
+	return err
+}
+
+func (w *prefixWriter) writeLine(line []byte) (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_, err = w.out.Write(append([]byte(w.prefix), line...))
+
+	return err
+}
@@ -1,10 +1,12 @@
 package gomason
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"log"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -113,43 +115,78 @@ func TestReadMetadata(t *testing.T) {
 //	}
 //}
 //
-//func TestS3Url(t *testing.T) {
-//	inputs := []struct {
-//		url    string
-//		result bool
-//		bucket string
-//		region string
-//		key    string
-//	}{
-//		{
-//			"https://www.nikogura.com",
-//			false,
-//			"",
-//			"",
-//			"",
-//		},
-//		{
-//			"https://dbt-tools.s3.us-east-1.amazonaws.com/catalog/1.2.3/linux/amd64/catalog",
-//			true,
-//			"dbt-tools",
-//			"us-east-1",
-//			"catalog/1.2.3/linux/amd64/catalog",
-//		},
-//	}
-//
-//	for _, tc := range inputs {
-//		t.Run(tc.url, func(t *testing.T) {
-//			fmt.Printf("Testing %s\n", tc.url)
-//			ok, meta := S3Url(tc.url)
-//
-//			assert.True(t, ok == tc.result, fmt.Sprintf("%s does not meet expectations", tc.url))
-//			assert.True(t, tc.bucket == meta.Bucket, fmt.Sprintf("Bucket %q doesn't look right", meta.Bucket))
-//			assert.True(t, tc.region == meta.Region, fmt.Sprintf("Region %q doesn't look right.", meta.Region))
-//			assert.True(t, tc.key == meta.Key, fmt.Sprintf("Key %q doesn't look right.", meta.Key))
-//		})
-//	}
-//}
-//
+func TestS3Url(t *testing.T) {
+	inputs := []struct {
+		url       string
+		result    bool
+		bucket    string
+		region    string
+		key       string
+		pathStyle bool
+	}{
+		{
+			"https://www.nikogura.com",
+			false,
+			"",
+			"",
+			"",
+			false,
+		},
+		{
+			"https://dbt-tools.s3.us-east-1.amazonaws.com/catalog/1.2.3/linux/amd64/catalog",
+			true,
+			"dbt-tools",
+			"us-east-1",
+			"catalog/1.2.3/linux/amd64/catalog",
+			false,
+		},
+		{
+			"https://dbt-tools.s3-us-west-2.amazonaws.com/catalog/1.2.3/linux/amd64/catalog",
+			true,
+			"dbt-tools",
+			"us-west-2",
+			"catalog/1.2.3/linux/amd64/catalog",
+			false,
+		},
+		{
+			"https://s3.eu-west-1.amazonaws.com/dbt-tools/catalog/1.2.3/linux/amd64/catalog",
+			true,
+			"dbt-tools",
+			"eu-west-1",
+			"catalog/1.2.3/linux/amd64/catalog",
+			true,
+		},
+		{
+			"s3://dbt-tools/catalog/1.2.3/linux/amd64/catalog",
+			true,
+			"dbt-tools",
+			"",
+			"catalog/1.2.3/linux/amd64/catalog",
+			false,
+		},
+		{
+			"s3:///catalog",
+			false,
+			"",
+			"",
+			"",
+			false,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.url, func(t *testing.T) {
+			ok, meta := S3Url(tc.url)
+
+			assert.True(t, ok == tc.result, fmt.Sprintf("%s does not meet expectations", tc.url))
+			assert.True(t, tc.bucket == meta.Bucket, fmt.Sprintf("Bucket %q doesn't look right", meta.Bucket))
+			assert.True(t, tc.region == meta.Region, fmt.Sprintf("Region %q doesn't look right.", meta.Region))
+			assert.True(t, tc.key == meta.Key, fmt.Sprintf("Key %q doesn't look right.", meta.Key))
+			assert.True(t, tc.pathStyle == meta.PathStyle, fmt.Sprintf("Path style %t doesn't look right.", meta.PathStyle))
+		})
+	}
+}
+
 //func TestDirsForURL(t *testing.T) {
 //	inputs := []struct {
 //		name   string
@@ -190,3 +227,87 @@ func TestReadMetadata(t *testing.T) {
 //		})
 //	}
 //}
+
+func TestCredentialSources(t *testing.T) {
+	inputs := []struct {
+		name     string
+		config   UserConfig
+		meta     Metadata
+		username string
+		password string
+	}{
+		{
+			"none",
+			UserConfig{},
+			Metadata{},
+			"none",
+			"none",
+		},
+		{
+			"metadata",
+			UserConfig{},
+			Metadata{
+				PublishInfo: PublishInfo{
+					Username:     "foo",
+					PasswordFunc: "echo 'bar'",
+				},
+			},
+			"username in metadata.json",
+			"passwordfunc in metadata.json",
+		},
+		{
+			"user config overrides metadata",
+			UserConfig{
+				User: UserInfo{
+					UsernameFunc: "echo 'foo'",
+					Password:     "bar",
+				},
+			},
+			Metadata{
+				PublishInfo: PublishInfo{
+					Username:     "foo",
+					PasswordFunc: "echo 'bar'",
+				},
+			},
+			"usernamefunc in ~/.gomason",
+			"password in ~/.gomason",
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			g := Gomason{Config: tc.config}
+
+			username, password := g.CredentialSources(tc.meta)
+
+			assert.Equal(t, tc.username, username, "Username source meets expectations")
+			assert.Equal(t, tc.password, password, "Password source meets expectations")
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	lock := &sync.Mutex{}
+
+	w := &prefixWriter{prefix: "[linux/amd64] ", out: buf, lock: lock}
+
+	_, err := w.Write([]byte("foo\nba"))
+	if err != nil {
+		t.Errorf("Error writing: %s", err)
+	}
+
+	assert.Equal(t, "[linux/amd64] foo\n", buf.String(), "Partial lines are held")
+
+	_, err = w.Write([]byte("r\nbaz"))
+	if err != nil {
+		t.Errorf("Error writing: %s", err)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		t.Errorf("Error flushing: %s", err)
+	}
+
+	assert.Equal(t, "[linux/amd64] foo\n[linux/amd64] bar\n[linux/amd64] baz\n", buf.String(), "Prefixed output meets expectations")
+}
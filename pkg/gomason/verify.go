@@ -0,0 +1,339 @@
+package gomason
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ChecksumTypes are the types of checksum files gomason publishes, and verifies.
+var ChecksumTypes = []string{"md5", "sha1", "sha256"}
+
+// ChecksumMismatchError means a file doesn't match its published checksum.
+type ChecksumMismatchError struct {
+	File     string
+	SumType  string
+	Expected string
+	Actual   string
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: %s of %s is %s, but %s was expected", e.SumType, e.File, e.Actual, e.Expected)
+}
+
+// BadSignatureError means a file's signature was made by a key we know, but doesn't match the file.  Either the file or the signature has been tampered with.
+type BadSignatureError struct {
+	File   string
+	Reason string
+}
+
+func (e BadSignatureError) Error() string {
+	return fmt.Sprintf("bad signature on %s: %s", e.File, e.Reason)
+}
+
+// UnknownSignerError means a file's signature was made by a key that isn't in the keyring, or isn't allowed to sign.
+type UnknownSignerError struct {
+	File   string
+	Signer string
+}
+
+func (e UnknownSignerError) Error() string {
+	return fmt.Sprintf("unknown signer: %s was signed by %s", e.File, e.Signer)
+}
+
+// VerifyResult is what was checked when verifying a file.
+type VerifyResult struct {
+	File       string
+	Checksums  []string
+	Signatures []string
+}
+
+// ReadChecksumFile reads a checksum from a checksum file.  Both bare checksums, as gomason publishes, and the '<checksum>  <filename>' format of sha256sum and friends are understood.
+func ReadChecksumFile(checksumFile string) (checksum string, err error) {
+	content, err := os.ReadFile(checksumFile)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read %s", checksumFile)
+		return checksum, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		err = errors.New(fmt.Sprintf("%s is empty", checksumFile))
+		return checksum, err
+	}
+
+	checksum = strings.ToLower(fields[0])
+
+	return checksum, err
+}
+
+// VerifyChecksum checks that the sumType checksum of file is the expected one.
+func VerifyChecksum(file string, sumType string, expected string) (err error) {
+	var actual string
+
+	switch sumType {
+	case "md5":
+		actual, err = FileMd5(file)
+	case "sha1":
+		actual, err = FileSha1(file)
+	case "sha256":
+		actual, err = FileSha256(file)
+	default:
+		err = errors.New(fmt.Sprintf("unsupported checksum type %q", sumType))
+		return err
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to calculate %s of %s", sumType, file)
+		return err
+	}
+
+	if actual != strings.ToLower(expected) {
+		err = ChecksumMismatchError{File: file, SumType: sumType, Expected: expected, Actual: actual}
+		return err
+	}
+
+	return err
+}
+
+// VerifyFile checks a local file against whatever checksum files sit next to it, and its signatures, made by each of the signing identities in the metadata file.  If signed is true, a missing signature is an error.
+func VerifyFile(meta Metadata, file string, signed bool) (result VerifyResult, err error) {
+	result = VerifyResult{
+		File:       file,
+		Checksums:  make([]string, 0),
+		Signatures: make([]string, 0),
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		err = errors.Wrapf(err, "failed to stat %s", file)
+		return result, err
+	}
+
+	for _, sumType := range ChecksumTypes {
+		checksumFile := fmt.Sprintf("%s.%s", file, sumType)
+
+		if _, statErr := os.Stat(checksumFile); os.IsNotExist(statErr) {
+			continue
+		}
+
+		expected, err := ReadChecksumFile(checksumFile)
+		if err != nil {
+			return result, err
+		}
+
+		err = VerifyChecksum(file, sumType, expected)
+		if err != nil {
+			return result, err
+		}
+
+		logrus.Debugf("%s of %s matches", sumType, file)
+
+		result.Checksums = append(result.Checksums, sumType)
+	}
+
+	identities, err := meta.SigningIdentities()
+	if err != nil {
+		return result, err
+	}
+
+	for _, identity := range identities {
+		sigFile := identity.SignatureFile(file)
+
+		if _, statErr := os.Stat(sigFile); os.IsNotExist(statErr) {
+			if signed {
+				err = errors.New(fmt.Sprintf("no signature for %s by %s: %s does not exist", file, identity, sigFile))
+				return result, err
+			}
+
+			continue
+		}
+
+		signer, err := GetSigner(identity.Program)
+		if err != nil {
+			return result, err
+		}
+
+		ok, err := signer.Verify(identity, meta, file, sigFile)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to verify with %q", identity.Program)
+			return result, err
+		}
+
+		if !ok {
+			err = BadSignatureError{File: file, Reason: "signature did not verify"}
+			return result, err
+		}
+
+		logrus.Debugf("Good signature on %s by %s", file, identity)
+
+		result.Signatures = append(result.Signatures, sigFile)
+	}
+
+	if len(result.Checksums) == 0 && len(result.Signatures) == 0 {
+		err = errors.New(fmt.Sprintf("nothing to verify %s against: no signature or checksum files", file))
+		return result, err
+	}
+
+	return result, err
+}
+
+// VerifyTarget downloads the published artifact for the named publishing target, along with its signature and checksums if the target publishes them, into dir, and verifies them all.
+func (g *Gomason) VerifyTarget(meta Metadata, targetName string, dir string) (result VerifyResult, err error) {
+	target, ok := meta.PublishInfo.TargetsMap[targetName]
+	if !ok {
+		err = errors.New(fmt.Sprintf("no publishing target named %s in %s", targetName, METADATA_FILENAME))
+		return result, err
+	}
+
+	parsedDestination, err := ParseTemplateForMetadata(target.Destination, meta)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse destination url %s", target.Destination)
+		return result, err
+	}
+
+	file := filepath.Join(dir, filepath.Base(target.Source))
+	awsConfig := meta.AWSConfigFor(target)
+
+	// what's downloaded, by the suffix it's published with
+	suffixes := []string{""}
+
+	if target.Signature {
+		identities, err := meta.SigningIdentities()
+		if err != nil {
+			return result, err
+		}
+
+		for _, identity := range identities {
+			suffixes = append(suffixes, identity.SignatureSuffix())
+		}
+	}
+
+	if target.Checksums {
+		for _, sumType := range ChecksumTypes {
+			suffixes = append(suffixes, "."+sumType)
+		}
+	}
+
+	username, password, err := g.GetCredentials(meta)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get credentials")
+		return result, err
+	}
+
+	publisher, err := GetPublisherWithAWS(parsedDestination, awsConfig)
+	if err != nil {
+		return result, err
+	}
+
+	// some publishers keep the signatures and checksums with the file, rather than next to it
+	if getter, ok := publisher.(BundleGetter); ok {
+		var platform *BuildPlatform
+
+		if p, ok := meta.BuildPlatformFor(target.Source); ok {
+			platform = &p
+		}
+
+		_, err = meta.PublishInfo.Retry.Retry(fmt.Sprintf("download of %s", parsedDestination), func() error {
+			return DownloadBundle(getter, parsedDestination, platform, file, suffixes, username, password)
+		})
+
+		if err != nil {
+			err = errors.Wrapf(err, "failed to download %s", parsedDestination)
+			return result, err
+		}
+
+		return VerifyFile(meta, file, target.Signature)
+	}
+
+	for _, suffix := range suffixes {
+		src := parsedDestination + suffix
+		dst := file + suffix
+
+		_, err = meta.PublishInfo.Retry.Retry(fmt.Sprintf("download of %s", src), func() error {
+			return DownloadWithAWS(src, dst, awsConfig, username, password)
+		})
+
+		if err != nil {
+			err = errors.Wrapf(err, "failed to download %s", src)
+			return result, err
+		}
+	}
+
+	return VerifyFile(meta, file, target.Signature)
+}
+
+// DownloadBundle fetches a file published to url by a BundlePublisher into the file dst, along with whatever was published with it with the given suffixes, which go next to it.
+func DownloadBundle(getter BundleGetter, url string, platform *BuildPlatform, dst string, suffixes []string, username string, password string) (err error) {
+	outs := make(map[string]io.Writer)
+
+	for _, suffix := range suffixes {
+		out, err := os.Create(dst + suffix)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create %s", dst+suffix)
+			return err
+		}
+
+		defer out.Close()
+
+		outs[suffix] = out
+	}
+
+	logrus.Debugf("Downloading %s to %s", url, dst)
+
+	return getter.GetBundle(url, platform, outs, username, password)
+}
+
+// Download fetches url into the file dst, with whichever Publisher handles the url.
+func Download(url string, dst string, username string, password string) (err error) {
+	return DownloadWithAWS(url, dst, AWSConfig{}, username, password)
+}
+
+// DownloadWithAWS fetches url into the file dst, with whichever Publisher handles the url, and the given AWS settings.
+func DownloadWithAWS(url string, dst string, config AWSConfig, username string, password string) (err error) {
+	publisher, err := GetPublisherWithAWS(url, config)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create %s", dst)
+		return err
+	}
+
+	defer out.Close()
+
+	logrus.Debugf("Downloading %s to %s", url, dst)
+
+	return publisher.Get(url, out, username, password)
+}
+
+// VerificationFailure digs the ChecksumMismatchError, BadSignatureError, UnknownSignerError or SignerNotAllowedError out of an error returned by verification, so that what actually failed can be reported without the context wrapped around it.  Returns nil if err isn't one of those.
+func VerificationFailure(err error) (failure error) {
+	var mismatch ChecksumMismatchError
+	if errors.As(err, &mismatch) {
+		return mismatch
+	}
+
+	var badSig BadSignatureError
+	if errors.As(err, &badSig) {
+		return badSig
+	}
+
+	var unknown UnknownSignerError
+	if errors.As(err, &unknown) {
+		return unknown
+	}
+
+	var notAllowed SignerNotAllowedError
+	if errors.As(err, &notAllowed) {
+		return notAllowed
+	}
+
+	return failure
+}
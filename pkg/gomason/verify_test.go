@@ -0,0 +1,238 @@
+package gomason
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestRelease writes a binary into dir, signed with minisign, with checksum files next to it, the way publish would upload them.  Returns the metadata to verify it with.
+func writeTestRelease(t *testing.T, dir string, keyDir string, binary string) (meta Metadata) {
+	keyFile, pubFile := writeTestMinisignKeys(t, keyDir, "")
+
+	err := os.WriteFile(binary, []byte(testFileContent()), 0755)
+	if err != nil {
+		t.Fatalf("Error writing %s: %s", binary, err)
+	}
+
+	meta = testMetadataObj()
+	meta.SignInfo = SignInfo{Program: SigningProgramMinisign, Keyring: pubFile}
+
+	g := Gomason{Config: UserConfig{Signing: UserSignInfo{KeyFile: keyFile}}}
+
+	err = g.SignBinary(meta, binary)
+	if err != nil {
+		t.Fatalf("Error signing %s: %s", binary, err)
+	}
+
+	writeTestChecksums(t, binary)
+
+	return meta
+}
+
+// writeTestChecksums writes .md5, .sha1 and .sha256 files for file.
+func writeTestChecksums(t *testing.T, file string) {
+	sums := make(map[string]string)
+
+	var err error
+
+	sums["md5"], sums["sha1"], sums["sha256"], err = AllChecksumsForFile(file)
+	if err != nil {
+		t.Fatalf("Error calculating checksums for %s: %s", file, err)
+	}
+
+	for sumType, sum := range sums {
+		err = os.WriteFile(fmt.Sprintf("%s.%s", file, sumType), []byte(sum), 0644)
+		if err != nil {
+			t.Fatalf("Error writing %s checksum: %s", sumType, err)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	inputs := []struct {
+		name      string
+		mutate    func(t *testing.T, dir string, binary string, meta *Metadata)
+		checksums int
+		signed    bool
+		failure   error
+		errs      bool
+	}{
+		{
+			"good",
+			func(t *testing.T, dir string, binary string, meta *Metadata) {},
+			3,
+			true,
+			nil,
+			false,
+		},
+		{
+			"checksum mismatch",
+			func(t *testing.T, dir string, binary string, meta *Metadata) {
+				_ = os.WriteFile(binary+".sha256", []byte("0000000000000000000000000000000000000000000000000000000000000000  testproject_linux_amd64\n"), 0644)
+			},
+			0,
+			true,
+			ChecksumMismatchError{},
+			true,
+		},
+		{
+			"bad signature",
+			func(t *testing.T, dir string, binary string, meta *Metadata) {
+				_ = os.WriteFile(binary, []byte(testFileContent()+"tampered"), 0755)
+				writeTestChecksums(t, binary)
+			},
+			3,
+			true,
+			BadSignatureError{},
+			true,
+		},
+		{
+			"unknown signer",
+			func(t *testing.T, dir string, binary string, meta *Metadata) {
+				otherDir := filepath.Join(dir, "other")
+				_ = os.MkdirAll(otherDir, 0755)
+				_, pubFile := writeTestMinisignKeys(t, otherDir, "")
+				meta.SignInfo.Keyring = pubFile
+			},
+			3,
+			true,
+			UnknownSignerError{},
+			true,
+		},
+		{
+			"missing signature",
+			func(t *testing.T, dir string, binary string, meta *Metadata) {
+				_ = os.Remove(binary + MinisignSignatureSuffix)
+			},
+			3,
+			true,
+			nil,
+			true,
+		},
+		{
+			"unsigned, checksums only",
+			func(t *testing.T, dir string, binary string, meta *Metadata) {
+				_ = os.Remove(binary + MinisignSignatureSuffix)
+			},
+			3,
+			false,
+			nil,
+			false,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "gomason")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			binary := filepath.Join(dir, "testproject_linux_amd64")
+
+			meta := writeTestRelease(t, dir, dir, binary)
+
+			tc.mutate(t, dir, binary, &meta)
+
+			result, err := VerifyFile(meta, binary, tc.signed)
+			if tc.errs {
+				assert.NotNil(t, err, "Verification fails")
+				assert.IsType(t, tc.failure, VerificationFailure(err), "Failure is reported as what it is")
+				return
+			}
+
+			assert.Nil(t, err, "No error verifying")
+			assert.Equal(t, tc.checksums, len(result.Checksums), "Checksums verified")
+			assert.Equal(t, tc.signed, len(result.Signatures) == 1, "Signature verified")
+		})
+	}
+}
+
+func TestVerifyTarget(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// published files live where the target's destination says, with the binary named 'testproject'
+	releaseDir := filepath.Join(dir, "repo", "testproject", "0.1.0", "linux", "amd64")
+
+	err = os.MkdirAll(releaseDir, 0755)
+	if err != nil {
+		t.Fatalf("Error creating %s: %s", releaseDir, err)
+	}
+
+	meta := writeTestRelease(t, dir, dir, filepath.Join(releaseDir, "testproject"))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join(dir, "repo"))))
+	defer server.Close()
+
+	meta.Repository = server.URL
+	meta.PublishInfo.Retry = RetryPolicy{Attempts: 1}
+
+	inputs := []struct {
+		name    string
+		target  string
+		version string
+		errs    bool
+	}{
+		{"good", "testproject_linux_amd64", "0.1.0", false},
+		{"unknown target", "testproject_darwin_amd64", "0.1.0", true},
+		{"missing version", "testproject_linux_amd64", "0.2.0", true},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			downloadDir, err := os.MkdirTemp("", "gomason")
+			if err != nil {
+				t.Fatalf("Error creating temp dir: %s", err)
+			}
+			defer os.RemoveAll(downloadDir)
+
+			g := Gomason{}
+
+			m := meta
+			m.Version = tc.version
+
+			result, err := g.VerifyTarget(m, tc.target, downloadDir)
+			if tc.errs {
+				assert.NotNil(t, err, "Verification fails")
+				return
+			}
+
+			assert.Nil(t, err, "No error verifying")
+			assert.Equal(t, filepath.Join(downloadDir, tc.target), result.File, "Downloaded file verified")
+			assert.Equal(t, ChecksumTypes, result.Checksums, "Checksums verified")
+			assert.Equal(t, []string{filepath.Join(downloadDir, tc.target) + MinisignSignatureSuffix}, result.Signatures, "Signature verified")
+		})
+	}
+}
+
+func TestGPGStatusError(t *testing.T) {
+	runErr := fmt.Errorf("exit status 2")
+
+	inputs := []struct {
+		name    string
+		status  string
+		failure error
+	}{
+		{"bad signature", "[GNUPG:] NEWSIG t@x.com\n[GNUPG:] BADSIG 3AFD0D5E8DC71BED t@x.com\n", BadSignatureError{}},
+		{"unknown signer", "[GNUPG:] NEWSIG t@x.com\n[GNUPG:] ERRSIG 3AFD0D5E8DC71BED 22 8 00 1792221938 9\n[GNUPG:] NO_PUBKEY 3AFD0D5E8DC71BED\n", UnknownSignerError{}},
+		{"other", "", runErr},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := GPGStatusError("testproject", tc.status, runErr)
+			assert.IsType(t, tc.failure, err, "Error is reported as what it is")
+		})
+	}
+}
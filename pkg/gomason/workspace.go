@@ -0,0 +1,71 @@
+package gomason
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Workspace is the root directory in which gomason does its work.  By default it's an ephemeral temp dir that's removed when gomason is done.  If a directory is specified, it's persistent, and will be reused as a warm workspace on subsequent runs.
+type Workspace struct {
+	Dir           string
+	Persistent    bool
+	KeepOnFailure bool
+}
+
+// NewWorkspace creates a Workspace.  If dir is empty, a temp dir will be created, and removed on cleanup unless keepOnFailure is set and something failed.  If dir is not empty, it's created if necessary, and never removed.
+func NewWorkspace(dir string, keepOnFailure bool) (ws *Workspace, err error) {
+	ws = &Workspace{
+		KeepOnFailure: keepOnFailure,
+	}
+
+	if dir == "" {
+		ws.Dir, err = os.MkdirTemp("", "gomason")
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create temp dir")
+			return ws, err
+		}
+
+		logrus.Debugf("Created ephemeral workspace %s", ws.Dir)
+
+		return ws, err
+	}
+
+	// we change directories a lot.  Relative paths won't do.
+	ws.Dir, err = filepath.Abs(dir)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to get absolute path of %s", dir)
+		return ws, err
+	}
+
+	ws.Persistent = true
+
+	err = os.MkdirAll(ws.Dir, 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed creating workspace %s", ws.Dir)
+		return ws, err
+	}
+
+	logrus.Debugf("Using persistent workspace %s", ws.Dir)
+
+	return ws, err
+}
+
+// Cleanup removes the workspace unless it's persistent, or something failed and we were asked to keep it around for inspection.  Returns true if the workspace was kept.
+func (w *Workspace) Cleanup(failed bool) (kept bool, err error) {
+	if w.Persistent || (failed && w.KeepOnFailure) {
+		kept = true
+		return kept, err
+	}
+
+	logrus.Debugf("Removing workspace %s", w.Dir)
+
+	err = os.RemoveAll(w.Dir)
+	if err != nil {
+		err = errors.Wrapf(err, "failed removing workspace %s", w.Dir)
+	}
+
+	return kept, err
+}
@@ -0,0 +1,99 @@
+package gomason
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspace(t *testing.T) {
+	persistentDir, err := os.MkdirTemp("", "gomason")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(persistentDir)
+
+	inputs := []struct {
+		name          string
+		dir           string
+		keepOnFailure bool
+		failed        bool
+		kept          bool
+	}{
+		{
+			"ephemeral success",
+			"",
+			false,
+			false,
+			false,
+		},
+		{
+			"ephemeral failure",
+			"",
+			false,
+			true,
+			false,
+		},
+		{
+			"ephemeral keep on failure",
+			"",
+			true,
+			true,
+			true,
+		},
+		{
+			"ephemeral keep on failure succeeded",
+			"",
+			true,
+			false,
+			false,
+		},
+		{
+			"persistent",
+			filepath.Join(persistentDir, "work"),
+			false,
+			false,
+			true,
+		},
+		{
+			"persistent reused",
+			filepath.Join(persistentDir, "work"),
+			false,
+			true,
+			true,
+		},
+	}
+
+	for _, tc := range inputs {
+		t.Run(tc.name, func(t *testing.T) {
+			ws, err := NewWorkspace(tc.dir, tc.keepOnFailure)
+			if err != nil {
+				t.Fatalf("Error creating workspace: %s", err)
+			}
+
+			if tc.dir != "" {
+				assert.Equal(t, tc.dir, ws.Dir, "Workspace is where we asked for it")
+			}
+
+			if _, err := os.Stat(ws.Dir); os.IsNotExist(err) {
+				t.Errorf("Workspace %s not created", ws.Dir)
+			}
+
+			kept, err := ws.Cleanup(tc.failed)
+			if err != nil {
+				t.Errorf("Error cleaning up workspace: %s", err)
+			}
+
+			assert.Equal(t, tc.kept, kept, "Workspace retention meets expectations")
+
+			_, err = os.Stat(ws.Dir)
+			assert.Equal(t, tc.kept, err == nil, "Workspace existence meets expectations")
+
+			if kept && !ws.Persistent {
+				_ = os.RemoveAll(ws.Dir)
+			}
+		})
+	}
+}